@@ -1,8 +1,10 @@
 package main
 
 import (
+	"bufio"
 	"flag"
 	"fmt"
+	"io"
 	"os"
 	"path/filepath"
 	"strings"
@@ -10,6 +12,8 @@ import (
 	"github.com/ape902/ansible-go/pkg/config"
 	"github.com/ape902/ansible-go/pkg/executor"
 	"github.com/ape902/ansible-go/pkg/logger"
+	"github.com/ape902/ansible-go/pkg/vars"
+	"golang.org/x/term"
 )
 
 // 项目模板定义
@@ -23,10 +27,21 @@ ssh:
   password: ""                # 密码认证（留空则使用密钥认证）
   key_file: "~/.ssh/id_rsa"   # 密钥文件路径
   key_password: ""            # 密钥密码（如果密钥有密码保护）
+  host_key_checking: strict   # 主机密钥检查: strict(拒绝未知密钥), tofu(首次连接时记录), off(不检查)
+  known_hosts_file: "known_hosts"  # 项目级known_hosts文件，可用 ansible-go known-hosts 预先记录
+  use_key_auth: true          # 优先使用密钥认证，失败后回退到密码认证
+  # identity_files:           # 额外的私钥文件，按顺序尝试
+  #   - "~/.ssh/id_ed25519"
   # 高级选项
   timeout: 10                # 连接超时时间（秒）
   max_parallel: 5            # 最大并行执行数
 
+# 提权配置，在play或任务中设置 become: true 时生效
+become:
+  method: sudo                # 提权方式: sudo, su, doas
+  user: root                  # 提权后的用户
+  password: ""                # 提权密码，可使用 ansible-go encrypt 生成的加密值，或执行时使用 -ask-become-pass 输入
+
 # 主机清单配置
 inventory:
   # 简化格式 - 直接使用主机名列表
@@ -84,7 +99,7 @@ tasks:
       module: "command"
       args:
         cmd: "apt-get install -y nginx curl wget"
-        sudo: true
+      become: true
       # 可以添加标签作为变量
       vars:
         tags: ["setup", "packages"]
@@ -232,6 +247,18 @@ type CommandFlags struct {
 	Verbose    bool
 	Parallel   int
 	Tags       string
+	EventLog   string
+	RetryFile  string
+	AuditDir   string
+
+	IgnoreUnreachable bool
+
+	// 提权和vault参数
+	AskBecomePass     bool
+	VaultPasswordFile string
+
+	// known-hosts命令参数
+	ReplaceHostKeys bool
 
 	// init子命令参数
 	ProjectName string
@@ -252,6 +279,13 @@ func initFlags() (*flag.FlagSet, *flag.FlagSet, *CommandFlags) {
 	mainFlags.BoolVar(&flags.Verbose, "verbose", false, "启用详细日志输出")
 	mainFlags.IntVar(&flags.Parallel, "parallel", 5, "最大并行执行数")
 	mainFlags.StringVar(&flags.Tags, "tags", "", "要执行的标签，多个标签用逗号分隔")
+	mainFlags.StringVar(&flags.EventLog, "event-log", "", "以JSON Lines格式记录运行事件的文件路径")
+	mainFlags.StringVar(&flags.AuditDir, "audit-dir", "", "审计目录，每次运行在该目录下创建JSON Lines文件，记录发送到每台主机的命令，敏感值脱敏")
+	mainFlags.StringVar(&flags.RetryFile, "retry-file", "", "记录失败和不可达主机的文件路径 (默认: 任务文件同目录下的ansible-go.retry)")
+	mainFlags.BoolVar(&flags.IgnoreUnreachable, "ignore-unreachable", false, "不可达主机不视为执行失败")
+	mainFlags.BoolVar(&flags.ReplaceHostKeys, "replace-host-keys", false, "known-hosts命令中替换已变更的主机密钥记录")
+	mainFlags.BoolVar(&flags.AskBecomePass, "ask-become-pass", false, "执行前提示输入提权密码")
+	mainFlags.StringVar(&flags.VaultPasswordFile, "vault-password-file", "", "vault密码文件路径，用于加密和解密!ENCRYPTED:开头的值 (默认: 环境变量ANSIBLE_GO_VAULT_PASSWORD)")
 
 	// 创建init子命令
 	initCmd := flag.NewFlagSet("init", flag.ExitOnError)
@@ -269,12 +303,19 @@ func showHelp(mainFlags *flag.FlagSet) {
 	fmt.Println("\n可用命令:")
 	fmt.Println("  init\t初始化新项目")
 	fmt.Println("  check\t检查配置文件的合规性")
+	fmt.Println("  known-hosts\t扫描清单中所有主机的密钥并记录到known_hosts文件")
+	fmt.Println("  encrypt\t使用vault密码加密输入的值，输出可以写入配置文件的!ENCRYPTED:值")
 	fmt.Println("\n子命令参数:")
 	fmt.Println("  init:")
 	fmt.Println("    -name string\t项目名称 (默认: \"ansible-go-project\")")
 	fmt.Println("    -path string\t项目初始化路径 (默认: \".\")")
 	fmt.Println("  check:")
 	fmt.Println("    -config string\t配置文件路径")
+	fmt.Println("  known-hosts:")
+	fmt.Println("    -config string\t配置文件路径")
+	fmt.Println("    -replace-host-keys\t替换已变更的主机密钥记录")
+	fmt.Println("  encrypt:")
+	fmt.Println("    -vault-password-file string\tvault密码文件路径")
 	fmt.Println("\n全局参数:")
 	mainFlags.PrintDefaults()
 }
@@ -322,6 +363,98 @@ func handleCheckCommand(configFile string, mainFlags *flag.FlagSet, log *logger.
 	log.Success("配置文件验证通过")
 }
 
+// handleKnownHostsCommand 处理known-hosts命令，扫描并记录清单中所有主机的密钥
+// 参数:
+//   - configFile: 配置文件路径
+//   - flags: 命令行参数
+//   - log: 日志记录器
+func handleKnownHostsCommand(configFile string, flags *CommandFlags, log *logger.Logger) {
+	if configFile == "" {
+		handleErrorAndExit(log, "未指定配置文件，请使用 --config 参数指定配置文件路径")
+	}
+
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		handleErrorAndExit(log, "加载配置失败: %v", err)
+	}
+
+	exec := executor.NewExecutorWithOptions(cfg, executor.Options{
+		Logger:         log,
+		DisableConsole: true,
+	})
+
+	failed := false
+	for _, result := range exec.PinHostKeys(flags.ReplaceHostKeys) {
+		switch {
+		case result.Error != nil:
+			failed = true
+			log.Error("%s (%s): %v", result.Host, result.Address, result.Error)
+		case result.Status == "":
+			log.Info("%s: 非SSH连接，已跳过", result.Host)
+		default:
+			log.Success("%s (%s): %s %s [%s]", result.Host, result.Address, result.KeyType, result.Fingerprint, result.Status)
+		}
+	}
+
+	if failed {
+		os.Exit(1)
+	}
+}
+
+// handleEncryptCommand 处理encrypt命令，加密从标准输入读取的值
+// 参数:
+//   - flags: 命令行参数
+//   - log: 日志记录器
+func handleEncryptCommand(flags *CommandFlags, log *logger.Logger) {
+	vaultPassword, err := readVaultPassword(flags.VaultPasswordFile)
+	if err != nil {
+		handleErrorAndExit(log, "读取vault密码失败: %v", err)
+	}
+	if vaultPassword == "" {
+		handleErrorAndExit(log, "未提供vault密码，请使用 -vault-password-file 参数或ANSIBLE_GO_VAULT_PASSWORD环境变量")
+	}
+
+	value, err := readSecret("要加密的值: ")
+	if err != nil {
+		handleErrorAndExit(log, "读取输入失败: %v", err)
+	}
+
+	encrypted, err := vars.NewSecurityManager(vaultPassword).EncryptValue(value)
+	if err != nil {
+		handleErrorAndExit(log, "加密失败: %v", err)
+	}
+	fmt.Println(encrypted)
+}
+
+// readVaultPassword 读取vault密码，未指定密码文件时使用环境变量ANSIBLE_GO_VAULT_PASSWORD
+func readVaultPassword(file string) (string, error) {
+	if file == "" {
+		return os.Getenv("ANSIBLE_GO_VAULT_PASSWORD"), nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimRight(string(data), "\r\n"), nil
+}
+
+// readSecret 读取密码等敏感输入，标准输入为终端时提示输入且不回显
+func readSecret(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if term.IsTerminal(fd) {
+		fmt.Fprint(os.Stderr, prompt)
+		secret, err := term.ReadPassword(fd)
+		fmt.Fprintln(os.Stderr)
+		return string(secret), err
+	}
+
+	line, err := bufio.NewReader(os.Stdin).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", err
+	}
+	return strings.TrimRight(line, "\r\n"), nil
+}
+
 // executeTask 执行ansible任务
 // 参数:
 //   - configFile: 配置文件路径
@@ -347,14 +480,49 @@ func executeTask(configFile string, flags *CommandFlags, log *logger.Logger) {
 		os.Exit(1)
 	}
 
+	// 失败和不可达主机默认记录到任务目录下的重试文件
+	retryFile := flags.RetryFile
+	if retryFile == "" {
+		retryFile = filepath.Join(filepath.Dir(configFile), "tasks", "ansible-go.retry")
+	}
+
+	// 读取vault密码和提权密码
+	vaultPassword, err := readVaultPassword(flags.VaultPasswordFile)
+	if err != nil {
+		handleErrorAndExit(log, "读取vault密码失败: %v", err)
+	}
+	var becomePassword string
+	if flags.AskBecomePass {
+		if becomePassword, err = readSecret("提权密码: "); err != nil {
+			handleErrorAndExit(log, "读取提权密码失败: %v", err)
+		}
+	}
+
 	// 创建执行器
-	exec := executor.NewExecutor(cfg)
+	exec := executor.NewExecutorWithOptions(cfg, executor.Options{
+		Logger:            log,
+		IgnoreUnreachable: flags.IgnoreUnreachable,
+		RetryFile:         retryFile,
+		BecomePassword:    becomePassword,
+		VaultPassword:     vaultPassword,
+		AuditDir:          flags.AuditDir,
+	})
 
 	// 设置verbose模式
 	if flags.Verbose {
 		exec.SetVerboseMode(true)
 	}
 
+	// 记录JSON格式的运行事件
+	if flags.EventLog != "" {
+		eventFile, err := os.Create(flags.EventLog)
+		if err != nil {
+			handleErrorAndExit(log, "创建事件日志文件失败: %v", err)
+		}
+		defer eventFile.Close()
+		exec.AddCallback(executor.NewJSONCallback(eventFile))
+	}
+
 	// 设置最大并行执行数
 	if flags.Parallel > 0 {
 		cfg.SSH.MaxParallel = flags.Parallel
@@ -524,6 +692,18 @@ func main() {
 		// 执行配置检查
 		handleCheckCommand(flags.ConfigFile, mainFlags, log)
 
+	case "known-hosts":
+		// 解析主命令参数
+		mainFlags.Parse(os.Args[2:])
+		// 扫描并记录主机密钥
+		handleKnownHostsCommand(flags.ConfigFile, flags, log)
+
+	case "encrypt":
+		// 解析主命令参数
+		mainFlags.Parse(os.Args[2:])
+		// 加密敏感值
+		handleEncryptCommand(flags, log)
+
 	case "help", "-h", "--help":
 		showHelp(mainFlags)
 
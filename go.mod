@@ -5,8 +5,13 @@ go 1.23.0
 toolchain go1.24.0
 
 require (
+	github.com/pkg/sftp v1.13.9
 	golang.org/x/crypto v0.35.0
+	golang.org/x/term v0.29.0
 	gopkg.in/yaml.v3 v3.0.1
 )
 
-require golang.org/x/sys v0.30.0 // indirect
+require (
+	github.com/kr/fs v0.1.0 // indirect
+	golang.org/x/sys v0.30.0 // indirect
+)
@@ -3,6 +3,8 @@ package config
 import (
 	"fmt"
 	"io/ioutil"
+	"path/filepath"
+	"strings"
 
 	"github.com/ape902/ansible-go/pkg/config/types"
 	"gopkg.in/yaml.v3"
@@ -11,8 +13,12 @@ import (
 // Config 定义配置结构
 type Config struct {
 	Inventory map[string][]types.HostInfo `yaml:"inventory"`
+	GroupVars map[string]map[string]interface{} `yaml:"group_vars,omitempty"`
+	HostVars  map[string]map[string]interface{} `yaml:"host_vars,omitempty"`
 	Vars      map[string]interface{}      `yaml:"vars"`
 	SSH       types.SSHConfig             `yaml:"ssh"`
+	Become    types.BecomeConfig          `yaml:"become,omitempty"`
+	Docker    types.DockerConfig          `yaml:"docker,omitempty"`
 	Log       types.LogConfig             `yaml:"log"`
 	Paths     ConfigPaths                 `yaml:"paths,omitempty"`
 }
@@ -25,19 +31,35 @@ type ConfigPaths struct {
 	ExecutorDir string `yaml:"executor_dir"`
 }
 
+// NewConfig 创建默认配置，用于在内存中构建主机清单
+func NewConfig() *Config {
+	return &Config{
+		Inventory: make(map[string][]types.HostInfo),
+		Vars:      make(map[string]interface{}),
+		Paths: ConfigPaths{
+			VarsDir:     "vars",
+			TasksDir:    "tasks",
+			FilesDir:    "files",
+			ExecutorDir: "executor",
+		},
+	}
+}
+
+// AddHost 添加主机到指定主机组，未设置的端口和连接类型在连接时使用组变量或全局SSH配置中的值
+func (c *Config) AddHost(group string, host types.HostInfo) {
+	if host.Vars == nil {
+		host.Vars = make(map[string]interface{})
+	}
+	if c.Inventory == nil {
+		c.Inventory = make(map[string][]types.HostInfo)
+	}
+	c.Inventory[group] = append(c.Inventory[group], host)
+}
+
 // LoadConfig 从文件加载配置
 func LoadConfig(configPath string) (*Config, error) {
 	if configPath == "" {
-		return &Config{
-			Inventory: make(map[string][]types.HostInfo),
-			Vars:      make(map[string]interface{}),
-			Paths: ConfigPaths{
-				VarsDir:    "vars",
-				TasksDir:   "tasks",
-				FilesDir:   "files",
-				ExecutorDir: "executor",
-			},
-		}, nil
+		return NewConfig(), nil
 	}
 
 	// 读取配置文件
@@ -49,8 +71,12 @@ func LoadConfig(configPath string) (*Config, error) {
 	// 解析YAML
 	var rawConfig struct {
 		Inventory map[string]interface{}    `yaml:"inventory"`
+		GroupVars map[string]map[string]interface{} `yaml:"group_vars"`
+		HostVars  map[string]map[string]interface{} `yaml:"host_vars"`
 		Vars      map[string]interface{}    `yaml:"vars"`
 		SSH       types.SSHConfig           `yaml:"ssh"`
+		Become    types.BecomeConfig        `yaml:"become"`
+		Docker    types.DockerConfig        `yaml:"docker"`
 		Log       types.LogConfig           `yaml:"log"`
 		Paths     ConfigPaths               `yaml:"paths"`
 	}
@@ -63,8 +89,12 @@ func LoadConfig(configPath string) (*Config, error) {
 	// 创建最终配置对象
 	cfg := &Config{
 		Inventory: make(map[string][]types.HostInfo),
+		GroupVars: rawConfig.GroupVars,
+		HostVars:  rawConfig.HostVars,
 		Vars:      rawConfig.Vars,
 		SSH:       rawConfig.SSH,
+		Become:    rawConfig.Become,
+		Docker:    rawConfig.Docker,
 		Log:       rawConfig.Log,
 		Paths:     rawConfig.Paths,
 	}
@@ -83,6 +113,10 @@ func LoadConfig(configPath string) (*Config, error) {
 		cfg.Paths.ExecutorDir = "executor"
 	}
 
+	// known_hosts和ssh_config文件的相对路径相对于配置文件所在目录
+	cfg.SSH.KnownHostsFile = resolveConfigPath(configPath, cfg.SSH.KnownHostsFile)
+	cfg.SSH.SSHConfigFile = resolveConfigPath(configPath, cfg.SSH.SSHConfigFile)
+
 	// 处理inventory部分
 	for groupName, hosts := range rawConfig.Inventory {
 		// 添加调试信息
@@ -94,42 +128,14 @@ func LoadConfig(configPath string) (*Config, error) {
 				if hostStr, ok := host.(string); ok {
 					// 处理字符串格式的主机
 					hostInfos = append(hostInfos, types.HostInfo{
-						Host:           hostStr,
-						Port:           22, // 默认SSH端口
-						ConnectionType: "ssh", // 默认连接类型
-						Vars:           make(map[string]interface{}),
+						Host: hostStr,
+						Vars: make(map[string]interface{}),
 					})
 				} else if hostMap, ok := host.(map[string]interface{}); ok {
 					// 处理map格式的主机
-					hostInfo := types.HostInfo{
-						Port:           22, // 默认值
-						ConnectionType: "ssh", // 默认值
-						Vars:           make(map[string]interface{}),
-					}
-
-					// 解析主机信息
-					if host, ok := hostMap["host"].(string); ok {
-						hostInfo.Host = host
-					}
-
-					// 解析端口
-					if port, ok := hostMap["port"].(int); ok {
-						hostInfo.Port = port
-					}
-
-					// 解析别名
-					if alias, ok := hostMap["alias"].(string); ok {
-						hostInfo.Alias = alias
-					}
-
-					// 解析连接类型
-					if connType, ok := hostMap["connection_type"].(string); ok {
-						hostInfo.ConnectionType = connType
-					}
-
-					// 解析变量
-					if vars, ok := hostMap["vars"].(map[string]interface{}); ok {
-						hostInfo.Vars = vars
+					hostInfo, err := parseHostInfo(hostMap)
+					if err != nil {
+						return nil, fmt.Errorf("解析主机组 %s 失败: %w", groupName, err)
 					}
 
 					hostInfos = append(hostInfos, hostInfo)
@@ -145,11 +151,9 @@ func LoadConfig(configPath string) (*Config, error) {
 			for host, alias := range hostList {
 				if aliasStr, ok := alias.(string); ok {
 					hostInfos = append(hostInfos, types.HostInfo{
-						Host:           host,
-						Port:           22, // 默认SSH端口
-						ConnectionType: "ssh", // 默认连接类型
-						Alias:          aliasStr,
-						Vars:           make(map[string]interface{}),
+						Host:  host,
+						Alias: aliasStr,
+						Vars:  make(map[string]interface{}),
 					})
 				}
 			}
@@ -159,35 +163,9 @@ func LoadConfig(configPath string) (*Config, error) {
 			// 尝试将单个map转换为主机列表
 			if hostMap, ok := hosts.(map[string]interface{}); ok {
 				hostInfos := make([]types.HostInfo, 0, 1)
-				hostInfo := types.HostInfo{
-					Port:           22, // 默认值
-					ConnectionType: "ssh", // 默认值
-					Vars:           make(map[string]interface{}),
-				}
-
-				// 解析主机信息
-				if host, ok := hostMap["host"].(string); ok {
-					hostInfo.Host = host
-				}
-
-				// 解析端口
-				if port, ok := hostMap["port"].(int); ok {
-					hostInfo.Port = port
-				}
-
-				// 解析别名
-				if alias, ok := hostMap["alias"].(string); ok {
-					hostInfo.Alias = alias
-				}
-
-				// 解析连接类型
-				if connType, ok := hostMap["connection_type"].(string); ok {
-					hostInfo.ConnectionType = connType
-				}
-
-				// 解析变量
-				if vars, ok := hostMap["vars"].(map[string]interface{}); ok {
-					hostInfo.Vars = vars
+				hostInfo, err := parseHostInfo(hostMap)
+				if err != nil {
+					return nil, fmt.Errorf("解析主机组 %s 失败: %w", groupName, err)
 				}
 
 				hostInfos = append(hostInfos, hostInfo)
@@ -196,35 +174,9 @@ func LoadConfig(configPath string) (*Config, error) {
 				hostInfos := make([]types.HostInfo, 0, len(hostList))
 				for _, item := range hostList {
 					if hostMap, ok := item.(map[string]interface{}); ok {
-						hostInfo := types.HostInfo{
-							Port:           22, // 默认值
-							ConnectionType: "ssh", // 默认值
-							Vars:           make(map[string]interface{}),
-						}
-
-						// 解析主机信息
-						if host, ok := hostMap["host"].(string); ok {
-							hostInfo.Host = host
-						}
-
-						// 解析端口
-						if port, ok := hostMap["port"].(int); ok {
-							hostInfo.Port = port
-						}
-
-						// 解析别名
-						if alias, ok := hostMap["alias"].(string); ok {
-							hostInfo.Alias = alias
-						}
-
-						// 解析连接类型
-						if connType, ok := hostMap["connection_type"].(string); ok {
-							hostInfo.ConnectionType = connType
-						}
-
-						// 解析变量
-						if vars, ok := hostMap["vars"].(map[string]interface{}); ok {
-							hostInfo.Vars = vars
+						hostInfo, err := parseHostInfo(hostMap)
+						if err != nil {
+							return nil, fmt.Errorf("解析主机组 %s 失败: %w", groupName, err)
 						}
 
 						hostInfos = append(hostInfos, hostInfo)
@@ -242,6 +194,78 @@ func LoadConfig(configPath string) (*Config, error) {
 	return cfg, nil
 }
 
+// resolveConfigPath 将相对路径转换为相对于配置文件所在目录的路径，空路径和~开头的路径保持不变
+func resolveConfigPath(configPath, path string) string {
+	if path == "" || strings.HasPrefix(path, "~") || filepath.IsAbs(path) {
+		return path
+	}
+	return filepath.Join(filepath.Dir(configPath), path)
+}
+
+// parseHostInfo 解析map格式的主机配置
+// 未设置的端口和连接类型保持为空，连接时依次使用组变量和全局SSH配置中的值
+func parseHostInfo(hostMap map[string]interface{}) (types.HostInfo, error) {
+	hostInfo := types.HostInfo{
+		Vars: make(map[string]interface{}),
+	}
+
+	// 解析主机信息
+	if host, ok := hostMap["host"].(string); ok {
+		hostInfo.Host = host
+	}
+
+	// 解析端口
+	if port, ok := hostMap["port"].(int); ok {
+		hostInfo.Port = port
+	}
+
+	// 解析别名
+	if alias, ok := hostMap["alias"].(string); ok {
+		hostInfo.Alias = alias
+	}
+
+	// 解析连接类型
+	if connType, ok := hostMap["connection_type"].(string); ok {
+		hostInfo.ConnectionType = connType
+	}
+
+	// 解析主机级认证信息
+	if user, ok := hostMap["user"].(string); ok {
+		hostInfo.User = user
+	}
+	if password, ok := hostMap["password"].(string); ok {
+		hostInfo.Password = password
+	}
+	if keyFile, ok := hostMap["key_file"].(string); ok {
+		hostInfo.KeyFile = keyFile
+	}
+	if certFile, ok := hostMap["cert_file"].(string); ok {
+		hostInfo.CertFile = certFile
+	}
+	if keyPassword, ok := hostMap["key_password"].(string); ok {
+		hostInfo.KeyPassword = keyPassword
+	}
+	if forwardAgent, ok := hostMap["forward_agent"].(bool); ok {
+		hostInfo.ForwardAgent = &forwardAgent
+	}
+
+	// 解析跳板机链
+	if jumpHosts, ok := hostMap["jump_hosts"]; ok {
+		hops, err := ParseJumpHosts(jumpHosts)
+		if err != nil {
+			return hostInfo, fmt.Errorf("主机 %s: %w", hostInfo.Host, err)
+		}
+		hostInfo.JumpHosts = hops
+	}
+
+	// 解析变量
+	if vars, ok := hostMap["vars"].(map[string]interface{}); ok {
+		hostInfo.Vars = vars
+	}
+
+	return hostInfo, nil
+}
+
 // LoadPlaybook 加载playbook文件
 func LoadPlaybook(playbookPath string) (*types.TaskConfig, error) {
 	// 读取playbook文件
@@ -258,15 +282,36 @@ func LoadPlaybook(playbookPath string) (*types.TaskConfig, error) {
 	}
 
 	// 验证必要字段
+	if err := ValidatePlaybook(&taskConfig); err != nil {
+		return nil, err
+	}
+
+	return &taskConfig, nil
+}
+
+// ValidatePlaybook 验证playbook的必要字段
+func ValidatePlaybook(taskConfig *types.TaskConfig) error {
+	if taskConfig == nil {
+		return fmt.Errorf("playbook不能为空")
+	}
 	if taskConfig.Name == "" {
-		return nil, fmt.Errorf("playbook必须包含name字段")
+		return fmt.Errorf("playbook必须包含name字段")
 	}
 	if len(taskConfig.Hosts) == 0 {
-		return nil, fmt.Errorf("playbook必须包含hosts字段")
+		return fmt.Errorf("playbook必须包含hosts字段")
 	}
 	if len(taskConfig.Tasks) == 0 {
-		return nil, fmt.Errorf("playbook必须包含至少一个任务")
+		return fmt.Errorf("playbook必须包含至少一个任务")
 	}
+	return nil
+}
 
-	return &taskConfig, nil
+// NewPlaybook 创建在内存中构建的playbook
+func NewPlaybook(name string, hosts ...string) *types.TaskConfig {
+	return &types.TaskConfig{
+		Name:  name,
+		Hosts: hosts,
+		Tasks: make([]map[string]types.TaskSpec, 0),
+		Vars:  make(map[string]interface{}),
+	}
 }
\ No newline at end of file
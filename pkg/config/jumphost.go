@@ -0,0 +1,147 @@
+package config
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+)
+
+// JumpHostsNone 表示不使用跳板机，用于在主机或组中覆盖全局跳板机配置
+const JumpHostsNone = "none"
+
+// JumpHostsVar 组变量和主机变量中配置跳板机链的变量名
+const JumpHostsVar = "ansible_jump_hosts"
+
+// ParseJumpHosts 解析跳板机链配置，支持两种格式:
+//
+//	"admin@bastion1:2222,bastion2"      OpenSSH ProxyJump格式，逗号分隔
+//	[{host: bastion1, user: admin, key_file: ~/.ssh/bastion}, ...]
+//
+// 值为"none"时返回空列表，表示不使用跳板机
+func ParseJumpHosts(value interface{}) ([]types.JumpHostConfig, error) {
+	switch v := value.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return parseProxyJump(v)
+	case []types.JumpHostConfig:
+		return v, nil
+	case []interface{}:
+		hops := make([]types.JumpHostConfig, 0, len(v))
+		for i, item := range v {
+			switch hop := item.(type) {
+			case string:
+				parsed, err := parseJumpHostSpec(hop)
+				if err != nil {
+					return nil, err
+				}
+				hops = append(hops, parsed)
+			case map[string]interface{}:
+				parsed, err := parseJumpHostMap(hop)
+				if err != nil {
+					return nil, fmt.Errorf("跳板机 #%d: %w", i+1, err)
+				}
+				hops = append(hops, parsed)
+			default:
+				return nil, fmt.Errorf("跳板机 #%d 格式不正确: %v", i+1, item)
+			}
+		}
+		return hops, nil
+	default:
+		return nil, fmt.Errorf("跳板机配置格式不正确: %v", value)
+	}
+}
+
+// parseProxyJump 解析OpenSSH ProxyJump格式的跳板机链
+func parseProxyJump(spec string) ([]types.JumpHostConfig, error) {
+	spec = strings.TrimSpace(spec)
+	if spec == "" || strings.EqualFold(spec, JumpHostsNone) {
+		return []types.JumpHostConfig{}, nil
+	}
+
+	parts := strings.Split(spec, ",")
+	hops := make([]types.JumpHostConfig, 0, len(parts))
+	for _, part := range parts {
+		hop, err := parseJumpHostSpec(part)
+		if err != nil {
+			return nil, err
+		}
+		hops = append(hops, hop)
+	}
+	return hops, nil
+}
+
+// parseJumpHostSpec 解析[user@]host[:port]格式的单个跳板机
+func parseJumpHostSpec(spec string) (types.JumpHostConfig, error) {
+	var hop types.JumpHostConfig
+	spec = strings.TrimSpace(spec)
+
+	if i := strings.LastIndex(spec, "@"); i >= 0 {
+		hop.User = spec[:i]
+		spec = spec[i+1:]
+	}
+
+	hop.Host = spec
+	if strings.HasPrefix(spec, "[") || strings.Count(spec, ":") == 1 {
+		host, port, err := net.SplitHostPort(spec)
+		if err != nil {
+			return hop, fmt.Errorf("跳板机地址格式不正确: %s", spec)
+		}
+		hop.Host = host
+		hop.Port, err = strconv.Atoi(port)
+		if err != nil {
+			return hop, fmt.Errorf("跳板机端口格式不正确: %s", spec)
+		}
+	}
+
+	if hop.Host == "" {
+		return hop, fmt.Errorf("跳板机地址不能为空")
+	}
+	return hop, nil
+}
+
+// parseJumpHostMap 解析map格式的单个跳板机
+func parseJumpHostMap(hopMap map[string]interface{}) (types.JumpHostConfig, error) {
+	var hop types.JumpHostConfig
+	if host, ok := hopMap["host"].(string); ok {
+		hop.Host = host
+	}
+	if port, ok := hopMap["port"].(int); ok {
+		hop.Port = port
+	}
+	if user, ok := hopMap["user"].(string); ok {
+		hop.User = user
+	}
+	if password, ok := hopMap["password"].(string); ok {
+		hop.Password = password
+	}
+	if keyFile, ok := hopMap["key_file"].(string); ok {
+		hop.KeyFile = keyFile
+	}
+	if keyPassword, ok := hopMap["key_password"].(string); ok {
+		hop.KeyPassword = keyPassword
+	}
+
+	if hop.Host == "" {
+		return hop, fmt.Errorf("跳板机地址不能为空")
+	}
+	return hop, nil
+}
+
+// GlobalJumpHosts 获取全局SSH配置中的跳板机链，未启用跳板机时返回nil
+// jump_hosts优先于jump_host
+func GlobalJumpHosts(cfg *types.SSHConfig) []types.JumpHostConfig {
+	if !cfg.UseJumpHost {
+		return nil
+	}
+	if len(cfg.JumpHosts) > 0 {
+		return cfg.JumpHosts
+	}
+	if cfg.JumpHost.Host != "" {
+		return []types.JumpHostConfig{cfg.JumpHost}
+	}
+	return nil
+}
@@ -0,0 +1,20 @@
+package types
+
+// 提权方式
+const (
+	BecomeMethodSudo = "sudo" // 使用sudo提权
+	BecomeMethodSu   = "su"   // 使用su提权
+	BecomeMethodDoas = "doas" // 使用doas提权
+)
+
+// BecomeConfig 定义提权配置
+type BecomeConfig struct {
+	// 提权方式 (sudo, su, doas)，默认为sudo
+	Method string `json:"method" yaml:"method" toml:"method"`
+
+	// 提权后的用户，默认为root
+	User string `json:"user" yaml:"user" toml:"user"`
+
+	// 提权密码，支持!ENCRYPTED:开头的加密值，无需密码时留空
+	Password string `json:"password" yaml:"password" toml:"password"`
+}
@@ -0,0 +1,10 @@
+package types
+
+// DockerConfig 定义docker连接配置
+type DockerConfig struct {
+	// docker可执行文件路径，默认为docker，也可以使用podman等兼容命令
+	Binary string `json:"binary" yaml:"binary" toml:"binary"`
+
+	// 在容器中执行命令的默认用户，为空时使用镜像的默认用户，可以在主机配置中单独覆盖
+	User string `json:"user" yaml:"user" toml:"user"`
+}
@@ -8,6 +8,24 @@ type TaskConfig struct {
 	Tasks       []map[string]TaskSpec  `yaml:"tasks"`
 	Vars        map[string]interface{} `yaml:"vars,omitempty"`
 	Handlers    []HandlerSpec          `yaml:"handlers,omitempty"`
+	// 是否对play中的任务和处理器提权，任务可以单独覆盖
+	Become bool `yaml:"become,omitempty"`
+	// 提权后的用户，为空时使用配置文件中的become.user
+	BecomeUser string `yaml:"become_user,omitempty"`
+	// 提权方式，为空时使用配置文件中的become.method
+	BecomeMethod string `yaml:"become_method,omitempty"`
+	// play中所有任务和处理器执行命令时设置的环境变量，任务可以单独覆盖
+	Environment map[string]string `yaml:"environment,omitempty"`
+}
+
+// AddTask 按顺序追加任务
+func (c *TaskConfig) AddTask(name string, spec TaskSpec) {
+	c.Tasks = append(c.Tasks, map[string]TaskSpec{name: spec})
+}
+
+// AddHandler 追加处理器
+func (c *TaskConfig) AddHandler(handler HandlerSpec) {
+	c.Handlers = append(c.Handlers, handler)
 }
 
 // TaskSpec 定义具体任务规格
@@ -21,6 +39,22 @@ type TaskSpec struct {
 	IgnoreError bool                   `yaml:"ignore_error,omitempty"`
 	Retries     int                    `yaml:"retries,omitempty"`
 	Delay       string                 `yaml:"delay,omitempty"`
+	// 覆盖Changed标记的条件表达式，可引用rc、stdout、stderr、stdout_lines
+	ChangedWhen string `yaml:"changed_when,omitempty"`
+	// 覆盖Failed标记的条件表达式，可引用rc、stdout、stderr、stdout_lines
+	FailedWhen string `yaml:"failed_when,omitempty"`
+	// 该任务在所有主机上的最大并发数，0表示不限制
+	Throttle int `yaml:"throttle,omitempty"`
+	// 是否允许与同一主机上的其他任务并发执行
+	AllowConcurrent bool `yaml:"allow_concurrent,omitempty"`
+	// 是否提权执行，为空时使用play的设置
+	Become *bool `yaml:"become,omitempty"`
+	// 提权后的用户，为空时使用play的设置
+	BecomeUser string `yaml:"become_user,omitempty"`
+	// 提权方式，为空时使用play的设置
+	BecomeMethod string `yaml:"become_method,omitempty"`
+	// 执行命令时设置的环境变量，与play的环境变量合并，同名时使用任务的值
+	Environment map[string]string `yaml:"environment,omitempty"`
 }
 
 // HandlerSpec 定义处理器规格
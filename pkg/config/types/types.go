@@ -24,6 +24,10 @@ const (
 	ConnectionTypeLocal ConnectionType = "local"
 	// ConnectionTypeDocker Docker连接
 	ConnectionTypeDocker ConnectionType = "docker"
+	// ConnectionTypeChroot chroot连接，用于配置挂载的镜像根目录
+	ConnectionTypeChroot ConnectionType = "chroot"
+	// ConnectionTypeNspawn systemd-nspawn连接，用于配置挂载的镜像根目录
+	ConnectionTypeNspawn ConnectionType = "nspawn"
 )
 
 // LogLevel 定义日志级别
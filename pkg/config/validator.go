@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"regexp"
 	"strings"
 
 	"github.com/ape902/ansible-go/pkg/config/types"
@@ -24,6 +25,15 @@ func ValidateConfig(cfg *Config) []ConfigValidationError {
 	// 验证SSH配置
 	errors = append(errors, validateSSHConfig(cfg.SSH)...)
 
+	// 验证提权配置
+	errors = append(errors, validateBecomeMethod("become.method", cfg.Become.Method)...)
+
+	// 验证组变量和主机变量中的跳板机和代理配置
+	errors = append(errors, validateJumpHostVars("group_vars", cfg.GroupVars)...)
+	errors = append(errors, validateJumpHostVars("host_vars", cfg.HostVars)...)
+	errors = append(errors, validateProxyVars("group_vars", cfg.GroupVars)...)
+	errors = append(errors, validateProxyVars("host_vars", cfg.HostVars)...)
+
 	// 验证变量
 	errors = append(errors, validateVars(cfg.Vars)...)
 
@@ -49,6 +59,9 @@ func ValidateTaskConfig(taskCfg *types.TaskConfig) []ConfigValidationError {
 		})
 	}
 
+	errors = append(errors, validateBecomeMethod("become_method", taskCfg.BecomeMethod)...)
+	errors = append(errors, validateEnvironment("environment", taskCfg.Environment)...)
+
 	// 验证任务列表
 	for i, task := range taskCfg.Tasks {
 		for name, spec := range task {
@@ -98,6 +111,16 @@ func validateTaskSpec(name string, spec types.TaskSpec) []ConfigValidationError
 		})
 	}
 
+	if spec.Throttle < 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "throttle",
+			Message: "throttle不能为负数",
+		})
+	}
+
+	errors = append(errors, validateBecomeMethod("become_method", spec.BecomeMethod)...)
+	errors = append(errors, validateEnvironment("environment", spec.Environment)...)
+
 	// 检查notify列表中是否有重复项
 	notifyMap := make(map[string]bool)
 	for i, handler := range spec.Notify {
@@ -142,7 +165,8 @@ func validateInventory(inventory map[string][]types.HostInfo) []ConfigValidation
 				})
 			}
 
-			if host.Port <= 0 || host.Port > 65535 {
+			// 端口为0表示使用默认端口
+			if host.Port < 0 || host.Port > 65535 {
 				errors = append(errors, ConfigValidationError{
 					Field:   fmt.Sprintf("inventory.%s[%d].port", groupName, i),
 					Message: "端口号必须在1-65535之间",
@@ -165,13 +189,175 @@ func validateSSHConfig(cfg types.SSHConfig) []ConfigValidationError {
 		})
 	}
 
-	if cfg.UseKeyAuth && cfg.KeyFile == "" {
+	if cfg.CertFile != "" && cfg.KeyFile == "" {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.cert_file",
+			Message: "配置证书文件时必须同时配置key_file",
+		})
+	}
+
+	if cfg.ReconnectAttempts < 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.reconnect_attempts",
+			Message: "重连次数不能为负数",
+		})
+	}
+
+	if cfg.KeepaliveCountMax < 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.keepalive_count_max",
+			Message: "keepalive次数不能为负数",
+		})
+	}
+
+	if cfg.IdleTimeout < 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.idle_timeout",
+			Message: "空闲连接保留时间不能为负数",
+		})
+	}
+
+	if cfg.MaxSessions < 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.max_sessions",
+			Message: "最大会话数不能为负数",
+		})
+	}
+
+	if cfg.MaxConnectionsPerHost < 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.max_connections_per_host",
+			Message: "每个主机的最大连接数不能为负数",
+		})
+	}
+
+	if cfg.MaxConnections < 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.max_connections",
+			Message: "最大连接数不能为负数",
+		})
+	}
+
+	if cfg.UseJumpHost && cfg.JumpHost.Host == "" && len(cfg.JumpHosts) == 0 {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.jump_host",
+			Message: "启用跳板机时，jump_host或jump_hosts不能为空",
+		})
+	}
+	for i, hop := range cfg.JumpHosts {
+		if hop.Host == "" {
+			errors = append(errors, ConfigValidationError{
+				Field:   fmt.Sprintf("ssh.jump_hosts[%d].host", i),
+				Message: "跳板机地址不能为空",
+			})
+		}
+	}
+
+	if _, err := ParseProxy(cfg.Proxy); err != nil {
+		errors = append(errors, ConfigValidationError{
+			Field:   "ssh.proxy",
+			Message: err.Error(),
+		})
+	}
+
+	switch cfg.HostKeyChecking {
+	case "", "strict", "tofu", "off":
+	default:
 		errors = append(errors, ConfigValidationError{
-			Field:   "ssh.key_file",
-			Message: "使用密钥认证时，私钥路径不能为空",
+			Field:   "ssh.host_key_checking",
+			Message: fmt.Sprintf("不支持的主机密钥检查模式: %s，可选值为strict、tofu、off", cfg.HostKeyChecking),
 		})
 	}
 
+	// 使用密钥认证但未配置私钥时，连接时尝试~/.ssh下的默认私钥
+	for i, path := range cfg.IdentityFiles {
+		if strings.TrimSpace(path) == "" {
+			errors = append(errors, ConfigValidationError{
+				Field:   fmt.Sprintf("ssh.identity_files[%d]", i),
+				Message: "私钥路径不能为空",
+			})
+		}
+	}
+
+	return errors
+}
+
+// validateBecomeMethod 验证提权方式
+func validateBecomeMethod(field, method string) []ConfigValidationError {
+	switch method {
+	case "", types.BecomeMethodSudo, types.BecomeMethodSu, types.BecomeMethodDoas:
+		return nil
+	}
+	return []ConfigValidationError{{
+		Field:   field,
+		Message: fmt.Sprintf("不支持的提权方式: %s，可选值为sudo、su、doas", method),
+	}}
+}
+
+// envNamePattern 环境变量名的格式
+var envNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// validateEnvironment 验证环境变量名，只允许字母、数字和下划线且不能以数字开头
+func validateEnvironment(field string, env map[string]string) []ConfigValidationError {
+	var errors []ConfigValidationError
+
+	for name := range env {
+		if !envNamePattern.MatchString(name) {
+			errors = append(errors, ConfigValidationError{
+				Field:   fmt.Sprintf("%s.%s", field, name),
+				Message: "环境变量名只能包含字母、数字和下划线，且不能以数字开头",
+			})
+		}
+	}
+
+	return errors
+}
+
+// validateJumpHostVars 验证变量中的跳板机链配置
+func validateJumpHostVars(field string, varsMap map[string]map[string]interface{}) []ConfigValidationError {
+	var errors []ConfigValidationError
+
+	for name, vars := range varsMap {
+		value, ok := vars[JumpHostsVar]
+		if !ok {
+			continue
+		}
+		if _, err := ParseJumpHosts(value); err != nil {
+			errors = append(errors, ConfigValidationError{
+				Field:   fmt.Sprintf("%s.%s.%s", field, name, JumpHostsVar),
+				Message: err.Error(),
+			})
+		}
+	}
+
+	return errors
+}
+
+// validateProxyVars 验证变量中的代理配置
+func validateProxyVars(field string, varsMap map[string]map[string]interface{}) []ConfigValidationError {
+	var errors []ConfigValidationError
+
+	for name, vars := range varsMap {
+		value, ok := vars[ProxyVar]
+		if !ok {
+			continue
+		}
+		proxy, ok := value.(string)
+		if !ok {
+			errors = append(errors, ConfigValidationError{
+				Field:   fmt.Sprintf("%s.%s.%s", field, name, ProxyVar),
+				Message: "代理地址必须是字符串",
+			})
+			continue
+		}
+		if _, err := ParseProxy(proxy); err != nil {
+			errors = append(errors, ConfigValidationError{
+				Field:   fmt.Sprintf("%s.%s.%s", field, name, ProxyVar),
+				Message: err.Error(),
+			})
+		}
+	}
+
 	return errors
 }
 
@@ -0,0 +1,212 @@
+package executor
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/connection"
+	"github.com/ape902/ansible-go/pkg/vars"
+)
+
+// auditMinSecretLength 按值脱敏的最小长度，过短的值容易误伤正常输出
+const auditMinSecretLength = 4
+
+// auditQuotedPair 匹配env前缀中经过单引号引用的'NAME=value'，值中可能包含空格和转义的单引号
+var auditQuotedPair = regexp.MustCompile(`'([A-Za-z_][A-Za-z0-9_]*)=(?:[^']|'\\'')*'`)
+
+// auditAssignment 匹配NAME=value、--name=value和name: value形式的赋值
+var auditAssignment = regexp.MustCompile(`\b([A-Za-z_][A-Za-z0-9_.-]*)(=|:[ \t]+)('[^']*'|"[^"]*"|[^\s'";&|<>()]+)`)
+
+// auditOption 匹配--name value和-name value形式的命令行选项名及其后的空白
+var auditOption = regexp.MustCompile(`(--?[A-Za-z][A-Za-z0-9_-]*)[ \t]+`)
+
+// auditValueDelimiters 结束未引用的参数值的字符
+const auditValueDelimiters = " \t\r\n'\";&|<>()"
+
+// openAudit 在审计目录下创建本次运行的审计文件，未配置审计目录时返回nil
+// 文件名包含开始时间，每次运行使用单独的文件，权限为0600
+func (e *Executor) openAudit(play *types.TaskConfig) (*os.File, *connection.AuditRecorder, error) {
+	if e.auditDir == "" {
+		return nil, nil, nil
+	}
+
+	if err := os.MkdirAll(e.auditDir, 0700); err != nil {
+		return nil, nil, fmt.Errorf("创建审计目录失败: %w", err)
+	}
+	file, err := os.CreateTemp(e.auditDir, "audit-"+time.Now().Format("20060102-150405")+"-*.jsonl")
+	if err != nil {
+		return nil, nil, fmt.Errorf("创建审计文件失败: %w", err)
+	}
+
+	security := e.vault
+	if security == nil {
+		security = vars.NewSecurityManager("")
+	}
+	redactor := &auditRedactor{
+		security: security,
+		secrets:  e.auditSecrets(security, play),
+	}
+	return file, connection.NewAuditRecorder(file, e.auditMaxOutput, redactor.redact, security.IsSensitiveKey), nil
+}
+
+// auditSecrets 收集配置、清单和playbook中敏感变量的值以及各类密码，按长度从长到短排序
+// 变量名是否敏感由SecurityManager.IsSensitiveKey判断，加密的值在提供vault密码时解密后使用
+func (e *Executor) auditSecrets(security *vars.SecurityManager, play *types.TaskConfig) []string {
+	seen := make(map[string]bool)
+	add := func(value string) {
+		if decrypted, err := e.decryptSecret(value); err == nil {
+			value = decrypted
+		}
+		if len(value) >= auditMinSecretLength {
+			seen[value] = true
+		}
+	}
+	collect := func(varsMap map[string]interface{}) {
+		collectSensitiveValues(security, varsMap, add)
+	}
+
+	add(e.config.SSH.Password)
+	add(e.config.SSH.KeyPassword)
+	add(e.config.Become.Password)
+	add(e.becomePassword)
+	collect(e.config.Vars)
+	for _, groupVars := range e.config.GroupVars {
+		collect(groupVars)
+	}
+	for _, hostVars := range e.config.HostVars {
+		collect(hostVars)
+	}
+	for _, hosts := range e.config.Inventory {
+		for _, host := range hosts {
+			collect(host.Vars)
+			params := e.resolveHost(host.Host)
+			add(params.Password)
+			add(params.KeyPassword)
+			add(params.BecomePassword)
+		}
+	}
+
+	collect(play.Vars)
+	collectSensitiveEnv(security, play.Environment, add)
+	for _, task := range play.Tasks {
+		for _, spec := range task {
+			collect(spec.Vars)
+			collectSensitiveEnv(security, spec.Environment, add)
+		}
+	}
+
+	secrets := make([]string, 0, len(seen))
+	for value := range seen {
+		secrets = append(secrets, value)
+	}
+	// 先替换较长的值，避免其中包含的较短值先被替换后长值无法匹配
+	sort.Slice(secrets, func(i, j int) bool {
+		if len(secrets[i]) != len(secrets[j]) {
+			return len(secrets[i]) > len(secrets[j])
+		}
+		return secrets[i] < secrets[j]
+	})
+	return secrets
+}
+
+// collectSensitiveValues 递归收集变量中名称敏感的字符串值
+func collectSensitiveValues(security *vars.SecurityManager, varsMap map[string]interface{}, add func(string)) {
+	for key, value := range varsMap {
+		switch v := value.(type) {
+		case string:
+			if security.IsSensitiveKey(key) {
+				add(v)
+			}
+		case map[string]interface{}:
+			collectSensitiveValues(security, v, add)
+		}
+	}
+}
+
+// collectSensitiveEnv 收集名称敏感的环境变量的值
+func collectSensitiveEnv(security *vars.SecurityManager, env map[string]string, add func(string)) {
+	for name, value := range env {
+		if security.IsSensitiveKey(name) {
+			add(value)
+		}
+	}
+}
+
+// auditRedactor 对审计记录中的命令和输出脱敏
+type auditRedactor struct {
+	security *vars.SecurityManager
+	secrets  []string // 已知的敏感值，按长度从长到短排序
+}
+
+// redact 替换已知的敏感值，以及名称敏感的赋值和命令行选项的值
+func (r *auditRedactor) redact(s string) string {
+	if s == "" {
+		return s
+	}
+
+	for _, secret := range r.secrets {
+		s = strings.ReplaceAll(s, secret, connection.RedactedValue)
+	}
+
+	s = auditQuotedPair.ReplaceAllStringFunc(s, func(match string) string {
+		parts := auditQuotedPair.FindStringSubmatch(match)
+		if !r.security.IsSensitiveKey(parts[1]) {
+			return match
+		}
+		return "'" + parts[1] + "=" + connection.RedactedValue + "'"
+	})
+
+	s = auditAssignment.ReplaceAllStringFunc(s, func(match string) string {
+		parts := auditAssignment.FindStringSubmatch(match)
+		if !r.security.IsSensitiveKey(parts[1]) {
+			return match
+		}
+		return parts[1] + parts[2] + connection.RedactedValue
+	})
+
+	return r.redactOptions(s)
+}
+
+// redactOptions 替换名称敏感的命令行选项后的参数值
+// 选项名只在行首、空白或引号之后匹配，参数值可以是引号括起的字符串，从后向前替换以保持位置有效
+func (r *auditRedactor) redactOptions(s string) string {
+	matches := auditOption.FindAllStringSubmatchIndex(s, -1)
+	for i := len(matches) - 1; i >= 0; i-- {
+		m := matches[i]
+		if m[0] > 0 && !strings.ContainsRune(" \t\r\n'\"", rune(s[m[0]-1])) {
+			continue
+		}
+		if !r.security.IsSensitiveKey(strings.TrimLeft(s[m[2]:m[3]], "-")) {
+			continue
+		}
+		if end := auditValueEnd(s, m[1]); end > m[1] {
+			s = s[:m[1]] + connection.RedactedValue + s[end:]
+		}
+	}
+	return s
+}
+
+// auditValueEnd 获取从start开始的参数值的结束位置，引号括起的值在对应的引号之后结束，下一个选项不视为参数值
+func auditValueEnd(s string, start int) int {
+	if start >= len(s) {
+		return start
+	}
+	switch s[start] {
+	case '-':
+		return start
+	case '\'', '"':
+		if end := strings.IndexByte(s[start+1:], s[start]); end >= 0 {
+			return start + end + 2
+		}
+		return len(s)
+	}
+	if end := strings.IndexAny(s[start:], auditValueDelimiters); end >= 0 {
+		return start + end
+	}
+	return len(s)
+}
@@ -0,0 +1,136 @@
+package executor
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ape902/ansible-go/pkg/config"
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/connection"
+	"github.com/ape902/ansible-go/pkg/logger"
+	"github.com/ape902/ansible-go/pkg/vars"
+)
+
+func TestAuditRedact(t *testing.T) {
+	r := &auditRedactor{
+		security: vars.NewSecurityManager(""),
+		secrets:  []string{"hunter2-long", "hunter2"},
+	}
+	for _, tt := range []struct {
+		name  string
+		input string
+		want  string
+	}{
+		{"known secret", "login hunter2-long ok", "login ****** ok"},
+		{"env prefix", `env 'PASSWORD=p'\''w d' 'HOME=/root' sh -c 'id'`, `env 'PASSWORD=******' 'HOME=/root' sh -c 'id'`},
+		{"option with value", "mysql --password x -e 'select 1'", "mysql --password ****** -e 'select 1'"},
+		{"quoted assignment", `curl --token="a b" https://example.com`, "curl --token=****** https://example.com"},
+		{"quoted option value", `curl --token "a b" https://example.com`, "curl --token ****** https://example.com"},
+		{"option without value", "tool --password --verbose", "tool --password --verbose"},
+		{"yaml style", "password: x\nuser: deploy", "password: ******\nuser: deploy"},
+		{"prompt before quote", "sudo -p 'password:' -u root -- sh -c 'id'", "sudo -p 'password:' -u root -- sh -c 'id'"},
+		{"shell assignment", "DB_SECRET=abc ./migrate", "DB_SECRET=****** ./migrate"},
+		{"insensitive", "ls --color never -la /etc/passwd", "ls --color never -la /etc/passwd"},
+		{"option inside word", "echo a--password x", "echo a--password x"},
+	} {
+		if got := r.redact(tt.input); got != tt.want {
+			t.Errorf("%s: redact(%q) = %q, want %q", tt.name, tt.input, got, tt.want)
+		}
+	}
+}
+
+func TestAuditSecretsDecryptsVaultValues(t *testing.T) {
+	encrypted, err := vars.NewSecurityManager("vault-pass").EncryptValue("db-s3cret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.NewConfig()
+	cfg.Vars["db_password"] = encrypted
+	cfg.Vars["db_name"] = "inventory"
+	e := NewExecutorWithOptions(cfg, Options{DisableConsole: true, VaultPassword: "vault-pass"})
+
+	security := vars.NewSecurityManager("")
+	r := &auditRedactor{security: security, secrets: e.auditSecrets(security, &types.TaskConfig{})}
+	if got := r.redact("connect inventory with db-s3cret"); got != "connect inventory with ******" {
+		t.Fatalf("redact = %q, want the decrypted vault value replaced", got)
+	}
+}
+
+func TestAuditRecordRedactsSensitiveEnv(t *testing.T) {
+	security := vars.NewSecurityManager("")
+	r := &auditRedactor{security: security}
+	var out bytes.Buffer
+	recorder := connection.NewAuditRecorder(&out, 0, r.redact, security.IsSensitiveKey)
+	recorder.Record(&connection.AuditRecord{
+		Command: "deploy",
+		Env:     map[string]string{"API_TOKEN": "t0k", "HOME": "/root", "OPTS": "--password x"},
+	})
+
+	record := &connection.AuditRecord{}
+	if err := json.Unmarshal(out.Bytes(), record); err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]string{"API_TOKEN": connection.RedactedValue, "HOME": "/root", "OPTS": "--password ******"}
+	for name, value := range want {
+		if record.Env[name] != value {
+			t.Errorf("env %s = %q, want %q", name, record.Env[name], value)
+		}
+	}
+}
+
+// leakySudoStub 模拟sudo：读取密码后在标准错误中回显密码，再执行--之后的命令
+const leakySudoStub = `#!/bin/sh
+printf '%s' "$4" >&2
+IFS= read -r password
+echo "authenticated with $password" >&2
+shift 7
+exec "$@"
+`
+
+func TestAuditBecomePasswordNotRecorded(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(leakySudoStub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	const password = "become-s3cret"
+	cfg := config.NewConfig()
+	cfg.Inventory["local"] = []types.HostInfo{{Host: "localhost", ConnectionType: string(connection.ConnectionTypeLocal)}}
+	e := NewExecutorWithOptions(cfg, Options{
+		Logger:         logger.NewWithWriter(io.Discard),
+		DisableConsole: true,
+		BecomePassword: password,
+		AuditDir:       filepath.Join(dir, "audit"),
+	})
+
+	play := &types.TaskConfig{Name: "audit", Hosts: []string{"localhost"}, Become: true}
+	play.AddTask("echo", types.TaskSpec{Module: "command", Args: map[string]interface{}{"cmd": "echo " + password}})
+	result, err := e.Run(context.Background(), play)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Failed() {
+		t.Fatalf("run failed: %+v", result.Hosts["localhost"])
+	}
+
+	data, err := os.ReadFile(result.AuditFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 提权命令的其余部分和输出中密码之外的内容保持不变
+	for _, want := range []string{"-u root -- /bin/sh -c", "authenticated with " + connection.RedactedValue} {
+		if !strings.Contains(string(data), want) {
+			t.Fatalf("audit file does not contain %q:\n%s", want, data)
+		}
+	}
+	if strings.Contains(string(data), password) {
+		t.Fatalf("audit file contains the become password:\n%s", data)
+	}
+}
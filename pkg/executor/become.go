@@ -0,0 +1,92 @@
+package executor
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/models"
+	"github.com/ape902/ansible-go/pkg/vars"
+)
+
+// executeTask 解析任务的提权设置和环境变量后交给执行引擎执行
+func (e *Executor) executeTask(play *types.TaskConfig, task *models.Task, ctx *models.TaskContext, execCtx context.Context) error {
+	become, err := e.taskBecome(play, task.Spec, task.Host)
+	if err != nil {
+		task.Status = models.TaskStatusFailed
+		task.Error = err
+		return err
+	}
+	task.Become = become
+	task.Environment = taskEnvironment(play, task.Spec)
+	return e.engine.ExecuteTask(task, ctx, execCtx)
+}
+
+// taskEnvironment 合并play和任务的环境变量，同名时使用任务的值
+func taskEnvironment(play *types.TaskConfig, spec *types.TaskSpec) map[string]string {
+	if len(play.Environment) == 0 && len(spec.Environment) == 0 {
+		return nil
+	}
+
+	env := make(map[string]string, len(play.Environment)+len(spec.Environment))
+	for name, value := range play.Environment {
+		env[name] = value
+	}
+	for name, value := range spec.Environment {
+		env[name] = value
+	}
+	return env
+}
+
+// taskBecome 合并play和任务的提权设置，返回nil表示不提权
+// 提权方式和用户优先级从高到低: 任务 > play > 配置文件中的become
+// 提权密码优先级从高到低: 主机变量ansible_become_password > 命令行输入 > 配置文件中的become.password
+func (e *Executor) taskBecome(play *types.TaskConfig, spec *types.TaskSpec, host string) (*types.BecomeConfig, error) {
+	enabled := play.Become
+	if spec.Become != nil {
+		enabled = *spec.Become
+	}
+	if !enabled {
+		return nil, nil
+	}
+
+	become := e.config.Become
+	if play.BecomeMethod != "" {
+		become.Method = play.BecomeMethod
+	}
+	if spec.BecomeMethod != "" {
+		become.Method = spec.BecomeMethod
+	}
+	if play.BecomeUser != "" {
+		become.User = play.BecomeUser
+	}
+	if spec.BecomeUser != "" {
+		become.User = spec.BecomeUser
+	}
+
+	if e.becomePassword != "" {
+		become.Password = e.becomePassword
+	}
+	if password := e.resolveHost(host).BecomePassword; password != "" {
+		become.Password = password
+	}
+
+	password, err := e.decryptSecret(become.Password)
+	if err != nil {
+		return nil, fmt.Errorf("解密主机 %s 的提权密码失败: %w", host, err)
+	}
+	become.Password = password
+
+	return &become, nil
+}
+
+// decryptSecret 使用vault密码解密!ENCRYPTED:开头的值，其他值原样返回
+func (e *Executor) decryptSecret(value string) (string, error) {
+	if !vars.IsEncrypted(value) {
+		return value, nil
+	}
+	if e.vault == nil {
+		return "", fmt.Errorf("值已加密，请使用-vault-password-file参数或ANSIBLE_GO_VAULT_PASSWORD环境变量提供vault密码")
+	}
+	return e.vault.DecryptValue(value)
+}
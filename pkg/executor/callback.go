@@ -0,0 +1,171 @@
+package executor
+
+import (
+	"sync"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/executor/models"
+)
+
+// EventType 定义运行生命周期事件类型
+type EventType string
+
+const (
+	EventPlaybookStart    EventType = "playbook_start"    // playbook开始执行
+	EventPlayStart        EventType = "play_start"        // play开始执行
+	EventTaskStart        EventType = "task_start"        // 任务在某个主机上开始执行
+	EventTaskResult       EventType = "task_result"       // 任务在某个主机上执行完成
+	EventHandlerRun       EventType = "handler_run"       // 处理器在某个主机上开始执行
+	EventHostUnreachable  EventType = "host_unreachable"  // 主机不可达，后续任务将跳过该主机
+	EventTransferProgress EventType = "transfer_progress" // 文件传输进度
+	EventTaskOutput       EventType = "task_output"       // 任务执行过程中的一行命令输出
+	EventRecap            EventType = "recap"             // 运行结束汇总
+)
+
+// ResultStatus 定义任务结果状态
+type ResultStatus string
+
+const (
+	ResultStatusOK          ResultStatus = "ok"          // 执行成功且无变更
+	ResultStatusChanged     ResultStatus = "changed"     // 执行成功且发生变更
+	ResultStatusFailed      ResultStatus = "failed"      // 执行失败
+	ResultStatusSkipped     ResultStatus = "skipped"     // 已跳过
+	ResultStatusUnreachable ResultStatus = "unreachable" // 主机不可达
+)
+
+// HostStats 定义单个主机的执行统计
+type HostStats struct {
+	OK          int `json:"ok"`          // 成功任务数（含变更）
+	Changed     int `json:"changed"`     // 发生变更的任务数
+	Failed      int `json:"failed"`      // 失败任务数
+	Skipped     int `json:"skipped"`     // 跳过任务数
+	Unreachable int `json:"unreachable"` // 不可达次数
+}
+
+// Event 定义运行生命周期事件
+type Event struct {
+	Type        EventType             `json:"type"`                  // 事件类型
+	Time        time.Time             `json:"time"`                  // 事件时间
+	Playbook    string                `json:"playbook,omitempty"`    // playbook文件路径
+	Play        string                `json:"play,omitempty"`        // play名称
+	Hosts       []string              `json:"hosts,omitempty"`       // play的目标主机列表
+	Host        string                `json:"host,omitempty"`        // 事件所属主机
+	Task        string                `json:"task,omitempty"`        // 任务ID
+	Module      string                `json:"module,omitempty"`      // 任务模块
+	Handler     string                `json:"handler,omitempty"`     // 处理器名称
+	Status      ResultStatus          `json:"status,omitempty"`      // 任务结果状态
+	Result      *models.TaskResult    `json:"result,omitempty"`      // 任务执行结果
+	Error       string                `json:"error,omitempty"`       // 错误信息
+	Stats       map[string]*HostStats `json:"stats,omitempty"`       // 各主机执行统计，仅recap事件
+	Transferred int64                 `json:"transferred,omitempty"` // 已传输字节数，仅transfer_progress事件
+	Total       int64                 `json:"total,omitempty"`       // 文件总字节数，仅transfer_progress事件
+	Stream      string                `json:"stream,omitempty"`      // 输出流(stdout, stderr)，仅task_output事件
+	Line        string                `json:"line,omitempty"`        // 一行输出，不含换行符，仅task_output事件
+}
+
+// Callback 定义运行事件回调接口
+// 每个回调在单独的goroutine中按事件顺序串行调用，实现无需自行处理并发，处理慢的回调不会阻塞任务执行和其他回调
+type Callback interface {
+	// OnEvent 处理运行事件
+	OnEvent(event *Event)
+}
+
+// CallbackFunc 将普通函数适配为Callback
+type CallbackFunc func(event *Event)
+
+// OnEvent 实现Callback接口
+func (f CallbackFunc) OnEvent(event *Event) {
+	f(event)
+}
+
+// subscriber 按顺序将事件异步派发给单个回调
+// 事件先进入队列，有事件待处理时启动一个goroutine依次调用回调，队列为空时goroutine退出
+type subscriber struct {
+	callback Callback
+	mutex    sync.Mutex
+	idle     *sync.Cond
+	queue    []*Event
+	running  bool
+}
+
+// newSubscriber 创建回调的派发器
+func newSubscriber(cb Callback) *subscriber {
+	s := &subscriber{callback: cb}
+	s.idle = sync.NewCond(&s.mutex)
+	return s
+}
+
+// send 将事件加入队列，不等待回调处理
+func (s *subscriber) send(event *Event) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.queue = append(s.queue, event)
+	if !s.running {
+		s.running = true
+		go s.dispatch()
+	}
+}
+
+// dispatch 依次将队列中的事件交给回调处理，直到队列为空
+func (s *subscriber) dispatch() {
+	for {
+		s.mutex.Lock()
+		if len(s.queue) == 0 {
+			s.running = false
+			s.idle.Broadcast()
+			s.mutex.Unlock()
+			return
+		}
+		event := s.queue[0]
+		s.queue[0] = nil
+		s.queue = s.queue[1:]
+		s.mutex.Unlock()
+
+		s.callback.OnEvent(event)
+	}
+}
+
+// flush 等待队列中的事件全部处理完成
+func (s *subscriber) flush() {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for s.running {
+		s.idle.Wait()
+	}
+}
+
+// resultStatus 根据任务执行情况判断结果状态
+func resultStatus(task *models.Task, err error) ResultStatus {
+	result := task.Result
+	switch {
+	case result != nil && result.Unreachable:
+		return ResultStatusUnreachable
+	case err != nil || task.Status == models.TaskStatusFailed:
+		return ResultStatusFailed
+	case result == nil:
+		return ResultStatusOK
+	case result.Skipped:
+		return ResultStatusSkipped
+	case result.Changed:
+		return ResultStatusChanged
+	default:
+		return ResultStatusOK
+	}
+}
+
+// record 将结果状态计入主机统计
+func (s *HostStats) record(status ResultStatus) {
+	switch status {
+	case ResultStatusOK:
+		s.OK++
+	case ResultStatusChanged:
+		s.OK++
+		s.Changed++
+	case ResultStatusFailed:
+		s.Failed++
+	case ResultStatusSkipped:
+		s.Skipped++
+	case ResultStatusUnreachable:
+		s.Unreachable++
+	}
+}
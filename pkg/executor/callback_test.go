@@ -0,0 +1,69 @@
+package executor
+
+import (
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestSubscriberDeliversInOrderWithoutBlocking(t *testing.T) {
+	release := make(chan struct{})
+	var got []int64
+	slow := newSubscriber(CallbackFunc(func(event *Event) {
+		<-release
+		got = append(got, event.Total)
+	}))
+
+	var mutex sync.Mutex
+	fastCount := 0
+	fast := newSubscriber(CallbackFunc(func(event *Event) {
+		mutex.Lock()
+		fastCount++
+		mutex.Unlock()
+	}))
+
+	for i := 0; i < 100; i++ {
+		event := &Event{Total: int64(i)}
+		slow.send(event)
+		fast.send(event)
+	}
+
+	// 慢回调阻塞时其他回调仍能处理完全部事件
+	fast.flush()
+	if fastCount != 100 {
+		t.Fatalf("fast subscriber got %d events, want 100", fastCount)
+	}
+
+	close(release)
+	slow.flush()
+	if len(got) != 100 {
+		t.Fatalf("slow subscriber got %d events, want 100", len(got))
+	}
+	for i, total := range got {
+		if total != int64(i) {
+			t.Fatalf("event %d delivered out of order: %v", i, got)
+		}
+	}
+}
+
+func TestEmitDoesNotWaitForCallbacks(t *testing.T) {
+	block := make(chan struct{})
+	defer close(block)
+
+	e := &Executor{}
+	e.AddCallback(CallbackFunc(func(event *Event) { <-block }))
+
+	done := make(chan struct{})
+	go func() {
+		for i := 0; i < 10; i++ {
+			e.emit(&Event{Type: EventTaskOutput})
+		}
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Fatal("emit blocked on a slow callback")
+	}
+}
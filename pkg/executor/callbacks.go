@@ -0,0 +1,142 @@
+package executor
+
+import (
+	"encoding/json"
+	"io"
+	"sort"
+
+	"github.com/ape902/ansible-go/pkg/logger"
+)
+
+// ConsoleCallback 将运行事件输出到控制台
+type ConsoleCallback struct {
+	logger   *logger.Logger
+	streamed map[string]bool // 已实时输出过命令输出的主机和任务，结果中不再重复输出
+}
+
+// NewConsoleCallback 创建新的控制台回调
+func NewConsoleCallback(log *logger.Logger) *ConsoleCallback {
+	return &ConsoleCallback{
+		logger:   log,
+		streamed: make(map[string]bool),
+	}
+}
+
+// OnEvent 实现Callback接口
+func (c *ConsoleCallback) OnEvent(event *Event) {
+	switch event.Type {
+	case EventPlaybookStart:
+		c.logger.Info("开始执行playbook: %s", event.Playbook)
+	case EventPlayStart:
+		c.logger.Info("开始执行play: %s，目标主机 %d 个", event.Play, len(event.Hosts))
+	case EventTaskStart:
+		c.logger.Debug("在主机 %s 上开始执行任务 %s (%s)", event.Host, event.Task, event.Module)
+	case EventHandlerRun:
+		c.logger.Info("在主机 %s 上执行处理器 %s", event.Host, event.Handler)
+	case EventHostUnreachable:
+		c.logger.Error("主机 %s 不可达，后续任务将跳过该主机: %s", event.Host, event.Error)
+	case EventTransferProgress:
+		c.logger.Info("主机 %s 上的任务 %s 文件传输进度: %d/%d 字节 (%d%%)", event.Host, event.Task, event.Transferred, event.Total, event.Transferred*100/event.Total)
+	case EventTaskOutput:
+		c.streamed[event.Host+"/"+event.Task] = true
+		c.logger.OutputLine(event.Host, event.Task, event.Line, event.Stream == "stderr")
+	case EventTaskResult:
+		c.printResult(event)
+	case EventRecap:
+		c.printRecap(event)
+	}
+}
+
+// printResult 输出任务执行结果
+func (c *ConsoleCallback) printResult(event *Event) {
+	key := event.Host + "/" + event.Task
+	streamed := c.streamed[key]
+	delete(c.streamed, key)
+
+	switch event.Status {
+	case ResultStatusUnreachable:
+		c.logger.Error("主机 %s 不可达，任务 %s 未执行: %s", event.Host, event.Task, event.Error)
+		return
+	case ResultStatusSkipped:
+		c.logger.Info("主机 %s 上的任务 %s 已跳过", event.Host, event.Task)
+		return
+	}
+
+	if event.Error != "" {
+		c.logger.Error("在主机 %s 上执行任务 %s 失败: %s", event.Host, event.Task, event.Error)
+	}
+
+	// 命令输出已在执行过程中实时输出
+	if streamed {
+		return
+	}
+
+	if event.Result == nil {
+		return
+	}
+	if event.Result.Stdout != "" {
+		c.logger.Output(event.Host, event.Task, event.Result.Stdout)
+	}
+	if event.Result.Stderr != "" {
+		c.logger.Error("主机 %s 上的任务 %s 的错误输出:", event.Host, event.Task)
+		c.logger.Output(event.Host, event.Task, event.Result.Stderr)
+	}
+}
+
+// printRecap 输出运行汇总
+func (c *ConsoleCallback) printRecap(event *Event) {
+	hosts := make([]string, 0, len(event.Stats))
+	for host := range event.Stats {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+
+	c.logger.Info("运行汇总:")
+	c.logger.IncreaseIndent()
+	for _, host := range hosts {
+		s := event.Stats[host]
+		line := "%-20s ok=%d changed=%d failed=%d skipped=%d unreachable=%d"
+		if s.Failed > 0 || s.Unreachable > 0 {
+			c.logger.Error(line, host, s.OK, s.Changed, s.Failed, s.Skipped, s.Unreachable)
+		} else {
+			c.logger.Success(line, host, s.OK, s.Changed, s.Failed, s.Skipped, s.Unreachable)
+		}
+	}
+	c.logger.DecreaseIndent()
+}
+
+// JSONCallback 将运行事件以JSON Lines格式写入输出流
+type JSONCallback struct {
+	encoder *json.Encoder
+}
+
+// NewJSONCallback 创建新的JSON回调
+func NewJSONCallback(w io.Writer) *JSONCallback {
+	return &JSONCallback{
+		encoder: json.NewEncoder(w),
+	}
+}
+
+// OnEvent 实现Callback接口
+func (c *JSONCallback) OnEvent(event *Event) {
+	// 写入失败不影响任务执行
+	_ = c.encoder.Encode(event)
+}
+
+// ChannelCallback 将运行事件发送到通道，用于流式消费事件
+// 通道写满时只阻塞该回调的派发，运行返回前会等待事件全部发送，调用方应在运行期间持续读取
+type ChannelCallback struct {
+	events chan<- *Event
+}
+
+// NewChannelCallback 创建新的通道回调
+func NewChannelCallback(events chan<- *Event) *ChannelCallback {
+	return &ChannelCallback{
+		events: events,
+	}
+}
+
+// OnEvent 实现Callback接口
+func (c *ChannelCallback) OnEvent(event *Event) {
+	c.events <- event
+}
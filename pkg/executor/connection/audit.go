@@ -0,0 +1,293 @@
+package connection
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"os/user"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultAuditMaxOutput 审计记录中标准输出和标准错误各自保留的默认最大字节数
+const DefaultAuditMaxOutput = 64 << 10
+
+// auditRedactSlack 截断输出前额外保留的字节数，避免截断位置附近的敏感值只被部分脱敏
+const auditRedactSlack = 4 << 10
+
+// AuditRecord 定义一条远程命令的审计记录
+type AuditRecord struct {
+	Time            time.Time         `json:"time"`                       // 命令开始执行的时间
+	Host            string            `json:"host"`                       // 清单中的主机名
+	User            string            `json:"user,omitempty"`             // 连接使用的用户
+	Type            ConnectionType    `json:"type"`                       // 连接类型
+	Task            string            `json:"task,omitempty"`             // 任务ID，连接预检查时为空
+	Command         string            `json:"command"`                    // 实际发送的命令
+	Env             map[string]string `json:"env,omitempty"`              // 由连接设置的环境变量
+	Stdin           bool              `json:"stdin,omitempty"`            // 是否提供了标准输入，输入内容不记录
+	Pty             bool              `json:"pty,omitempty"`              // 是否分配了伪终端
+	ExitCode        int               `json:"exit_code"`                  // 退出码
+	Duration        time.Duration     `json:"duration"`                   // 执行时长
+	Stdout          string            `json:"stdout,omitempty"`           // 标准输出
+	Stderr          string            `json:"stderr,omitempty"`           // 标准错误
+	StdoutTruncated bool              `json:"stdout_truncated,omitempty"` // 标准输出是否被截断
+	StderrTruncated bool              `json:"stderr_truncated,omitempty"` // 标准错误是否被截断
+	Error           string            `json:"error,omitempty"`            // 执行命令失败的错误信息
+}
+
+// AuditRecorder 将审计记录以JSON Lines格式写入输出流，可以被多个连接并发使用
+type AuditRecorder struct {
+	mutex     sync.Mutex
+	encoder   *json.Encoder
+	err       error
+	maxOutput int
+	redact    func(string) string
+	sensitive func(string) bool
+}
+
+// NewAuditRecorder 创建审计记录器
+// maxOutput为标准输出和标准错误各自保留的最大字节数，不大于0时使用DefaultAuditMaxOutput
+// redact对命令、输出和错误信息脱敏，sensitive判断环境变量名是否敏感，为nil时不脱敏
+func NewAuditRecorder(w io.Writer, maxOutput int, redact func(string) string, sensitive func(string) bool) *AuditRecorder {
+	if maxOutput <= 0 {
+		maxOutput = DefaultAuditMaxOutput
+	}
+	if redact == nil {
+		redact = func(s string) string { return s }
+	}
+	if sensitive == nil {
+		sensitive = func(string) bool { return false }
+	}
+	return &AuditRecorder{
+		encoder:   json.NewEncoder(w),
+		maxOutput: maxOutput,
+		redact:    redact,
+		sensitive: sensitive,
+	}
+}
+
+// Record 脱敏并截断输出后写入一条审计记录
+func (r *AuditRecorder) Record(record *AuditRecord) {
+	record.Command = r.redact(record.Command)
+	record.Error = r.redact(record.Error)
+	record.Stdout, record.StdoutTruncated = r.truncate(r.redact(record.Stdout), record.StdoutTruncated)
+	record.Stderr, record.StderrTruncated = r.truncate(r.redact(record.Stderr), record.StderrTruncated)
+	if len(record.Env) > 0 {
+		env := make(map[string]string, len(record.Env))
+		for name, value := range record.Env {
+			if r.sensitive(name) {
+				value = RedactedValue
+			} else {
+				value = r.redact(value)
+			}
+			env[name] = value
+		}
+		record.Env = env
+	}
+
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if err := r.encoder.Encode(record); err != nil && r.err == nil {
+		r.err = err
+	}
+}
+
+// Err 获取第一次写入审计记录失败的错误
+func (r *AuditRecorder) Err() error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	return r.err
+}
+
+// truncate 将输出截断到最大字节数，不截断UTF-8字符
+func (r *AuditRecorder) truncate(output string, truncated bool) (string, bool) {
+	if len(output) <= r.maxOutput {
+		return output, truncated
+	}
+	return output[:runeBoundary(output, r.maxOutput)], true
+}
+
+// runeBoundary 获取不大于n且不在UTF-8字符中间的截断位置，s的长度必须大于n
+// 不是有效UTF-8的输出最多后退utf8.UTFMax-1个字节，找不到字符起始字节时在n处截断
+func runeBoundary(s string, n int) int {
+	for i := n; i > 0 && i > n-utf8.UTFMax; i-- {
+		if utf8.RuneStart(s[i]) {
+			return i
+		}
+	}
+	return n
+}
+
+// RedactedValue 审计记录中替换敏感值的占位符
+const RedactedValue = "******"
+
+// auditKey 上下文中审计记录器的键
+type auditKey struct{}
+
+// WithAudit 在上下文中设置审计记录器，执行引擎为任务使用的连接记录每条命令
+func WithAudit(ctx context.Context, recorder *AuditRecorder) context.Context {
+	return context.WithValue(ctx, auditKey{}, recorder)
+}
+
+// AuditFromContext 获取上下文中的审计记录器，未设置时返回nil
+func AuditFromContext(ctx context.Context) *AuditRecorder {
+	recorder, _ := ctx.Value(auditKey{}).(*AuditRecorder)
+	return recorder
+}
+
+// AuditConnection 记录每条命令的连接
+// 应直接包装连接池中的连接，使提权和环境变量处理后实际发送的命令都被记录，文件传输不记录
+type AuditConnection struct {
+	Connection
+	recorder *AuditRecorder
+	host     string
+	user     string
+	task     string
+}
+
+// NewAuditConnection 创建记录命令的连接，host为清单中的主机名，task为任务ID
+// conn支持流式执行命令时返回的连接同样实现StreamExecutor接口
+func NewAuditConnection(conn Connection, recorder *AuditRecorder, host, task string) Connection {
+	audit := &AuditConnection{
+		Connection: conn,
+		recorder:   recorder,
+		host:       host,
+		user:       connectionUser(conn),
+		task:       task,
+	}
+	if streamer, ok := conn.(StreamExecutor); ok {
+		return &auditStreamConnection{AuditConnection: audit, streamer: streamer}
+	}
+	return audit
+}
+
+// ExecuteCommand 执行命令并记录
+func (c *AuditConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	record := c.newRecord(command)
+	result, err := c.Connection.ExecuteCommand(command)
+	record.Duration = time.Since(record.Time)
+
+	switch {
+	case err != nil:
+		record.ExitCode = -1
+		record.Error = err.Error()
+	default:
+		record.ExitCode = result.ExitCode
+		record.Stdout = result.Stdout
+		record.Stderr = result.Stderr
+		if result.Error != nil {
+			record.Error = result.Error.Error()
+		}
+	}
+	c.recorder.Record(record)
+	return result, err
+}
+
+// auditStreamConnection 记录每条命令的连接，被包装的连接支持流式执行命令
+type auditStreamConnection struct {
+	*AuditConnection
+	streamer StreamExecutor
+}
+
+// ExecuteStream 实现StreamExecutor接口，执行命令并记录，输出在写入opts的同时被收集
+func (c *auditStreamConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	record := c.newRecord(command)
+	record.Env = opts.Env
+	record.Stdin = opts.Stdin != nil
+	record.Pty = opts.Pty
+
+	limit := c.recorder.maxOutput + auditRedactSlack
+	stdout := &auditWriter{next: opts.Stdout, limit: limit}
+	stderr := &auditWriter{next: opts.Stderr, limit: limit}
+	captured := *opts
+	captured.Stdout = stdout
+	captured.Stderr = stderr
+
+	exitCode, err := c.streamer.ExecuteStream(command, &captured)
+	record.Duration = time.Since(record.Time)
+	record.ExitCode = exitCode
+	record.Stdout, record.StdoutTruncated = stdout.output()
+	record.Stderr, record.StderrTruncated = stderr.output()
+	if err != nil {
+		record.Error = err.Error()
+	}
+	c.recorder.Record(record)
+	return exitCode, err
+}
+
+// CopyFileWithOptions 实现FileTransferer接口，文件传输不记录
+func (c *AuditConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	return CopyFileWithOptions(c.Connection, localPath, remotePath, opts)
+}
+
+// FetchFileWithOptions 实现FileTransferer接口，文件传输不记录
+func (c *AuditConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	return FetchFileWithOptions(c.Connection, remotePath, localPath, opts)
+}
+
+// newRecord 创建命令开始执行时的审计记录
+func (c *AuditConnection) newRecord(command string) *AuditRecord {
+	return &AuditRecord{
+		Time:    time.Now(),
+		Host:    c.host,
+		User:    c.user,
+		Type:    c.Connection.GetType(),
+		Task:    c.task,
+		Command: command,
+	}
+}
+
+// connectionUser 获取连接执行命令使用的用户，本地连接使用当前用户
+func connectionUser(conn Connection) string {
+	switch c := conn.(type) {
+	case *SSHConnection:
+		return c.User
+	case *DockerConnection:
+		return c.User
+	case *ChrootConnection:
+		return c.User
+	case *LocalConnection:
+		if current, err := user.Current(); err == nil {
+			return current.Username
+		}
+	}
+	return ""
+}
+
+// auditWriter 将输出写入下一个Writer，同时收集不超过limit字节的输出
+type auditWriter struct {
+	mutex     sync.Mutex
+	next      io.Writer
+	limit     int
+	buf       []byte
+	truncated bool
+}
+
+// Write 实现io.Writer接口，超过limit时在limit之前最近的UTF-8字符边界截断
+func (w *auditWriter) Write(p []byte) (int, error) {
+	w.mutex.Lock()
+	switch {
+	case w.truncated:
+	case len(w.buf)+len(p) <= w.limit:
+		w.buf = append(w.buf, p...)
+	default:
+		// 多收集截断位置之后的一个字节，用于判断截断位置是否在字符中间
+		w.buf = append(w.buf, p[:w.limit-len(w.buf)+1]...)
+		w.buf = w.buf[:runeBoundary(string(w.buf), w.limit)]
+		w.truncated = true
+	}
+	w.mutex.Unlock()
+
+	if w.next == nil {
+		return len(p), nil
+	}
+	return w.next.Write(p)
+}
+
+// output 获取收集到的输出以及是否超过了limit
+func (w *auditWriter) output() (string, bool) {
+	w.mutex.Lock()
+	defer w.mutex.Unlock()
+	return string(w.buf), w.truncated
+}
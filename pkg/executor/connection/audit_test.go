@@ -0,0 +1,108 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// commandOnlyConnection 只支持ExecuteCommand的连接
+type commandOnlyConnection struct {
+	Connection
+}
+
+func (c commandOnlyConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	return &ConnectionResult{Stdout: "ok\n"}, nil
+}
+
+func (c commandOnlyConnection) GetType() ConnectionType { return ConnectionTypeSSH }
+
+// readAuditRecords 解析审计输出中的所有记录
+func readAuditRecords(t *testing.T, data []byte) []*AuditRecord {
+	t.Helper()
+	var records []*AuditRecord
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		record := &AuditRecord{}
+		if err := json.Unmarshal(scanner.Bytes(), record); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, record)
+	}
+	return records
+}
+
+func TestAuditConnectionStreamCapability(t *testing.T) {
+	var out bytes.Buffer
+	recorder := NewAuditRecorder(&out, 0, nil, nil)
+
+	plain := NewAuditConnection(commandOnlyConnection{}, recorder, "web1", "t1")
+	if _, ok := plain.(StreamExecutor); ok {
+		t.Fatal("audit wrapper advertises streaming for a connection without it")
+	}
+	if _, err := plain.ExecuteCommand("uptime"); err != nil {
+		t.Fatal(err)
+	}
+
+	local := NewLocalConnection()
+	if err := local.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	stream, ok := NewAuditConnection(local, recorder, "web1", "t1").(StreamExecutor)
+	if !ok {
+		t.Fatal("audit wrapper hides streaming of the wrapped connection")
+	}
+	var stdout bytes.Buffer
+	code, err := stream.ExecuteStream("echo streamed; exit 2", &StreamOptions{Stdout: &stdout, Stderr: &bytes.Buffer{}})
+	if err != nil || code != 2 || stdout.String() != "streamed\n" {
+		t.Fatalf("ExecuteStream = %d, %v, stdout %q", code, err, stdout.String())
+	}
+
+	records := readAuditRecords(t, out.Bytes())
+	if len(records) != 2 || records[0].Command != "uptime" || records[1].ExitCode != 2 || records[1].Stdout != "streamed\n" {
+		t.Fatalf("records = %+v", records)
+	}
+}
+
+func TestAuditTruncateKeepsRunes(t *testing.T) {
+	// "审计"每个字符3字节，截断位置落在第二个字符中间
+	recorder := NewAuditRecorder(&bytes.Buffer{}, 4, nil, nil)
+	for _, tt := range []struct {
+		output string
+		want   string
+	}{
+		{"审计", "审"},
+		{"ab审计", "ab"},
+		{"abcd审", "abcd"},
+		{"abc", "abc"},
+		{"\x80\x80\x80\x80\x80\x80", "\x80\x80\x80\x80"},
+	} {
+		got, _ := recorder.truncate(tt.output, false)
+		if got != tt.want {
+			t.Errorf("truncate(%q) = %q, want %q", tt.output, got, tt.want)
+		}
+	}
+}
+
+func TestAuditWriterKeepsRunes(t *testing.T) {
+	var next bytes.Buffer
+	w := &auditWriter{next: &next, limit: 8}
+	// 字符跨越两次写入，截断位置落在第三个字符中间
+	input := []byte(strings.Repeat("日", 4))
+	for _, chunk := range [][]byte{input[:4], input[4:7], input[7:]} {
+		if n, err := w.Write(chunk); err != nil || n != len(chunk) {
+			t.Fatalf("Write = %d, %v", n, err)
+		}
+	}
+
+	output, truncated := w.output()
+	if output != "日日" || !truncated || !utf8.ValidString(output) {
+		t.Fatalf("output = %q, truncated = %v", output, truncated)
+	}
+	if next.String() != string(input) {
+		t.Fatalf("next writer got %q, want all output", next.String())
+	}
+}
@@ -0,0 +1,417 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+)
+
+// becomeSuccessPrefix 提权成功后命令首先输出的标记前缀
+const becomeSuccessPrefix = "BECOME-SUCCESS-"
+
+// becomeTempDir 提权传输文件时登录用户创建临时目录的位置
+const becomeTempDir = "/tmp"
+
+// passwordPromptPattern 匹配su和doas在伪终端中输出的密码提示
+var passwordPromptPattern = regexp.MustCompile(`(?i)(password|密码)[^\n]*[:：]\s*$`)
+
+// BecomeConnection 在基础连接上以提权用户执行命令和传输文件
+// 所有执行器通过该连接执行的命令都会提权，写入文件时先以登录用户上传到临时文件，再由提权用户移动到目标路径
+type BecomeConnection struct {
+	Connection
+	become *types.BecomeConfig
+}
+
+// NewBecomeConnection 创建提权连接，未设置的提权方式和用户分别使用sudo和root
+func NewBecomeConnection(conn Connection, become *types.BecomeConfig) *BecomeConnection {
+	b := *become
+	if b.Method == "" {
+		b.Method = types.BecomeMethodSudo
+	}
+	if b.User == "" {
+		b.User = "root"
+	}
+	return &BecomeConnection{Connection: conn, become: &b}
+}
+
+// ExecuteCommand 以提权用户执行命令
+// 命令先输出提权成功标记，出现密码提示时写入提权密码，标记之前的输出不计入结果
+func (c *BecomeConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	start := time.Now()
+	result, err := c.execute(command, &StreamOptions{})
+	if err != nil {
+		return nil, err
+	}
+	result.Duration = time.Since(start)
+	return result, nil
+}
+
+// ExecuteStream 实现StreamExecutor接口，以提权用户执行命令，提权成功后的输出在执行过程中写入opts中的Writer
+// 命令的标准输入先用于应答密码提示，提权成功后再写入opts中的输入；提权会重置环境变量，环境变量在提权后的shell中设置
+func (c *BecomeConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	result, err := c.execute(command, opts)
+	if err != nil {
+		return -1, err
+	}
+	return result.ExitCode, nil
+}
+
+// execute 以提权用户执行命令，opts中的Writer不为nil时同时写入提权成功后的输出
+func (c *BecomeConnection) execute(command string, opts *StreamOptions) (*ConnectionResult, error) {
+	streamer, ok := c.Connection.(StreamExecutor)
+	if !ok {
+		return nil, fmt.Errorf("%s连接不支持提权", c.Connection.GetType())
+	}
+
+	key := randomHex(8)
+	s := &becomeSession{
+		password:   c.become.Password,
+		marker:     regexp.MustCompile(`(?m)^` + becomeSuccessPrefix + key + `\r?\n`),
+		input:      opts.Stdin,
+		liveStdout: opts.Stdout,
+		liveStderr: opts.Stderr,
+	}
+	wrapped, pty := c.wrapCommand(envCommand(opts.Env, command), key, s)
+	pty = pty || opts.Pty
+
+	stdin, stdinWriter := io.Pipe()
+	s.stdin = stdinWriter
+	defer s.closeStdin()
+
+	exitCode, err := streamer.ExecuteStream(wrapped, &StreamOptions{
+		Stdin:  stdin,
+		Stdout: writerFunc(s.writeStdout),
+		Stderr: writerFunc(s.writeStderr),
+		Pty:    pty,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return s.result(c.become, exitCode, pty)
+}
+
+// wrapCommand 根据提权方式构造命令，返回是否需要伪终端
+// sudo使用带随机标识的提示符从标准输入读取密码，su和doas只能从终端读取密码
+func (c *BecomeConnection) wrapCommand(command, key string, s *becomeSession) (string, bool) {
+	inner := ShellQuote(fmt.Sprintf("echo %s%s; %s", becomeSuccessPrefix, key, command))
+	user := ShellQuote(c.become.User)
+
+	switch c.become.Method {
+	case types.BecomeMethodSu:
+		return fmt.Sprintf("su %s -c %s", user, ShellQuote("/bin/sh -c "+inner)), true
+	case types.BecomeMethodDoas:
+		return fmt.Sprintf("doas -u %s /bin/sh -c %s", user, inner), true
+	default:
+		s.prompt = []byte(fmt.Sprintf("[sudo via ansible-go, key=%s] password:", key))
+		return fmt.Sprintf("sudo -H -S -p %s -u %s -- /bin/sh -c %s", ShellQuote(string(s.prompt)), user, inner), false
+	}
+}
+
+// CopyFile 实现Connection接口的CopyFile方法
+func (c *BecomeConnection) CopyFile(localPath, remotePath string) error {
+	return c.CopyFileWithOptions(localPath, remotePath, nil)
+}
+
+// FetchFile 实现Connection接口的FetchFile方法
+func (c *BecomeConnection) FetchFile(remotePath, localPath string) error {
+	return c.FetchFileWithOptions(remotePath, localPath, nil)
+}
+
+// CopyFileWithOptions 以登录用户上传到临时目录，再由提权用户复制到目标路径，目标文件属于提权用户
+func (c *BecomeConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("读取本地文件信息失败: %w", err)
+	}
+	mode := transferMode(opts, info.Mode())
+
+	stage, err := c.newStage()
+	if err != nil {
+		return err
+	}
+	defer stage.remove()
+
+	uploadOpts := &TransferOptions{Mode: 0600}
+	if opts != nil {
+		uploadOpts.Progress = opts.Progress
+	}
+	if err := CopyFileWithOptions(c.Connection, localPath, stage.file, uploadOpts); err != nil {
+		return err
+	}
+	if err := c.grant(stage, "r"); err != nil {
+		return err
+	}
+
+	// 在目标目录中完成复制和权限设置后再重命名，避免目标文件处于写入一半的状态
+	dstTmp := ShellQuote(remoteTempPath(remotePath))
+	result, err := c.ExecuteCommand(fmt.Sprintf("cp %s %s && chmod %04o %s && mv -f %s %s || { rm -f %s; exit 1; }",
+		ShellQuote(stage.file), dstTmp, mode, dstTmp, dstTmp, ShellQuote(remotePath), dstTmp))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("提权写入文件失败: %s", strings.TrimSpace(result.Stderr+result.Stdout))
+	}
+	return nil
+}
+
+// FetchFileWithOptions 由提权用户将文件内容写入登录用户创建的临时文件，再以登录用户获取
+func (c *BecomeConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	stage, err := c.newStage()
+	if err != nil {
+		return err
+	}
+	defer stage.remove()
+
+	tmp := ShellQuote(stage.file)
+	result, err := c.Connection.ExecuteCommand(fmt.Sprintf("(umask 077 && : > %s)", tmp))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("创建临时文件失败: %s", strings.TrimSpace(result.Stderr))
+	}
+	if err := c.grant(stage, "w"); err != nil {
+		return err
+	}
+
+	src := ShellQuote(remotePath)
+	result, err = c.ExecuteCommand(fmt.Sprintf("stat -c %%a %s && cat %s > %s", src, src, tmp))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("提权读取文件失败: %s", strings.TrimSpace(result.Stderr+result.Stdout))
+	}
+
+	// 保留远程文件的权限
+	fetchOpts := &TransferOptions{}
+	if opts != nil {
+		*fetchOpts = *opts
+	}
+	if fetchOpts.Mode == 0 {
+		if perm, err := strconv.ParseUint(strings.TrimSpace(result.Stdout), 8, 32); err == nil {
+			fetchOpts.Mode = os.FileMode(perm)
+		}
+	}
+	return FetchFileWithOptions(c.Connection, stage.file, localPath, fetchOpts)
+}
+
+// becomeStage 提权传输文件时登录用户创建的临时目录，目录权限为0700，其中的文件只有登录用户和被授权的提权用户可以访问
+type becomeStage struct {
+	conn Connection
+	dir  string
+	file string
+}
+
+// newStage 以登录用户创建临时目录
+func (c *BecomeConnection) newStage() (*becomeStage, error) {
+	result, err := c.Connection.ExecuteCommand("mktemp -d " + ShellQuote(becomeTempDir+"/.ansible-go-become-XXXXXXXXXX"))
+	if err != nil {
+		return nil, err
+	}
+	dir := strings.TrimSpace(result.Stdout)
+	if result.ExitCode != 0 || dir == "" {
+		return nil, fmt.Errorf("创建临时目录失败: %s", strings.TrimSpace(result.Stderr))
+	}
+	return &becomeStage{conn: c.Connection, dir: dir, file: dir + "/file"}, nil
+}
+
+// remove 删除临时目录，忽略错误
+func (s *becomeStage) remove() {
+	s.conn.ExecuteCommand("rm -rf " + ShellQuote(s.dir))
+}
+
+// grant 允许提权用户以perm（r或w）访问临时文件，提权用户为root时不需要授权
+// 优先使用ACL只授权提权用户；远程主机不支持ACL时将文件属主改为提权用户，这需要登录用户为root。
+// 两者都失败时返回错误，不会放宽为所有用户可访问
+func (c *BecomeConnection) grant(stage *becomeStage, perm string) error {
+	if c.become.User == "root" {
+		return nil
+	}
+
+	user := ShellQuote(c.become.User)
+	dir, file := ShellQuote(stage.dir), ShellQuote(stage.file)
+	result, err := c.Connection.ExecuteCommand(fmt.Sprintf(
+		"{ setfacl -m u:%s:x %s && setfacl -m u:%s:%s %s; } 2>/dev/null || { chown %s %s && chmod 0711 %s; }",
+		user, dir, user, perm, file, user, file, dir))
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("无法授权用户%s访问临时文件，请在远程主机上安装setfacl: %s", c.become.User, strings.TrimSpace(result.Stderr))
+	}
+	return nil
+}
+
+// becomeSession 处理一次提权命令的交互和输出
+type becomeSession struct {
+	mu         sync.Mutex
+	password   string
+	prompt     []byte // sudo的密码提示，为空时在伪终端输出中匹配密码提示
+	marker     *regexp.Regexp
+	stdin      *io.PipeWriter
+	input      io.Reader // 提权成功后写入命令标准输入的内容，可以为nil
+	succeeded  bool
+	answered   int
+	prompts    int
+	noAnswer   bool // 需要密码但未提供或密码错误
+	pending    bytes.Buffer
+	stdout     bytes.Buffer
+	stderr     bytes.Buffer
+	liveStdout io.Writer // 提权成功后同时写入的标准输出，可以为nil
+	liveStderr io.Writer // 提权成功后同时写入的标准错误，可以为nil
+}
+
+// writeStdout 处理标准输出，提权成功标记之前的内容用于识别密码提示
+func (s *becomeSession) writeStdout(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.succeeded {
+		s.writeLive(s.liveStdout, p)
+		return s.stdout.Write(p)
+	}
+
+	s.pending.Write(p)
+	data := s.pending.Bytes()
+	// 标记必须单独成行，避免命令回显或错误信息中包含的标记被误判
+	if loc := s.marker.FindIndex(data); loc != nil {
+		s.succeeded = true
+		s.stdout.Write(data[loc[1]:])
+		s.writeLive(s.liveStdout, data[loc[1]:])
+		s.forwardInput()
+		return len(p), nil
+	}
+
+	// 使用伪终端时sudo的密码提示也出现在标准输出中
+	if (s.prompt == nil && passwordPromptPattern.Match(data)) || (s.prompt != nil && bytes.Contains(data, s.prompt)) {
+		s.pending.Reset()
+		s.answer()
+	}
+	return len(p), nil
+}
+
+// forwardInput 提权成功后将调用方的输入写入命令的标准输入，写完后关闭；没有输入时直接关闭
+// 写入在单独的goroutine中进行，命令结束时关闭标准输入使写入返回
+func (s *becomeSession) forwardInput() {
+	if s.input == nil {
+		s.closeStdinLocked()
+		return
+	}
+	go func() {
+		io.Copy(s.stdin, s.input)
+		s.stdin.Close()
+	}()
+}
+
+// writeStderr 处理标准错误，识别sudo的密码提示
+func (s *becomeSession) writeStderr(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.stderr.Write(p)
+	if s.succeeded {
+		s.writeLive(s.liveStderr, p)
+		return len(p), nil
+	}
+	if s.prompt == nil {
+		return len(p), nil
+	}
+	if n := bytes.Count(s.stderr.Bytes(), s.prompt); n > s.prompts {
+		s.prompts = n
+		s.answer()
+	}
+	return len(p), nil
+}
+
+// writeLive 写入提权成功后的实时输出
+func (s *becomeSession) writeLive(w io.Writer, p []byte) {
+	if w != nil && len(p) > 0 {
+		w.Write(p)
+	}
+}
+
+// answer 应答密码提示，只应答一次，再次出现提示说明密码错误
+// 写入在单独的goroutine中进行，避免输出处理阻塞在等待命令读取输入上
+func (s *becomeSession) answer() {
+	if s.password == "" || s.answered > 0 {
+		s.noAnswer = true
+		s.closeStdinLocked()
+		return
+	}
+	s.answered++
+	go s.stdin.Write([]byte(s.password + "\n"))
+}
+
+// closeStdin 关闭命令的标准输入
+func (s *becomeSession) closeStdin() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.closeStdinLocked()
+}
+
+// closeStdinLocked 在持有锁时关闭命令的标准输入
+func (s *becomeSession) closeStdinLocked() {
+	if s.stdin != nil {
+		s.stdin.Close()
+	}
+}
+
+// result 生成命令执行结果，未出现提权成功标记时返回提权失败的错误
+func (s *becomeSession) result(become *types.BecomeConfig, exitCode int, pty bool) (*ConnectionResult, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stderr := s.stderr.String()
+	if s.prompt != nil {
+		stderr = strings.ReplaceAll(stderr, string(s.prompt), "")
+	}
+
+	if !s.succeeded {
+		msg := strings.TrimSpace(stderr + s.pending.String())
+		if s.noAnswer && s.answered == 0 {
+			return nil, fmt.Errorf("使用%s切换到用户%s需要密码，请设置become.password、主机变量ansible_become_password或使用-ask-become-pass参数", become.Method, become.User)
+		}
+		if s.noAnswer {
+			return nil, fmt.Errorf("使用%s切换到用户%s失败，密码错误: %s", become.Method, become.User, msg)
+		}
+		return nil, fmt.Errorf("使用%s切换到用户%s失败(退出码%d): %s", become.Method, become.User, exitCode, msg)
+	}
+
+	stdout := s.stdout.String()
+	if pty {
+		// 伪终端将换行转换为\r\n
+		stdout = strings.ReplaceAll(stdout, "\r\n", "\n")
+	}
+	return &ConnectionResult{
+		Stdout:   stdout,
+		Stderr:   strings.TrimLeft(stderr, "\n"),
+		ExitCode: exitCode,
+	}, nil
+}
+
+// writerFunc 将函数适配为io.Writer
+type writerFunc func(p []byte) (int, error)
+
+// Write 实现io.Writer接口
+func (f writerFunc) Write(p []byte) (int, error) {
+	return f(p)
+}
+
+// randomHex 生成指定字节数的随机十六进制字符串
+func randomHex(n int) string {
+	b := make([]byte, n)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
@@ -0,0 +1,185 @@
+package connection
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+)
+
+// recordingConnection 记录收到的命令和文件传输，提权命令直接输出提权成功标记
+type recordingConnection struct {
+	commands  []string
+	uploads   map[string]os.FileMode
+	stageDir  string
+	grantFail bool
+}
+
+var becomeKeyPattern = regexp.MustCompile(becomeSuccessPrefix + `[0-9a-f]+`)
+
+func (r *recordingConnection) Connect() error          { return nil }
+func (r *recordingConnection) Disconnect() error       { return nil }
+func (r *recordingConnection) IsConnected() bool       { return true }
+func (r *recordingConnection) GetType() ConnectionType { return ConnectionTypeSSH }
+
+func (r *recordingConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	r.commands = append(r.commands, command)
+	switch {
+	case strings.HasPrefix(command, "mktemp -d "):
+		return &ConnectionResult{Stdout: r.stageDir + "\n"}, nil
+	case strings.Contains(command, "setfacl") && r.grantFail:
+		return &ConnectionResult{Stderr: "chown: operation not permitted", ExitCode: 1}, nil
+	}
+	return &ConnectionResult{}, nil
+}
+
+func (r *recordingConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	r.commands = append(r.commands, command)
+	fmt.Fprintln(opts.Stdout, becomeKeyPattern.FindString(command))
+	return 0, nil
+}
+
+func (r *recordingConnection) CopyFile(localPath, remotePath string) error {
+	return r.CopyFileWithOptions(localPath, remotePath, nil)
+}
+
+func (r *recordingConnection) FetchFile(remotePath, localPath string) error {
+	return nil
+}
+
+func (r *recordingConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	r.uploads[remotePath] = opts.Mode
+	return nil
+}
+
+func (r *recordingConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	return nil
+}
+
+func newRecordingConnection() *recordingConnection {
+	return &recordingConnection{uploads: make(map[string]os.FileMode), stageDir: "/tmp/.ansible-go-become-Ab3dE"}
+}
+
+func TestBecomeCopyStagesInPrivateDirectory(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(local, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, user := range []string{"root", "app"} {
+		rec := newRecordingConnection()
+		conn := NewBecomeConnection(rec, &types.BecomeConfig{User: user})
+		if err := conn.CopyFile(local, "/etc/app.conf"); err != nil {
+			t.Fatalf("%s: %v", user, err)
+		}
+
+		staged := rec.stageDir + "/file"
+		if mode, ok := rec.uploads[staged]; !ok || mode != 0600 {
+			t.Fatalf("%s: uploads = %v, want %s with mode 0600", user, rec.uploads, staged)
+		}
+		all := strings.Join(rec.commands, "\n")
+		if granted := strings.Contains(all, "setfacl"); granted != (user != "root") {
+			t.Fatalf("%s: setfacl issued = %v:\n%s", user, granted, all)
+		}
+		if last := rec.commands[len(rec.commands)-1]; last != "rm -rf "+ShellQuote(rec.stageDir) {
+			t.Fatalf("%s: last command = %q, want staging directory removed", user, last)
+		}
+	}
+}
+
+func TestBecomeCopyFailsWhenGrantFails(t *testing.T) {
+	local := filepath.Join(t.TempDir(), "app.conf")
+	if err := os.WriteFile(local, []byte("x"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	rec := newRecordingConnection()
+	rec.grantFail = true
+	conn := NewBecomeConnection(rec, &types.BecomeConfig{User: "app"})
+	if err := conn.CopyFile(local, "/etc/app.conf"); err == nil || !strings.Contains(err.Error(), "setfacl") {
+		t.Fatalf("err = %v, want grant failure", err)
+	}
+	for _, command := range rec.commands {
+		if strings.Contains(command, "cp ") {
+			t.Fatalf("copied after failed grant: %q", command)
+		}
+	}
+}
+
+// sudoStub 模拟sudo：在标准错误输出-p指定的提示，读取一行密码，密码正确时执行--之后的命令
+const sudoStub = `#!/bin/sh
+printf '%s' "$4" >&2
+IFS= read -r password
+[ "$password" = secret ] || { echo "Sorry, try again." >&2; exit 1; }
+shift 7
+exec "$@"
+`
+
+func TestBecomeForwardsStdinAfterPassword(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "sudo"), []byte(sudoStub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("PATH", dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	local := NewLocalConnection()
+	if err := local.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	conn := NewBecomeConnection(local, &types.BecomeConfig{Password: "secret"})
+	result, err := ExecuteCommandWithOptions(conn, "cat; echo done", &CommandOptions{Stdin: "yes\n/opt/app\n"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 0 || result.Stdout != "yes\n/opt/app\ndone\n" {
+		t.Fatalf("result = %+v, want stdin passed to the command after the password", result)
+	}
+}
+
+// ptyConnection 模拟在伪终端中执行sudo：密码提示和命令输出都写入标准输出
+type ptyConnection struct {
+	*recordingConnection
+	pty bool
+}
+
+var sudoPromptPattern = regexp.MustCompile(`\[sudo via ansible-go, key=[0-9a-f]+\] password:`)
+
+func (c *ptyConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	c.pty = opts.Pty
+	io.WriteString(opts.Stdout, sudoPromptPattern.FindString(command))
+	input := bufio.NewReader(opts.Stdin)
+	if password, _ := input.ReadString('\n'); password != "secret\n" {
+		return 1, nil
+	}
+	fmt.Fprintf(opts.Stdout, "\r\n%s\r\n", becomeKeyPattern.FindString(command))
+	io.Copy(opts.Stdout, input)
+	return 0, nil
+}
+
+func TestBecomeSudoWithPty(t *testing.T) {
+	inner := &ptyConnection{recordingConnection: newRecordingConnection()}
+	conn := NewBecomeConnection(inner, &types.BecomeConfig{Password: "secret"})
+
+	var stdout bytes.Buffer
+	code, err := conn.ExecuteStream("installer", &StreamOptions{
+		Stdin:  strings.NewReader("yes\n"),
+		Stdout: &stdout,
+		Pty:    true,
+	})
+	if err != nil || code != 0 {
+		t.Fatalf("ExecuteStream = %d, %v", code, err)
+	}
+	if !inner.pty {
+		t.Fatal("pty not requested for sudo")
+	}
+	if stdout.String() != "yes\n" {
+		t.Fatalf("stdout = %q, want only the forwarded input", stdout.String())
+	}
+}
@@ -0,0 +1,186 @@
+package connection
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// maxSymlinks 解析根目录中的路径时最多跟随的符号链接数量，与Linux的限制一致
+const maxSymlinks = 40
+
+// ChrootConnection 在本机的根目录中执行命令，用于离线配置挂载的镜像文件系统
+// 使用chroot或systemd-nspawn执行命令，文件传输直接读写根目录下对应的路径，需要以root运行
+type ChrootConnection struct {
+	Root   string // 根目录
+	User   string // 执行命令的用户，为空时使用root
+	nspawn bool   // 是否使用systemd-nspawn
+
+	connected bool
+}
+
+// NewChrootConnection 创建使用chroot执行命令的连接
+func NewChrootConnection(root string) *ChrootConnection {
+	return &ChrootConnection{Root: root}
+}
+
+// NewNspawnConnection 创建使用systemd-nspawn执行命令的连接
+// 与chroot相比，命令在独立的命名空间中执行，/proc、/sys、/dev由systemd-nspawn挂载
+func NewNspawnConnection(root string) *ChrootConnection {
+	return &ChrootConnection{Root: root, nspawn: true}
+}
+
+// Connect 检查根目录是否存在
+func (c *ChrootConnection) Connect() error {
+	info, err := os.Stat(c.Root)
+	if err != nil {
+		return fmt.Errorf("检查根目录 %s 失败: %w", c.Root, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("根目录 %s 不是目录", c.Root)
+	}
+	c.connected = true
+	return nil
+}
+
+// Disconnect 断开连接
+func (c *ChrootConnection) Disconnect() error {
+	c.connected = false
+	return nil
+}
+
+// IsConnected 检查是否已连接
+func (c *ChrootConnection) IsConnected() bool {
+	return c.connected
+}
+
+// ExecuteCommand 在根目录中执行命令
+func (c *ChrootConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("连接未建立")
+	}
+	return runProcess(c.command(command, nil)), nil
+}
+
+// ExecuteStream 实现StreamExecutor接口，以流方式在根目录中执行命令
+func (c *ChrootConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	if !c.IsConnected() {
+		return -1, fmt.Errorf("连接未建立")
+	}
+	if opts.Pty {
+		return -1, fmt.Errorf("%s连接不支持伪终端", c.GetType())
+	}
+	return streamProcess(c.command(command, opts.Env), opts)
+}
+
+// command 构造在根目录中通过/bin/sh执行命令的进程
+// chroot中的命令继承本进程的环境变量，systemd-nspawn中的命令只能通过--setenv设置环境变量
+func (c *ChrootConnection) command(command string, env map[string]string) *exec.Cmd {
+	if c.nspawn {
+		// 不向systemd-machined注册，标准输入输出直接连接到命令
+		args := []string{"--quiet", "--register=no", "--console=pipe", "--directory", c.Root}
+		if c.User != "" {
+			args = append(args, "--user", c.User)
+		}
+		for _, pair := range envList(env) {
+			args = append(args, "--setenv="+pair)
+		}
+		return exec.Command("systemd-nspawn", append(args, "/bin/sh", "-c", command)...)
+	}
+
+	args := []string{}
+	if c.User != "" {
+		args = append(args, "--userspec", c.User)
+	}
+	cmd := exec.Command("chroot", append(args, c.Root, "/bin/sh", "-c", command)...)
+	cmd.Env = processEnv(env)
+	return cmd
+}
+
+// CopyFile 复制文件到根目录下的对应路径，保留本地文件权限
+func (c *ChrootConnection) CopyFile(localPath, remotePath string) error {
+	return c.CopyFileWithOptions(localPath, remotePath, nil)
+}
+
+// FetchFile 从根目录下的对应路径获取文件，保留文件权限
+func (c *ChrootConnection) FetchFile(remotePath, localPath string) error {
+	return c.FetchFileWithOptions(remotePath, localPath, nil)
+}
+
+// CopyFileWithOptions 复制文件到根目录下的对应路径，先写入临时文件再重命名
+func (c *ChrootConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("连接未建立")
+	}
+	dst, err := c.HostPath(remotePath)
+	if err != nil {
+		return err
+	}
+	return copyLocalFile(localPath, dst, opts)
+}
+
+// FetchFileWithOptions 从根目录下的对应路径获取文件，先写入临时文件再重命名
+func (c *ChrootConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("连接未建立")
+	}
+	src, err := c.HostPath(remotePath)
+	if err != nil {
+		return err
+	}
+	return copyLocalFile(src, localPath, opts)
+}
+
+// HostPath 将根目录中的路径转换为本机路径
+// 路径中的符号链接按根目录解析，镜像中指向绝对路径的链接（如/etc/resolv.conf）不会指向本机的文件
+func (c *ChrootConnection) HostPath(p string) (string, error) {
+	pending := strings.Split(path.Clean("/"+p), "/")
+	resolved := "/"
+	links := 0
+
+	for len(pending) > 0 {
+		name := pending[0]
+		pending = pending[1:]
+		switch name {
+		case "", ".":
+			continue
+		case "..":
+			resolved = path.Dir(resolved)
+			continue
+		}
+
+		next := path.Join(resolved, name)
+		info, err := os.Lstat(filepath.Join(c.Root, next))
+		if err != nil || info.Mode()&os.ModeSymlink == 0 {
+			// 不存在的路径按原样拼接，由后续的读写报告错误
+			resolved = next
+			continue
+		}
+
+		links++
+		if links > maxSymlinks {
+			return "", fmt.Errorf("解析路径 %s 失败: 符号链接过多", p)
+		}
+		target, err := os.Readlink(filepath.Join(c.Root, next))
+		if err != nil {
+			return "", fmt.Errorf("解析路径 %s 失败: %w", p, err)
+		}
+		if path.IsAbs(target) {
+			resolved = "/"
+		}
+		pending = append(strings.Split(target, "/"), pending...)
+	}
+
+	return filepath.Join(c.Root, resolved), nil
+}
+
+// GetType 获取连接类型
+func (c *ChrootConnection) GetType() ConnectionType {
+	if c.nspawn {
+		return ConnectionTypeNspawn
+	}
+	return ConnectionTypeChroot
+}
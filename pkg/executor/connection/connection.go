@@ -1,7 +1,14 @@
 package connection
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strings"
 	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
 )
 
 // ConnectionType 定义连接类型
@@ -16,6 +23,10 @@ const (
 	ConnectionTypeLocal ConnectionType = "local"
 	// ConnectionTypeDocker Docker连接类型
 	ConnectionTypeDocker ConnectionType = "docker"
+	// ConnectionTypeChroot chroot连接类型
+	ConnectionTypeChroot ConnectionType = "chroot"
+	// ConnectionTypeNspawn systemd-nspawn连接类型
+	ConnectionTypeNspawn ConnectionType = "nspawn"
 )
 
 // ConnectionResult 定义连接执行结果
@@ -27,6 +38,102 @@ type ConnectionResult struct {
 	Error    error         // 错误信息
 }
 
+// HostParams 定义解析后的单个主机连接参数
+type HostParams struct {
+	Host           string                 // 清单中的主机名，用于标识主机
+	Address        string                 // 实际连接地址，为空时使用Host
+	Port           int                    // 端口号
+	User           string                 // 用户名，为空时使用全局配置
+	Password       string                 // 密码，为空时使用全局配置
+	KeyFile        string                 // 私钥文件路径，为空时使用全局配置
+	CertFile       string                 // 与KeyFile配对的证书文件，为空时尝试KeyFile加-cert.pub
+	KeyPassword    string                 // 私钥密码，为空时使用全局配置
+	Type           ConnectionType         // 连接类型
+	ForwardAgent   bool                   // 是否将本地ssh-agent转发到该主机
+	IdentityFiles  []string               // 额外的私钥文件，在KeyFile之后尝试
+	JumpHosts      []types.JumpHostConfig // 依次经过的跳板机，为nil时使用ssh_config或全局配置，为空列表时直接连接
+	Proxy          string                 // SOCKS5或HTTP CONNECT代理地址，为空时使用全局配置，为"none"时直接连接
+	BecomePassword string                 // 提权密码，为空时使用命令行输入或全局配置，不影响连接复用
+	DockerBinary   string                 // docker可执行文件路径，只用于docker连接，为空时使用docker
+}
+
+// NewHostParams 创建主机连接参数，localhost和回环地址使用本地连接，其他主机使用SSH连接
+// 未设置的地址、端口和用户在连接时使用ssh_config或全局SSH配置
+func NewHostParams(host string) *HostParams {
+	params := &HostParams{Host: host}
+	params.Type = params.DefaultType()
+	return params
+}
+
+// DefaultType 获取未指定连接类型时使用的连接类型
+// 地址为localhost或回环地址且未指定端口和跳板机时使用本地连接，其他情况使用SSH
+func (p *HostParams) DefaultType() ConnectionType {
+	if p.Port == 0 && len(p.JumpHosts) == 0 && IsLocalAddress(p.Addr()) {
+		return ConnectionTypeLocal
+	}
+	return ConnectionTypeSSH
+}
+
+// IsLocalAddress 判断地址是否指向控制节点本机
+func IsLocalAddress(addr string) bool {
+	if strings.EqualFold(addr, "localhost") {
+		return true
+	}
+	ip := net.ParseIP(addr)
+	return ip != nil && ip.IsLoopback()
+}
+
+// Addr 获取实际连接地址
+func (p *HostParams) Addr() string {
+	if p.Address != "" {
+		return p.Address
+	}
+	return p.Host
+}
+
+// Key 获取连接池中的键，同一地址、端口、用户、跳板机链、代理、认证身份和agent转发设置的主机共享连接
+func (p *HostParams) Key() string {
+	switch p.Type {
+	case ConnectionTypeLocal:
+		return string(ConnectionTypeLocal)
+	case ConnectionTypeDocker:
+		return fmt.Sprintf("%s:%s:%s:%s", p.Type, p.DockerBinary, p.Addr(), p.User)
+	case ConnectionTypeChroot, ConnectionTypeNspawn:
+		return fmt.Sprintf("%s:%s:%s", p.Type, p.Addr(), p.User)
+	}
+	key := fmt.Sprintf("%s:%s:%d:%s", p.Type, p.Addr(), p.Port, p.User)
+	if len(p.JumpHosts) > 0 {
+		key += " via " + jumpHostKey(p.JumpHosts)
+	}
+	if p.Proxy != "" {
+		key += " proxy " + p.Proxy
+	}
+	return key + p.authKey()
+}
+
+// authKey 获取连接池键中的认证部分，私钥、证书、额外私钥、密码或agent转发不同的主机不能共享连接
+// 密码和私钥密码只以摘要的形式出现在键中
+func (p *HostParams) authKey() string {
+	var b strings.Builder
+	if p.KeyFile != "" {
+		b.WriteString(" key " + p.KeyFile)
+	}
+	if p.CertFile != "" {
+		b.WriteString(" cert " + p.CertFile)
+	}
+	if len(p.IdentityFiles) > 0 {
+		b.WriteString(" identities " + strings.Join(p.IdentityFiles, ","))
+	}
+	if p.Password != "" || p.KeyPassword != "" {
+		sum := sha256.Sum256([]byte(p.Password + "\x00" + p.KeyPassword))
+		b.WriteString(" secret " + hex.EncodeToString(sum[:8]))
+	}
+	if p.ForwardAgent {
+		b.WriteString(" forward-agent")
+	}
+	return b.String()
+}
+
 // Connection 定义连接接口
 type Connection interface {
 	// Connect 建立连接
@@ -55,12 +162,21 @@ type Connection interface {
 type ConnectionManager interface {
 	// GetConnection 获取指定主机的连接
 	GetConnection(host string, port int, connType ConnectionType) (Connection, error)
+
+	// GetHostConnection 按解析后的主机参数获取连接
+	GetHostConnection(params *HostParams) (Connection, error)
+
+	// ResolveHostParams 使用ssh_config和全局SSH配置补全主机参数，返回实际使用的连接参数
+	ResolveHostParams(params *HostParams) (*HostParams, error)
 	
 	// ReleaseConnection 释放连接
 	ReleaseConnection(conn Connection)
 	
 	// CloseConnection 关闭指定连接
 	CloseConnection(host string, port int, connType ConnectionType) error
+
+	// CloseHostConnection 按解析后的主机参数关闭连接
+	CloseHostConnection(params *HostParams) error
 	
 	// CloseAll 关闭所有连接
 	CloseAll()
@@ -0,0 +1,43 @@
+package connection
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestHostParamsKeySeparatesAuthIdentity(t *testing.T) {
+	base := HostParams{Host: "web1", Port: 22, User: "deploy", Type: ConnectionTypeSSH}
+
+	variants := map[string]func(p *HostParams){
+		"key file":      func(p *HostParams) { p.KeyFile = "/keys/a" },
+		"cert file":     func(p *HostParams) { p.CertFile = "/keys/a-cert.pub" },
+		"identities":    func(p *HostParams) { p.IdentityFiles = []string{"/keys/b"} },
+		"password":      func(p *HostParams) { p.Password = "secret-one" },
+		"key password":  func(p *HostParams) { p.KeyPassword = "secret-two" },
+		"forward agent": func(p *HostParams) { p.ForwardAgent = true },
+	}
+
+	seen := map[string]string{base.Key(): "base"}
+	for name, apply := range variants {
+		p := base
+		apply(&p)
+		key := p.Key()
+		if other, ok := seen[key]; ok {
+			t.Fatalf("%s shares pool key %q with %s", name, key, other)
+		}
+		seen[key] = name
+	}
+}
+
+func TestHostParamsKeyHidesPasswords(t *testing.T) {
+	p := HostParams{Host: "web1", Port: 22, User: "deploy", Type: ConnectionTypeSSH, Password: "hunter2", KeyPassword: "swordfish"}
+	key := p.Key()
+	if strings.Contains(key, "hunter2") || strings.Contains(key, "swordfish") {
+		t.Fatalf("pool key %q contains a plaintext password", key)
+	}
+
+	same := p
+	if same.Key() != key {
+		t.Fatal("identical parameters produced different pool keys")
+	}
+}
@@ -0,0 +1,189 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// DockerConnection 通过docker exec在容器中执行命令，通过docker cp传输文件
+// 兼容docker命令行的其他工具（如podman）可以通过Binary指定
+type DockerConnection struct {
+	Container string // 容器名称或ID
+	User      string // 执行命令的用户，为空时使用镜像的默认用户
+	Binary    string // docker可执行文件路径
+
+	connected bool
+}
+
+// NewDockerConnection 创建新的docker连接
+func NewDockerConnection(container string) *DockerConnection {
+	return &DockerConnection{
+		Container: container,
+		Binary:    "docker",
+	}
+}
+
+// Connect 检查容器是否存在并正在运行
+func (c *DockerConnection) Connect() error {
+	output, err := c.run("inspect", "--format", "{{.State.Running}}", c.Container)
+	if err != nil {
+		return fmt.Errorf("检查容器 %s 失败: %w", c.Container, err)
+	}
+	if strings.TrimSpace(output) != "true" {
+		return fmt.Errorf("容器 %s 未运行", c.Container)
+	}
+	c.connected = true
+	return nil
+}
+
+// Disconnect 断开连接，容器本身不受影响
+func (c *DockerConnection) Disconnect() error {
+	c.connected = false
+	return nil
+}
+
+// IsConnected 检查是否已连接
+func (c *DockerConnection) IsConnected() bool {
+	return c.connected
+}
+
+// ExecuteCommand 在容器中执行命令
+func (c *DockerConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	if !c.IsConnected() {
+		return nil, fmt.Errorf("连接未建立")
+	}
+	return runProcess(exec.Command(c.Binary, c.execArgs(c.User, false, false, nil, command)...)), nil
+}
+
+// ExecuteStream 实现StreamExecutor接口，以流方式在容器中执行命令
+func (c *DockerConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	if !c.IsConnected() {
+		return -1, fmt.Errorf("连接未建立")
+	}
+	return streamProcess(exec.Command(c.Binary, c.execArgs(c.User, opts.Stdin != nil, opts.Pty, opts.Env, command)...), opts)
+}
+
+// execArgs 构造docker exec的参数，命令通过容器中的/bin/sh执行，环境变量通过-e设置
+func (c *DockerConnection) execArgs(user string, interactive, tty bool, env map[string]string, command string) []string {
+	args := []string{"exec"}
+	if interactive {
+		args = append(args, "-i")
+	}
+	if tty {
+		args = append(args, "-t")
+	}
+	if user != "" {
+		args = append(args, "-u", user)
+	}
+	for _, pair := range envList(env) {
+		args = append(args, "-e", pair)
+	}
+	return append(args, c.Container, "/bin/sh", "-c", command)
+}
+
+// CopyFile 复制文件到容器，保留本地文件权限
+func (c *DockerConnection) CopyFile(localPath, remotePath string) error {
+	return c.CopyFileWithOptions(localPath, remotePath, nil)
+}
+
+// FetchFile 从容器获取文件，保留容器中文件的权限
+func (c *DockerConnection) FetchFile(remotePath, localPath string) error {
+	return c.FetchFileWithOptions(remotePath, localPath, nil)
+}
+
+// CopyFileWithOptions 使用docker cp将文件复制到容器中的临时文件，设置权限和属主后重命名为目标文件
+// docker cp创建的文件属于root，指定了用户时将文件属主改为该用户；docker cp不报告传输进度
+func (c *DockerConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("连接未建立")
+	}
+
+	info, err := os.Stat(localPath)
+	if err != nil {
+		return fmt.Errorf("读取本地文件信息失败: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("不支持复制目录: %s", localPath)
+	}
+	mode := transferMode(opts, info.Mode())
+
+	tmpPath := remoteTempPath(remotePath)
+	if _, err := c.run("cp", localPath, c.Container+":"+tmpPath); err != nil {
+		return fmt.Errorf("docker cp复制文件失败: %w", err)
+	}
+
+	tmp := ShellQuote(tmpPath)
+	script := fmt.Sprintf("chmod %04o %s", mode, tmp)
+	if c.User != "" {
+		script += fmt.Sprintf(" && chown %s %s", ShellQuote(c.User), tmp)
+	}
+	script += fmt.Sprintf(" && mv -f %s %s || { rm -f %s; exit 1; }", tmp, ShellQuote(remotePath), tmp)
+
+	// 临时文件属于root，以root设置权限和重命名
+	if _, err := c.run(c.execArgs("0", false, false, nil, script)...); err != nil {
+		return fmt.Errorf("重命名容器中的文件失败: %w", err)
+	}
+	return nil
+}
+
+// FetchFileWithOptions 使用docker cp将容器中的文件复制到本地临时目录，完成后重命名为目标文件
+func (c *DockerConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	if !c.IsConnected() {
+		return fmt.Errorf("连接未建立")
+	}
+
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建本地目录失败: %w", err)
+	}
+	tmpDir, err := os.MkdirTemp(dir, "."+filepath.Base(localPath)+".ansible-go-tmp-*")
+	if err != nil {
+		return fmt.Errorf("创建本地临时目录失败: %w", err)
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// -L跟随容器中的符号链接，获取链接指向的文件
+	tmpPath := filepath.Join(tmpDir, "file")
+	if _, err := c.run("cp", "-L", c.Container+":"+remotePath, tmpPath); err != nil {
+		return fmt.Errorf("docker cp获取文件失败: %w", err)
+	}
+
+	info, err := os.Stat(tmpPath)
+	if err != nil {
+		return fmt.Errorf("读取本地文件信息失败: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("不支持获取目录: %s", remotePath)
+	}
+	if err := os.Chmod(tmpPath, transferMode(opts, info.Mode())); err != nil {
+		return fmt.Errorf("设置本地文件权限失败: %w", err)
+	}
+	if err := os.Rename(tmpPath, localPath); err != nil {
+		return fmt.Errorf("重命名本地文件失败: %w", err)
+	}
+	return nil
+}
+
+// GetType 获取连接类型
+func (c *DockerConnection) GetType() ConnectionType {
+	return ConnectionTypeDocker
+}
+
+// run 执行docker命令，失败时返回包含标准错误的错误
+func (c *DockerConnection) run(args ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := exec.Command(c.Binary, args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return "", fmt.Errorf("%w: %s", err, msg)
+		}
+		return "", err
+	}
+	return stdout.String(), nil
+}
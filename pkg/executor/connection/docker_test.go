@@ -0,0 +1,180 @@
+package connection
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// dockerStub 模拟docker命令行：记录每次调用的参数，inspect输出容器运行状态，
+// cp在本机复制文件（去掉容器名前缀），exec跳过选项和容器名后在本机执行命令
+const dockerStub = `#!/bin/sh
+printf '%s\037' "$@" >> "$DOCKER_STUB_LOG"
+echo >> "$DOCKER_STUB_LOG"
+case "$1" in
+inspect)
+	echo "${DOCKER_STUB_RUNNING:-true}"
+	;;
+cp)
+	shift
+	[ "$1" = -L ] && shift
+	exec cp "${1#*:}" "${2#*:}"
+	;;
+exec)
+	shift
+	while :; do
+		case "$1" in
+		-i|-t) shift ;;
+		-u) shift 2 ;;
+		-e) export "$2"; shift 2 ;;
+		*) break ;;
+		esac
+	done
+	shift
+	exec "$@"
+	;;
+esac
+`
+
+// newStubDocker 创建使用dockerStub的连接，返回读取调用记录的函数
+func newStubDocker(t *testing.T) (*DockerConnection, func() [][]string) {
+	t.Helper()
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "docker")
+	if err := os.WriteFile(binary, []byte(dockerStub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "calls")
+	t.Setenv("DOCKER_STUB_LOG", log)
+
+	conn := NewDockerConnection("web")
+	conn.Binary = binary
+	return conn, func() [][]string {
+		data, _ := os.ReadFile(log)
+		var calls [][]string
+		for _, line := range strings.Split(strings.TrimSuffix(string(data), "\n"), "\n") {
+			if line != "" {
+				calls = append(calls, strings.Split(strings.TrimSuffix(line, "\x1f"), "\x1f"))
+			}
+		}
+		return calls
+	}
+}
+
+func TestDockerExecArgs(t *testing.T) {
+	conn := NewDockerConnection("web")
+	tests := []struct {
+		name        string
+		user        string
+		interactive bool
+		tty         bool
+		env         map[string]string
+		want        []string
+	}{
+		{"plain", "", false, false, nil, []string{"exec", "web", "/bin/sh", "-c", "id"}},
+		{"stdin", "", true, false, nil, []string{"exec", "-i", "web", "/bin/sh", "-c", "id"}},
+		{"pty", "", true, true, nil, []string{"exec", "-i", "-t", "web", "/bin/sh", "-c", "id"}},
+		{"user", "app", false, false, nil, []string{"exec", "-u", "app", "web", "/bin/sh", "-c", "id"}},
+		{"env", "", false, false, map[string]string{"B": "2", "A": "1 2"},
+			[]string{"exec", "-e", "A=1 2", "-e", "B=2", "web", "/bin/sh", "-c", "id"}},
+	}
+	for _, tt := range tests {
+		if got := conn.execArgs(tt.user, tt.interactive, tt.tty, tt.env, "id"); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: execArgs = %q, want %q", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestDockerConnectRequiresRunningContainer(t *testing.T) {
+	conn, _ := newStubDocker(t)
+	t.Setenv("DOCKER_STUB_RUNNING", "false")
+	if err := conn.Connect(); err == nil || conn.IsConnected() {
+		t.Fatalf("Connect to stopped container: err=%v connected=%v", err, conn.IsConnected())
+	}
+}
+
+func TestDockerExecuteCommandExitCode(t *testing.T) {
+	conn, calls := newStubDocker(t)
+	conn.User = "app"
+	if err := conn.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	result, err := conn.ExecuteCommand("echo out; echo err >&2; exit 3")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 3 || result.Stdout != "out\n" || result.Stderr != "err\n" || result.Error != nil {
+		t.Fatalf("result = %+v, want exit 3 with separated output", result)
+	}
+	got := calls()[1]
+	want := []string{"exec", "-u", "app", "web", "/bin/sh", "-c", "echo out; echo err >&2; exit 3"}
+	if !reflect.DeepEqual(got, want) {
+		t.Fatalf("docker args = %q, want %q", got, want)
+	}
+
+	var stdout bytes.Buffer
+	code, err := conn.ExecuteStream(`echo "$GREETING"; exit 5`, &StreamOptions{
+		Stdout: &stdout,
+		Stderr: &bytes.Buffer{},
+		Env:    map[string]string{"GREETING": "hello"},
+	})
+	if err != nil || code != 5 || stdout.String() != "hello\n" {
+		t.Fatalf("ExecuteStream = %d, %v, stdout %q", code, err, stdout.String())
+	}
+
+	conn.Binary = filepath.Join(t.TempDir(), "missing")
+	result, err = conn.ExecuteCommand("true")
+	if err != nil || result.ExitCode != -1 || result.Error == nil {
+		t.Fatalf("missing binary: result=%+v err=%v, want exit -1 with error", result, err)
+	}
+}
+
+func TestDockerCopyFileWithOptions(t *testing.T) {
+	conn, calls := newStubDocker(t)
+	conn.User = strconv.Itoa(os.Getuid())
+	if err := conn.Connect(); err != nil {
+		t.Fatal(err)
+	}
+
+	dir := t.TempDir()
+	local := filepath.Join(dir, "app.conf")
+	if err := os.WriteFile(local, []byte("listen 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(dir, "remote.conf")
+	if err := conn.CopyFileWithOptions(local, remote, &TransferOptions{Mode: 0640}); err != nil {
+		t.Fatal(err)
+	}
+
+	info, err := os.Stat(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0640 {
+		t.Fatalf("mode = %o, want 640", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(remote); string(data) != "listen 80\n" {
+		t.Fatalf("content = %q", data)
+	}
+
+	log := calls()
+	if len(log) != 3 {
+		t.Fatalf("docker calls = %q, want inspect, cp, exec", log)
+	}
+	cp := log[1]
+	if len(cp) != 3 || cp[0] != "cp" || cp[1] != local || !strings.HasPrefix(cp[2], "web:"+filepath.Join(dir, ".remote.conf.ansible-go-tmp-")) {
+		t.Fatalf("cp args = %q", cp)
+	}
+	tmp := ShellQuote(strings.TrimPrefix(cp[2], "web:"))
+	script := "chmod 0640 " + tmp + " && chown " + ShellQuote(conn.User) + " " + tmp +
+		" && mv -f " + tmp + " " + ShellQuote(remote) + " || { rm -f " + tmp + "; exit 1; }"
+	want := []string{"exec", "-u", "0", "web", "/bin/sh", "-c", script}
+	if !reflect.DeepEqual(log[2], want) {
+		t.Fatalf("exec args = %q, want %q", log[2], want)
+	}
+}
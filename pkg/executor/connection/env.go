@@ -0,0 +1,129 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// EnvConnection 为每条命令设置环境变量的连接
+// 支持流式执行的连接由连接自身设置环境变量，其他连接在命令前添加env前缀
+type EnvConnection struct {
+	Connection
+	env map[string]string
+}
+
+// NewEnvConnection 创建设置环境变量的连接
+func NewEnvConnection(conn Connection, env map[string]string) *EnvConnection {
+	return &EnvConnection{
+		Connection: conn,
+		env:        env,
+	}
+}
+
+// ExecuteCommand 设置环境变量后执行命令
+func (c *EnvConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	streamer, ok := c.Connection.(StreamExecutor)
+	if !ok {
+		return c.Connection.ExecuteCommand(envCommand(c.env, command))
+	}
+
+	var stdout, stderr bytes.Buffer
+	start := time.Now()
+	exitCode, err := streamer.ExecuteStream(command, &StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Env:    c.env,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &ConnectionResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+		Duration: time.Since(start),
+	}, nil
+}
+
+// ExecuteStream 实现StreamExecutor接口，opts中的环境变量覆盖连接的同名环境变量
+func (c *EnvConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	streamer, ok := c.Connection.(StreamExecutor)
+	if !ok {
+		return -1, fmt.Errorf("%s连接不支持流式执行命令", c.Connection.GetType())
+	}
+
+	merged := *opts
+	merged.Env = mergeEnv(c.env, opts.Env)
+	return streamer.ExecuteStream(command, &merged)
+}
+
+// CopyFileWithOptions 实现FileTransferer接口，文件传输不受环境变量影响
+func (c *EnvConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	return CopyFileWithOptions(c.Connection, localPath, remotePath, opts)
+}
+
+// FetchFileWithOptions 实现FileTransferer接口，文件传输不受环境变量影响
+func (c *EnvConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	return FetchFileWithOptions(c.Connection, remotePath, localPath, opts)
+}
+
+// mergeEnv 合并环境变量，同名时使用override的值
+func mergeEnv(base, override map[string]string) map[string]string {
+	if len(override) == 0 {
+		return base
+	}
+	if len(base) == 0 {
+		return override
+	}
+
+	env := make(map[string]string, len(base)+len(override))
+	for name, value := range base {
+		env[name] = value
+	}
+	for name, value := range override {
+		env[name] = value
+	}
+	return env
+}
+
+// envNames 获取按名称排序的环境变量名
+func envNames(env map[string]string) []string {
+	names := make([]string, 0, len(env))
+	for name := range env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// envList 将环境变量转换为按名称排序的NAME=value列表
+func envList(env map[string]string) []string {
+	names := envNames(env)
+	list := make([]string, 0, len(names))
+	for _, name := range names {
+		list = append(list, name+"="+env[name])
+	}
+	return list
+}
+
+// envCommand 构造通过env设置环境变量后由/bin/sh执行命令的命令行
+// 用于服务端不接受session.Setenv或提权重置环境变量的情况，名称和值都经过引用
+func envCommand(env map[string]string, command string) string {
+	if len(env) == 0 {
+		return command
+	}
+
+	var b strings.Builder
+	b.WriteString("env")
+	for _, pair := range envList(env) {
+		b.WriteString(" ")
+		b.WriteString(ShellQuote(pair))
+	}
+	b.WriteString(" /bin/sh -c ")
+	b.WriteString(ShellQuote(command))
+	return b.String()
+}
@@ -0,0 +1,525 @@
+package connection
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config"
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+// 主机密钥检查模式
+const (
+	// HostKeyCheckingStrict 拒绝未知和已变更的主机密钥
+	HostKeyCheckingStrict = "strict"
+	// HostKeyCheckingTOFU 首次连接时记录未知的主机密钥，拒绝已变更的主机密钥
+	HostKeyCheckingTOFU = "tofu"
+	// HostKeyCheckingOff 不检查主机密钥
+	HostKeyCheckingOff = "off"
+)
+
+// userKnownHostsFile 用户级known_hosts文件
+const userKnownHostsFile = "~/.ssh/known_hosts"
+
+// knownHostsMutex 保护known_hosts文件的写入和已解析的known_hosts缓存
+var knownHostsMutex sync.Mutex
+
+// knownHostsCache 按文件列表缓存已解析的known_hosts，文件变化或写入新记录后重新解析
+var knownHostsCache = make(map[string]*knownHosts)
+
+// hostKeyCheckingMode 获取主机密钥检查模式，默认为strict
+func hostKeyCheckingMode(cfg *types.SSHConfig) string {
+	if cfg.DisableHostKeyChecking {
+		return HostKeyCheckingOff
+	}
+	if cfg.HostKeyChecking == "" {
+		return HostKeyCheckingStrict
+	}
+	return cfg.HostKeyChecking
+}
+
+// knownHostsFiles 获取用于验证的known_hosts文件列表，依次为用户级文件和项目级文件
+func knownHostsFiles(cfg *types.SSHConfig) []string {
+	files := []string{userKnownHostsFile}
+	if cfg.KnownHostsFile != "" && cfg.KnownHostsFile != userKnownHostsFile {
+		files = append(files, cfg.KnownHostsFile)
+	}
+	return files
+}
+
+// pinKnownHostsFile 获取记录新主机密钥的文件，配置了项目级文件时写入项目级文件
+func pinKnownHostsFile(cfg *types.SSHConfig) string {
+	if cfg.KnownHostsFile != "" {
+		return cfg.KnownHostsFile
+	}
+	return userKnownHostsFile
+}
+
+// getHostKeyCallback 获取主机密钥验证回调函数
+func getHostKeyCallback(cfg *types.SSHConfig) ssh.HostKeyCallback {
+	mode := hostKeyCheckingMode(cfg)
+	if mode == HostKeyCheckingOff {
+		return ssh.InsecureIgnoreHostKey()
+	}
+
+	return func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+		db, err := loadKnownHosts(knownHostsFiles(cfg))
+		if err != nil {
+			return err
+		}
+		err = checkHostKey(db, mode, hostname, remote, key)
+		if !errors.Is(err, errHostKeyUnknown) {
+			return err
+		}
+
+		// 记录前在锁内重新验证，避免并发连接同一新主机时重复记录
+		knownHostsMutex.Lock()
+		defer knownHostsMutex.Unlock()
+		if db, err = loadKnownHostsLocked(knownHostsFiles(cfg)); err != nil {
+			return err
+		}
+		key, err = db.verify(hostname, remote, key)
+		var keyErr *knownhosts.KeyError
+		if err == nil || !errors.As(err, &keyErr) {
+			return err
+		}
+		if len(keyErr.Want) > 0 {
+			return hostKeyChangedError(hostname, key, keyErr.Want)
+		}
+		return appendKnownHost(pinKnownHostsFile(cfg), hostname, key)
+	}
+}
+
+// errHostKeyUnknown 主机密钥未记录在known_hosts文件中
+var errHostKeyUnknown = errors.New("主机密钥未知")
+
+// checkHostKey 按检查模式验证主机密钥，tofu模式下主机密钥未知时返回errHostKeyUnknown
+func checkHostKey(db *knownHosts, mode, hostname string, remote net.Addr, key ssh.PublicKey) error {
+	key, err := db.verify(hostname, remote, key)
+	if err == nil {
+		return nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return err
+	}
+
+	// 主机密钥与记录不一致，无论哪种模式都拒绝连接
+	if len(keyErr.Want) > 0 {
+		return hostKeyChangedError(hostname, key, keyErr.Want)
+	}
+
+	if mode != HostKeyCheckingTOFU {
+		return fmt.Errorf("主机 %s 的密钥未知 (%s %s)，请先运行 ansible-go known-hosts 扫描并记录主机密钥，或设置 ssh.host_key_checking: tofu",
+			hostname, key.Type(), ssh.FingerprintSHA256(key))
+	}
+	return errHostKeyUnknown
+}
+
+// knownHosts 定义已解析的known_hosts文件
+type knownHosts struct {
+	certs   ssh.HostKeyCallback // 包含@cert-authority记录，用于验证主机证书，没有文件时为nil
+	plain   ssh.HostKeyCallback // 去掉@cert-authority记录，用于验证普通主机密钥，没有文件时为nil
+	origins map[string]string   // 去掉@cert-authority记录的副本到原文件的对应关系
+	stamps  []knownHostsStamp   // 解析时各文件的状态
+}
+
+// knownHostsStamp 定义解析时known_hosts文件的状态，用于判断文件是否已变化
+type knownHostsStamp struct {
+	path    string
+	size    int64
+	modTime time.Time
+}
+
+// loadKnownHosts 获取已解析的known_hosts，文件未变化时使用缓存
+func loadKnownHosts(files []string) (*knownHosts, error) {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+	return loadKnownHostsLocked(files)
+}
+
+// loadKnownHostsLocked 与loadKnownHosts相同，调用方必须持有knownHostsMutex
+func loadKnownHostsLocked(files []string) (*knownHosts, error) {
+	stamps := make([]knownHostsStamp, 0, len(files))
+	for _, file := range files {
+		path, err := expandPath(file)
+		if err != nil {
+			return nil, err
+		}
+		if info, err := os.Stat(path); err == nil {
+			stamps = append(stamps, knownHostsStamp{path: path, size: info.Size(), modTime: info.ModTime()})
+		}
+	}
+
+	cacheKey := strings.Join(files, "\n")
+	if db, ok := knownHostsCache[cacheKey]; ok && sameStamps(db.stamps, stamps) {
+		return db, nil
+	}
+
+	db, err := parseKnownHosts(stamps)
+	if err != nil {
+		return nil, err
+	}
+	knownHostsCache[cacheKey] = db
+	return db, nil
+}
+
+// sameStamps 判断两次获取的文件状态是否一致
+func sameStamps(a, b []knownHostsStamp) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i].path != b[i].path || a[i].size != b[i].size || !a[i].modTime.Equal(b[i].modTime) {
+			return false
+		}
+	}
+	return true
+}
+
+// invalidateKnownHosts 清空已解析的known_hosts缓存，调用方必须持有knownHostsMutex
+func invalidateKnownHosts() {
+	knownHostsCache = make(map[string]*knownHosts)
+}
+
+// parseKnownHosts 解析已存在的known_hosts文件
+// knownhosts验证普通主机密钥时也会匹配@cert-authority记录，并且每种密钥类型只比较第一条匹配的记录，
+// 因此另外解析一份去掉@cert-authority记录的副本用于验证普通主机密钥，副本保留原文件的行号，解析后即删除
+func parseKnownHosts(stamps []knownHostsStamp) (*knownHosts, error) {
+	db := &knownHosts{origins: make(map[string]string), stamps: stamps}
+	// 没有known_hosts文件时视为主机未知
+	if len(stamps) == 0 {
+		return db, nil
+	}
+
+	existing := make([]string, 0, len(stamps))
+	plain := make([]string, 0, len(stamps))
+	for _, stamp := range stamps {
+		existing = append(existing, stamp.path)
+		copied, err := withoutCertAuthorities(stamp.path)
+		if err != nil {
+			return nil, err
+		}
+		if copied != stamp.path {
+			defer os.Remove(copied)
+			db.origins[copied] = stamp.path
+		}
+		plain = append(plain, copied)
+	}
+
+	var err error
+	if db.certs, err = knownhosts.New(existing...); err != nil {
+		return nil, fmt.Errorf("加载known_hosts文件失败: %w", err)
+	}
+	if db.plain, err = knownhosts.New(plain...); err != nil {
+		return nil, fmt.Errorf("加载known_hosts文件失败: %w", err)
+	}
+	return db, nil
+}
+
+// verify 验证主机密钥，返回实际验证的密钥
+// 主机证书由known_hosts中@cert-authority记录的CA签发时验证通过；
+// 与OpenSSH一致，证书未被信任或无效时改为按证书中的主机密钥验证，证书已被吊销时直接拒绝
+func (db *knownHosts) verify(hostname string, remote net.Addr, key ssh.PublicKey) (ssh.PublicKey, error) {
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return key, db.check(hostname, remote, key)
+	}
+
+	err := db.check(hostname, remote, cert)
+	var revoked *knownhosts.RevokedError
+	if err == nil {
+		// @revoked记录的是主机密钥时，证书本身有效也拒绝连接
+		if keyErr := db.check(hostname, remote, cert.Key); errors.As(keyErr, &revoked) {
+			return cert.Key, keyErr
+		}
+		return cert, nil
+	}
+	if errors.As(err, &revoked) {
+		return cert, err
+	}
+	return cert.Key, db.check(hostname, remote, cert.Key)
+}
+
+// check 验证主机密钥，主机证书使用包含@cert-authority记录的文件验证
+func (db *knownHosts) check(hostname string, remote net.Addr, key ssh.PublicKey) error {
+	callback := db.plain
+	if _, ok := key.(*ssh.Certificate); ok {
+		callback = db.certs
+	}
+	if callback == nil {
+		return &knownhosts.KeyError{}
+	}
+
+	err := callback(hostname, remote, key)
+	var keyErr *knownhosts.KeyError
+	if errors.As(err, &keyErr) {
+		for i, known := range keyErr.Want {
+			if origin, ok := db.origins[known.Filename]; ok {
+				keyErr.Want[i].Filename = origin
+			}
+		}
+	}
+	return err
+}
+
+// withoutCertAuthorities 将known_hosts文件中的@cert-authority记录替换为空行后写入临时文件
+// 文件中没有@cert-authority记录时直接返回原文件
+func withoutCertAuthorities(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", fmt.Errorf("读取known_hosts文件失败: %w", err)
+	}
+
+	lines := bytes.Split(data, []byte("\n"))
+	found := false
+	for i, line := range lines {
+		if bytes.HasPrefix(bytes.TrimSpace(line), []byte("@cert-authority")) {
+			lines[i] = nil
+			found = true
+		}
+	}
+	if !found {
+		return path, nil
+	}
+
+	f, err := ioutil.TempFile("", "ansible-go-known-hosts-")
+	if err != nil {
+		return "", fmt.Errorf("创建临时文件失败: %w", err)
+	}
+	defer f.Close()
+	if _, err := f.Write(bytes.Join(lines, []byte("\n"))); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("写入临时文件失败: %w", err)
+	}
+	return f.Name(), nil
+}
+
+// hostKeyChangedError 构建主机密钥变更的错误信息
+func hostKeyChangedError(hostname string, key ssh.PublicKey, want []knownhosts.KnownKey) error {
+	var b strings.Builder
+	b.WriteString("警告: 远程主机密钥已变更！可能有人正在进行中间人攻击。\n")
+	fmt.Fprintf(&b, "主机 %s 当前的密钥为 %s %s\n", hostname, key.Type(), ssh.FingerprintSHA256(key))
+	for _, known := range want {
+		fmt.Fprintf(&b, "已记录的密钥 %s %s 位于 %s:%d\n",
+			known.Key.Type(), ssh.FingerprintSHA256(known.Key), known.Filename, known.Line)
+	}
+	b.WriteString("如果确认主机密钥已合法变更，请运行 ansible-go known-hosts -replace-host-keys 更新记录")
+	return errors.New(b.String())
+}
+
+// appendKnownHost 将主机密钥追加到known_hosts文件
+func appendKnownHost(file, hostname string, key ssh.PublicKey) error {
+	path, err := expandPath(file)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("创建known_hosts目录失败: %w", err)
+	}
+
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("打开known_hosts文件失败: %w", err)
+	}
+	defer f.Close()
+
+	defer invalidateKnownHosts()
+	line := knownhosts.Line([]string{knownhosts.Normalize(hostname)}, key)
+	if _, err := fmt.Fprintln(f, line); err != nil {
+		return fmt.Errorf("写入known_hosts文件失败: %w", err)
+	}
+	return nil
+}
+
+// removeKnownKey 从known_hosts文件中删除主机的一条密钥记录
+// 记录包含多个主机名时只删除与hostname匹配的主机名（包括哈希格式），其余主机名保留；
+// 记录通过通配符匹配hostname时不修改，返回错误
+func removeKnownKey(known knownhosts.KnownKey, hostname string) error {
+	data, err := ioutil.ReadFile(known.Filename)
+	if err != nil {
+		return fmt.Errorf("读取known_hosts文件失败: %w", err)
+	}
+	info, err := os.Stat(known.Filename)
+	if err != nil {
+		return fmt.Errorf("读取known_hosts文件失败: %w", err)
+	}
+
+	lines := strings.Split(string(data), "\n")
+	if known.Line < 1 || known.Line > len(lines) {
+		return fmt.Errorf("%s:%d 不存在，known_hosts文件已被修改", known.Filename, known.Line)
+	}
+	line := lines[known.Line-1]
+	fields := strings.Fields(line)
+	if len(fields) < 3 || strings.HasPrefix(fields[0], "@") {
+		return fmt.Errorf("%s:%d 不是主机密钥记录", known.Filename, known.Line)
+	}
+
+	normalized := knownhosts.Normalize(hostname)
+	var kept []string
+	for _, pattern := range strings.Split(fields[0], ",") {
+		if pattern != normalized && !hashedHostMatches(pattern, normalized) {
+			kept = append(kept, pattern)
+		}
+	}
+	switch {
+	case len(kept) == len(strings.Split(fields[0], ",")):
+		return fmt.Errorf("主机 %s 的密钥记录 %s:%d 通过通配符匹配，请手动修改该记录", hostname, known.Filename, known.Line)
+	case len(kept) == 0:
+		lines = append(lines[:known.Line-1], lines[known.Line:]...)
+	default:
+		offset := strings.Index(line, fields[0])
+		lines[known.Line-1] = line[:offset] + strings.Join(kept, ",") + line[offset+len(fields[0]):]
+	}
+
+	defer invalidateKnownHosts()
+	if err := ioutil.WriteFile(known.Filename, []byte(strings.Join(lines, "\n")), info.Mode().Perm()); err != nil {
+		return fmt.Errorf("主机 %s 已变更的密钥记录在 %s:%d，更新该文件失败: %w", hostname, known.Filename, known.Line, err)
+	}
+	return nil
+}
+
+// hashedHostMatches 判断哈希格式（|1|salt|hash）的主机名是否与host匹配
+func hashedHostMatches(pattern, host string) bool {
+	parts := strings.Split(pattern, "|")
+	if len(parts) != 4 || parts[0] != "" || parts[1] != "1" {
+		return false
+	}
+	salt, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	want, err := base64.StdEncoding.DecodeString(parts[3])
+	if err != nil {
+		return false
+	}
+	mac := hmac.New(sha1.New, salt)
+	mac.Write([]byte(host))
+	return hmac.Equal(mac.Sum(nil), want)
+}
+
+// HostKeyPinStatus 定义记录主机密钥的结果
+type HostKeyPinStatus string
+
+const (
+	HostKeyAdded     HostKeyPinStatus = "added"     // 新记录了主机密钥
+	HostKeyUnchanged HostKeyPinStatus = "unchanged" // 主机密钥已记录且一致
+	HostKeyChanged   HostKeyPinStatus = "changed"   // 主机密钥与记录不一致，未替换
+	HostKeyReplaced  HostKeyPinStatus = "replaced"  // 主机密钥与记录不一致，已替换
+)
+
+// errHostKeyScanned 扫描主机密钥后中止握手
+var errHostKeyScanned = errors.New("已获取主机密钥")
+
+// ScanHostKey 连接主机获取其主机密钥，不进行认证，proxy不为空时经过该代理连接
+func ScanHostKey(address string, port int, proxy string, timeout time.Duration) (ssh.PublicKey, error) {
+	if timeout <= 0 {
+		timeout = defaultSSHTimeout
+	}
+	proxyURL, err := config.ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+	var dial dialFunc
+	if proxyURL != nil {
+		dial = proxyDialer(proxyURL, timeout)
+	}
+
+	var hostKey ssh.PublicKey
+	clientConfig := &ssh.ClientConfig{
+		User: "ansible-go",
+		HostKeyCallback: func(hostname string, remote net.Addr, key ssh.PublicKey) error {
+			hostKey = key
+			return errHostKeyScanned
+		},
+		Timeout: timeout,
+	}
+
+	client, err := dialClient(dial, net.JoinHostPort(address, fmt.Sprint(port)), clientConfig)
+	if client != nil {
+		client.Close()
+	}
+	if hostKey == nil {
+		if err == nil {
+			err = errors.New("服务器未提供主机密钥")
+		}
+		return nil, fmt.Errorf("扫描主机密钥失败: %w", err)
+	}
+	return hostKey, nil
+}
+
+// PinHostKey 将主机密钥记录到known_hosts文件
+// 已记录的密钥与扫描结果不一致时，只有replace为true才替换记录：从所有known_hosts文件中删除旧密钥后记录新密钥；主机证书未被信任的CA签发时记录证书中的主机密钥
+func PinHostKey(cfg *types.SSHConfig, address string, port int, key ssh.PublicKey, replace bool) (HostKeyPinStatus, error) {
+	knownHostsMutex.Lock()
+	defer knownHostsMutex.Unlock()
+
+	hostname := net.JoinHostPort(address, fmt.Sprint(port))
+	remote := &net.TCPAddr{IP: net.ParseIP(address), Port: port}
+	file := pinKnownHostsFile(cfg)
+
+	db, err := loadKnownHostsLocked(knownHostsFiles(cfg))
+	if err != nil {
+		return "", err
+	}
+	key, err = db.verify(hostname, remote, key)
+	if err == nil {
+		return HostKeyUnchanged, nil
+	}
+
+	var keyErr *knownhosts.KeyError
+	if !errors.As(err, &keyErr) {
+		return "", err
+	}
+
+	if len(keyErr.Want) == 0 {
+		if err := appendKnownHost(file, hostname, key); err != nil {
+			return "", err
+		}
+		return HostKeyAdded, nil
+	}
+	if !replace {
+		return HostKeyChanged, hostKeyChangedError(hostname, key, keyErr.Want)
+	}
+
+	// 所有known_hosts文件中的旧密钥都要删除，knownhosts每种密钥类型只返回第一条记录，删除后重新验证直到没有不一致的记录
+	for len(keyErr.Want) > 0 {
+		// 同一文件中从后往前删除，避免删除整行后其余记录的行号变化
+		sort.Slice(keyErr.Want, func(i, j int) bool { return keyErr.Want[i].Line > keyErr.Want[j].Line })
+		for _, known := range keyErr.Want {
+			if err := removeKnownKey(known, hostname); err != nil {
+				return "", err
+			}
+		}
+		if db, err = loadKnownHostsLocked(knownHostsFiles(cfg)); err != nil {
+			return "", err
+		}
+		if key, err = db.verify(hostname, remote, key); err == nil {
+			return HostKeyReplaced, nil
+		}
+		keyErr = nil
+		if !errors.As(err, &keyErr) {
+			return "", err
+		}
+	}
+
+	if err := appendKnownHost(file, hostname, key); err != nil {
+		return "", err
+	}
+	return HostKeyReplaced, nil
+}
@@ -0,0 +1,171 @@
+package connection
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/knownhosts"
+)
+
+func newTestHostKey(t *testing.T) ssh.PublicKey {
+	t.Helper()
+	pub, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := ssh.NewPublicKey(pub)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return key
+}
+
+func TestHostKeyCallbackIgnoresCertAuthorityForPlainKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	file := filepath.Join(t.TempDir(), "known_hosts")
+
+	hostKey := newTestHostKey(t)
+	ca := newTestHostKey(t)
+	lines := "@cert-authority * " + string(ssh.MarshalAuthorizedKey(ca)) +
+		knownhosts.Line([]string{knownhosts.Normalize("web1:22")}, hostKey) + "\n"
+	if err := os.WriteFile(file, []byte(lines), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	pattern := filepath.Join(os.TempDir(), "ansible-go-known-hosts-*")
+	before, _ := filepath.Glob(pattern)
+
+	callback := getHostKeyCallback(&types.SSHConfig{KnownHostsFile: file})
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	for i := 0; i < 2; i++ {
+		if err := callback("web1:22", remote, hostKey); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+
+	err := callback("web1:22", remote, newTestHostKey(t))
+	if err == nil || !strings.Contains(err.Error(), file+":2") {
+		t.Fatalf("changed key error = %v, want reference to %s:2", err, file)
+	}
+
+	if after, _ := filepath.Glob(pattern); len(after) > len(before) {
+		t.Fatalf("temporary known_hosts copies left behind: %v", after)
+	}
+}
+
+func TestHostKeyCallbackSeesPinnedKeys(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	cfg := &types.SSHConfig{KnownHostsFile: file}
+	callback := getHostKeyCallback(cfg)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	hostKey := newTestHostKey(t)
+	if err := callback("192.0.2.1:22", remote, hostKey); err == nil {
+		t.Fatal("unknown host key accepted in strict mode")
+	}
+
+	status, err := PinHostKey(cfg, "192.0.2.1", 22, hostKey, false)
+	if err != nil || status != HostKeyAdded {
+		t.Fatalf("PinHostKey = %q, %v", status, err)
+	}
+	if err := callback("192.0.2.1:22", remote, hostKey); err != nil {
+		t.Fatalf("pinned host key rejected: %v", err)
+	}
+}
+
+func TestHostKeyCallbackTOFURecordsOnce(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	callback := getHostKeyCallback(&types.SSHConfig{KnownHostsFile: file, HostKeyChecking: HostKeyCheckingTOFU})
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+
+	hostKey := newTestHostKey(t)
+	for i := 0; i < 3; i++ {
+		if err := callback("web1:22", remote, hostKey); err != nil {
+			t.Fatalf("attempt %d: %v", i, err)
+		}
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := strings.Count(string(data), "\n"); n != 1 {
+		t.Fatalf("known_hosts has %d lines, want 1:\n%s", n, data)
+	}
+	if err := callback("web1:22", remote, newTestHostKey(t)); err == nil {
+		t.Fatal("changed host key accepted in tofu mode")
+	}
+}
+
+func TestPinHostKeyReplacesStaleKeyInEveryFile(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	userFile := filepath.Join(home, ".ssh", "known_hosts")
+	projectFile := filepath.Join(t.TempDir(), "known_hosts")
+	cfg := &types.SSHConfig{KnownHostsFile: projectFile}
+
+	oldKey := newTestHostKey(t)
+	otherKey := newTestHostKey(t)
+	// 用户文件中旧密钥与别名在同一行，另有一条哈希格式的记录；项目文件中也有旧密钥
+	userLines := knownhosts.Line([]string{"web1", "192.0.2.1"}, oldKey) + "\n" +
+		knownhosts.Line([]string{"db1"}, otherKey) + "\n" +
+		knownhosts.Line([]string{knownhosts.HashHostname("192.0.2.1")}, oldKey) + "\n"
+	if err := os.MkdirAll(filepath.Dir(userFile), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(userFile, []byte(userLines), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(projectFile, []byte(knownhosts.Line([]string{"192.0.2.1"}, oldKey)+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	newKey := newTestHostKey(t)
+	if status, err := PinHostKey(cfg, "192.0.2.1", 22, newKey, false); status != HostKeyChanged || err == nil {
+		t.Fatalf("PinHostKey without replace = %q, %v", status, err)
+	}
+	status, err := PinHostKey(cfg, "192.0.2.1", 22, newKey, true)
+	if err != nil || status != HostKeyReplaced {
+		t.Fatalf("PinHostKey = %q, %v", status, err)
+	}
+
+	callback := getHostKeyCallback(cfg)
+	remote := &net.TCPAddr{IP: net.ParseIP("192.0.2.1"), Port: 22}
+	if err := callback("192.0.2.1:22", remote, newKey); err != nil {
+		t.Fatalf("replaced key rejected: %v", err)
+	}
+
+	data, _ := os.ReadFile(userFile)
+	want := knownhosts.Line([]string{"web1"}, oldKey) + "\n" + knownhosts.Line([]string{"db1"}, otherKey) + "\n"
+	if string(data) != want {
+		t.Fatalf("user known_hosts =\n%s\nwant alias and other hosts kept:\n%s", data, want)
+	}
+	if err := callback("web1:22", remote, oldKey); err != nil {
+		t.Fatalf("alias web1 lost its key: %v", err)
+	}
+}
+
+func TestPinHostKeyRefusesWildcardRecords(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	file := filepath.Join(t.TempDir(), "known_hosts")
+	line := knownhosts.Line([]string{"192.0.2.*"}, newTestHostKey(t)) + "\n"
+	if err := os.WriteFile(file, []byte(line), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	status, err := PinHostKey(&types.SSHConfig{KnownHostsFile: file}, "192.0.2.1", 22, newTestHostKey(t), true)
+	if err == nil || status == HostKeyReplaced || !strings.Contains(err.Error(), file+":1") {
+		t.Fatalf("PinHostKey = %q, %v, want error naming %s:1", status, err, file)
+	}
+	if data, _ := os.ReadFile(file); string(data) != line {
+		t.Fatalf("wildcard record modified: %q", data)
+	}
+}
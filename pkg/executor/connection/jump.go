@@ -0,0 +1,204 @@
+package connection
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/ape902/ansible-go/pkg/config"
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// dialFunc 定义建立底层网络连接的函数
+type dialFunc func(network, addr string) (net.Conn, error)
+
+// jumpHostKey 获取跳板机链的标识，用于区分经过不同跳板机的连接
+// 私钥或密码不同的跳板机不能共享连接，密码和私钥密码只以摘要的形式出现在标识中
+func jumpHostKey(chain []types.JumpHostConfig) string {
+	parts := make([]string, 0, len(chain))
+	for _, hop := range chain {
+		part := jumpHostAddr(hop)
+		if hop.KeyFile != "" {
+			part += " key " + hop.KeyFile
+		}
+		if hop.Password != "" || hop.KeyPassword != "" {
+			sum := sha256.Sum256([]byte(hop.Password + "\x00" + hop.KeyPassword))
+			part += " secret " + hex.EncodeToString(sum[:8])
+		}
+		parts = append(parts, part)
+	}
+	return strings.Join(parts, ",")
+}
+
+// jumpHostAddr 获取跳板机的用户和地址，用于错误信息和连接标识
+func jumpHostAddr(hop types.JumpHostConfig) string {
+	return fmt.Sprintf("%s@%s", hop.User, net.JoinHostPort(hop.Host, fmt.Sprint(jumpHostPort(hop))))
+}
+
+// jumpHostPort 获取跳板机端口，默认22
+func jumpHostPort(hop types.JumpHostConfig) int {
+	if hop.Port > 0 {
+		return hop.Port
+	}
+	return 22
+}
+
+// jumpDialer 管理到跳板机的SSH连接
+// 经过同一跳板机链的所有目标主机共享跳板机连接，跳板机连接断开后在下次使用时重新建立
+type jumpDialer struct {
+	mutex     sync.Mutex
+	clients   map[string]*ssh.Client  // 键为到该跳板机为止的跳板机链标识
+	dialing   map[string]*pendingDial // 正在建立的跳板机连接，同一跳板机链只建立一次
+	sshConfig *types.SSHConfig
+}
+
+// newJumpDialer 创建新的跳板机连接管理器
+func newJumpDialer(sshConfig *types.SSHConfig) *jumpDialer {
+	return &jumpDialer{
+		clients:   make(map[string]*ssh.Client),
+		dialing:   make(map[string]*pendingDial),
+		sshConfig: sshConfig,
+	}
+}
+
+// dialer 获取经过代理和跳板机链建立连接的函数，代理只用于连接目标主机或第一个跳板机
+// 没有代理且跳板机链为空时返回nil表示直接连接，跳板机连接在第一次拨号时才建立
+func (d *jumpDialer) dialer(chain []types.JumpHostConfig, proxy string) (dialFunc, error) {
+	proxyURL, err := config.ParseProxy(proxy)
+	if err != nil {
+		return nil, err
+	}
+	var proxyDial dialFunc
+	if proxyURL != nil {
+		proxyDial = proxyDialer(proxyURL, sshTimeout(d.sshConfig))
+	}
+
+	if len(chain) == 0 {
+		return proxyDial, nil
+	}
+	return func(network, addr string) (net.Conn, error) {
+		client, err := d.client(chain, proxy, proxyDial)
+		if err != nil {
+			return nil, err
+		}
+		conn, err := client.Dial(network, addr)
+		if err != nil {
+			return nil, fmt.Errorf("通过跳板机 %s 连接 %s 失败: %w", jumpHostAddr(chain[len(chain)-1]), addr, err)
+		}
+		return conn, nil
+	}, nil
+}
+
+// client 获取到跳板机链最后一跳的SSH连接，依次复用或建立每一跳的连接
+// 第一跳经过proxyDial建立连接，经过不同代理的跳板机连接互不共享
+func (d *jumpDialer) client(chain []types.JumpHostConfig, proxy string, proxyDial dialFunc) (*ssh.Client, error) {
+	var prev *ssh.Client
+	for i, hop := range chain {
+		key := jumpHostKey(chain[:i+1])
+		if proxy != "" {
+			key = proxy + " " + key
+		}
+
+		dial := proxyDial
+		if prev != nil {
+			dial = prev.Dial
+		}
+		client, err := d.hop(key, hop, dial)
+		if err != nil {
+			return nil, err
+		}
+		prev = client
+	}
+	return prev, nil
+}
+
+// hop 获取到一跳的SSH连接，连接不存在时经过dial建立
+// 建立连接时不持有锁，经过其他跳板机的主机可以继续获取连接；
+// 同一跳板机链的连接正在建立时等待其完成并复用，建立失败时返回同一错误
+func (d *jumpDialer) hop(key string, hop types.JumpHostConfig, dial dialFunc) (*ssh.Client, error) {
+	d.mutex.Lock()
+	for {
+		if client, ok := d.clients[key]; ok {
+			d.mutex.Unlock()
+			return client, nil
+		}
+		pending, ok := d.dialing[key]
+		if !ok {
+			break
+		}
+		d.mutex.Unlock()
+		<-pending.done
+		if pending.err != nil {
+			return nil, pending.err
+		}
+		d.mutex.Lock()
+	}
+
+	pending := &pendingDial{done: make(chan struct{})}
+	d.dialing[key] = pending
+	d.mutex.Unlock()
+
+	user := hop.User
+	if user == "" {
+		user = d.sshConfig.User
+	}
+	client, err := dialSSH(dial, hop.Host, jumpHostPort(hop), sshCredentials{
+		User:        user,
+		Password:    hop.Password,
+		KeyFile:     hop.KeyFile,
+		KeyPassword: hop.KeyPassword,
+	}, d.sshConfig)
+	if err != nil {
+		err = fmt.Errorf("连接跳板机 %s 失败: %w", hop.Host, err)
+	}
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	delete(d.dialing, key)
+	pending.err = err
+	close(pending.done)
+	if err != nil {
+		return nil, err
+	}
+
+	d.clients[key] = client
+	go d.watch(key, client)
+	go keepAlive(client, d.sshConfig)
+	return client, nil
+}
+
+// watch 在跳板机连接断开后将其移除，下次使用时重新建立
+func (d *jumpDialer) watch(key string, client *ssh.Client) {
+	client.Wait()
+
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+	if d.clients[key] == client {
+		delete(d.clients, key)
+	}
+}
+
+// closeAll 关闭所有跳板机连接，先关闭后建立的连接
+func (d *jumpDialer) closeAll() {
+	d.mutex.Lock()
+	clients := d.clients
+	d.clients = make(map[string]*ssh.Client)
+	d.mutex.Unlock()
+
+	keys := make([]string, 0, len(clients))
+	for key := range clients {
+		keys = append(keys, key)
+	}
+	// 链越长的连接越靠后建立，按长度倒序关闭
+	sort.Slice(keys, func(i, j int) bool {
+		return len(keys[i]) > len(keys[j])
+	})
+	for _, key := range keys {
+		clients[key].Close()
+	}
+}
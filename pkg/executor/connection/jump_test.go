@@ -0,0 +1,123 @@
+package connection
+
+import (
+	"net"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+)
+
+func TestJumpHostKeySeparatesAuth(t *testing.T) {
+	base := types.JumpHostConfig{Host: "bastion", User: "jump"}
+
+	variants := map[string]func(hop *types.JumpHostConfig){
+		"key file":     func(hop *types.JumpHostConfig) { hop.KeyFile = "/keys/a" },
+		"password":     func(hop *types.JumpHostConfig) { hop.Password = "hunter2" },
+		"key password": func(hop *types.JumpHostConfig) { hop.KeyPassword = "swordfish" },
+	}
+
+	seen := map[string]string{jumpHostKey([]types.JumpHostConfig{base}): "base"}
+	for name, apply := range variants {
+		hop := base
+		apply(&hop)
+		key := jumpHostKey([]types.JumpHostConfig{hop})
+		if other, ok := seen[key]; ok {
+			t.Fatalf("%s shares jump key %q with %s", name, key, other)
+		}
+		if strings.Contains(key, "hunter2") || strings.Contains(key, "swordfish") {
+			t.Fatalf("jump key %q contains a plaintext password", key)
+		}
+		seen[key] = name
+	}
+}
+
+// newHangupListener 启动接受连接后延迟delay再关闭的监听，模拟握手缓慢且失败的跳板机
+// 返回跳板机配置和累计接受的连接数
+func newHangupListener(t *testing.T, delay time.Duration) (types.JumpHostConfig, *atomic.Int64) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	var accepted atomic.Int64
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			accepted.Add(1)
+			time.AfterFunc(delay, func() { nc.Close() })
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	return types.JumpHostConfig{Host: host, Port: portNum, User: "jump", Password: "secret"}, &accepted
+}
+
+func newTestJumpDialer(t *testing.T) *jumpDialer {
+	d := newJumpDialer(&types.SSHConfig{HostKeyChecking: HostKeyCheckingOff, DisableAgent: true})
+	t.Cleanup(d.closeAll)
+	return d
+}
+
+func TestJumpDialerSharesHopDial(t *testing.T) {
+	hop, accepted := newHangupListener(t, 300*time.Millisecond)
+	d := newTestJumpDialer(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = d.client([]types.JumpHostConfig{hop}, "", nil)
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil || err != errs[0] {
+			t.Fatalf("caller %d: err = %v, want the shared dial error", i, err)
+		}
+	}
+	if n := accepted.Load(); n != 1 {
+		t.Fatalf("jump host accepted %d connections, want one dial", n)
+	}
+	if len(d.dialing) != 0 || len(d.clients) != 0 {
+		t.Fatalf("dialing = %d, clients = %d after failure", len(d.dialing), len(d.clients))
+	}
+}
+
+func TestJumpDialerDoesNotBlockOtherChains(t *testing.T) {
+	slow, _ := newHangupListener(t, time.Second)
+	server := newTestSSHServer(t, subsystemServe)
+	fast := types.JumpHostConfig{Host: server.Host, Port: server.Port, User: "jump", Password: "secret"}
+	d := newTestJumpDialer(t)
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := d.client([]types.JumpHostConfig{slow}, "", nil)
+		slowDone <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := d.client([]types.JumpHostConfig{fast}, "", nil); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Fatalf("jump chain waited %v for another chain's dial", elapsed)
+	}
+	if err := <-slowDone; err == nil {
+		t.Fatal("dial to hung-up jump host succeeded")
+	}
+}
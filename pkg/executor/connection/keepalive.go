@@ -0,0 +1,84 @@
+package connection
+
+import (
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// 未配置时使用的keepalive参数
+const (
+	defaultKeepaliveInterval = 15 * time.Second
+	defaultKeepaliveCountMax = 3
+)
+
+// keepaliveRequest OpenSSH使用的keepalive全局请求，服务器对未知请求也会回复，回复本身说明连接可用
+const keepaliveRequest = "keepalive@openssh.com"
+
+// keepaliveSettings 获取keepalive间隔和允许连续未响应的次数，间隔为0表示不发送
+func keepaliveSettings(cfg *types.SSHConfig) (time.Duration, int) {
+	interval, countMax := defaultKeepaliveInterval, defaultKeepaliveCountMax
+	if cfg == nil {
+		return interval, countMax
+	}
+	if cfg.KeepaliveInterval > 0 {
+		interval = time.Duration(cfg.KeepaliveInterval) * time.Second
+	} else if cfg.KeepaliveInterval < 0 {
+		interval = 0
+	}
+	if cfg.KeepaliveCountMax > 0 {
+		countMax = cfg.KeepaliveCountMax
+	}
+	return interval, countMax
+}
+
+// keepAlive 定期发送keepalive请求，直到连接关闭
+// 请求发送失败或连续countMax次在一个间隔内未收到回复时关闭连接，网络中断后等待client.Wait的调用方随即得知连接已断开
+func keepAlive(client *ssh.Client, cfg *types.SSHConfig) {
+	interval, countMax := keepaliveSettings(cfg)
+	if interval <= 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		client.Wait()
+		close(done)
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	missed := 0
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+		}
+
+		reply := make(chan error, 1)
+		go func() {
+			_, _, err := client.SendRequest(keepaliveRequest, true, nil)
+			reply <- err
+		}()
+
+		select {
+		case <-done:
+			return
+		case err := <-reply:
+			if err != nil {
+				client.Close()
+				return
+			}
+			missed = 0
+		case <-time.After(interval):
+			missed++
+			if missed >= countMax {
+				client.Close()
+				return
+			}
+		}
+	}
+}
@@ -3,6 +3,7 @@ package connection
 import (
 	"bytes"
 	"fmt"
+	"os"
 	"os/exec"
 	"time"
 )
@@ -79,14 +80,57 @@ func (c *LocalConnection) ExecuteCommand(command string) (*ConnectionResult, err
 	return result, nil
 }
 
-// CopyFile 复制文件到远程主机
+// ExecuteStream 实现StreamExecutor接口，以流方式执行命令
+func (c *LocalConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	if !c.IsConnected() {
+		return -1, fmt.Errorf("连接未建立")
+	}
+	if opts.Pty {
+		return -1, fmt.Errorf("本地连接不支持伪终端")
+	}
+
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Env = processEnv(opts.Env)
+	return streamProcess(cmd, opts)
+}
+
+// CopyFile 复制文件到本机的目标路径，保留源文件权限
 func (c *LocalConnection) CopyFile(localPath, remotePath string) error {
-	return fmt.Errorf("本地连接不支持文件复制操作")
+	return c.CopyFileWithOptions(localPath, remotePath, nil)
 }
 
-// FetchFile 从远程主机获取文件
+// FetchFile 从本机获取文件，保留源文件权限
 func (c *LocalConnection) FetchFile(remotePath, localPath string) error {
-	return fmt.Errorf("本地连接不支持文件获取操作")
+	return c.FetchFileWithOptions(remotePath, localPath, nil)
+}
+
+// CopyFileWithOptions 复制文件到本机的目标路径，先写入临时文件再重命名
+func (c *LocalConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	return copyLocalFile(localPath, remotePath, opts)
+}
+
+// FetchFileWithOptions 从本机获取文件，先写入临时文件再重命名
+func (c *LocalConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	return copyLocalFile(remotePath, localPath, opts)
+}
+
+// copyLocalFile 在本机复制文件
+func copyLocalFile(srcPath, dstPath string, opts *TransferOptions) error {
+	src, err := os.Open(srcPath)
+	if err != nil {
+		return fmt.Errorf("打开源文件失败: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("读取源文件信息失败: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("不支持复制目录: %s", srcPath)
+	}
+
+	return writeLocalFile(dstPath, src, info.Size(), transferMode(opts, info.Mode()), opts)
 }
 
 // GetType 获取连接类型
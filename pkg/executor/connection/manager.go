@@ -3,91 +3,394 @@ package connection
 import (
 	"fmt"
 	"sync"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config"
 	"github.com/ape902/ansible-go/pkg/config/types"
 )
 
+// defaultIdleTimeout 未配置idle_timeout时空闲连接的保留时间
+const defaultIdleTimeout = 5 * time.Minute
+
 // ConnectionManagerImpl 实现连接管理器
 type ConnectionManagerImpl struct {
 	pool      *Pool
 	mutex     sync.RWMutex
+	released  *sync.Cond // 连接数达到上限时等待其他任务释放连接
 	sshConfig *types.SSHConfig
+	jumps     *jumpDialer             // 跳板机连接，经过同一跳板机的主机共享连接
+	dialing   map[string]*pendingDial // 正在建立的连接，同一主机参数只建立一次
+
+	sshConfigOnce sync.Once      // 保证ssh_config文件只加载一次
+	sshConfigFile *SSHConfigFile // 解析后的ssh_config文件
+	sshConfigErr  error          // 加载ssh_config文件的错误
 }
 
 // NewConnectionManager 创建新的连接管理器
 func NewConnectionManager(pool *Pool, sshConfig *types.SSHConfig) ConnectionManager {
-	return &ConnectionManagerImpl{
+	m := &ConnectionManagerImpl{
 		pool:      pool,
 		sshConfig: sshConfig,
+		jumps:     newJumpDialer(sshConfig),
+		dialing:   make(map[string]*pendingDial),
 	}
+	m.released = sync.NewCond(&m.mutex)
+	return m
 }
 
 // GetConnection 获取指定主机的连接
 func (m *ConnectionManagerImpl) GetConnection(host string, port int, connType ConnectionType) (Connection, error) {
-	m.mutex.Lock()
-	defer m.mutex.Unlock()
+	params := NewHostParams(host)
+	params.Port = port
+	params.Type = connType
+	return m.GetHostConnection(params)
+}
+
+// pendingDial 正在建立的连接，建立完成后关闭done，err为建立连接的错误
+type pendingDial struct {
+	done chan struct{}
+	err  error
+}
 
-	// 尝试从连接池获取连接
-	conn, err := m.pool.Get(host)
+// GetHostConnection 按解析后的主机参数获取连接
+// 建立连接（包括重连等待）时不持有锁，其他主机的任务可以继续获取连接；
+// 同一主机参数的连接正在建立时等待其完成并复用，建立失败时返回同一错误
+func (m *ConnectionManagerImpl) GetHostConnection(params *HostParams) (Connection, error) {
+	params, err := m.resolve(params)
 	if err != nil {
-		return nil, fmt.Errorf("从连接池获取连接失败: %w", err)
-	}
-
-	// 如果连接不存在，创建新连接
-	if conn == nil {
-		// 根据连接类型创建不同的连接
-		switch connType {
-		case ConnectionTypeSSH:
-			conn = NewSSHConnection(host, port)
-			// 设置SSH连接的用户名和密码
-			if sshConn, ok := conn.(*SSHConnection); ok {
-				// 使用配置文件中的用户名和密码
-				sshConn.User = m.sshConfig.User
-				sshConn.Password = m.sshConfig.Password
+		return nil, err
+	}
+	key := params.Key()
+
+	m.mutex.Lock()
+	for {
+		m.closeIdleLocked()
+
+		// 尝试从连接池获取连接
+		conn, err := m.pool.Get(key)
+		if err != nil {
+			m.mutex.Unlock()
+			return nil, fmt.Errorf("从连接池获取连接失败: %w", err)
+		}
+
+		// 丢弃已断开的连接（如keepalive超时），重新建立连接
+		if conn != nil && !conn.IsConnected() {
+			m.pool.Remove(key)
+			conn.Disconnect()
+			conn = nil
+		}
+		if conn != nil {
+			m.pool.Acquire(key)
+			m.mutex.Unlock()
+			return conn, nil
+		}
+
+		if pending, ok := m.dialing[key]; ok {
+			m.mutex.Unlock()
+			<-pending.done
+			if pending.err != nil {
+				return nil, pending.err
 			}
-		case ConnectionTypeLocal:
-			conn = NewLocalConnection()
-		default:
-			return nil, fmt.Errorf("不支持的连接类型: %s", connType)
+			m.mutex.Lock()
+			continue
 		}
 
-		// 建立连接
-		err = conn.Connect()
-		if err != nil {
-			return nil, fmt.Errorf("建立连接失败: %w", err)
+		if m.waitCapacityLocked() {
+			break
 		}
+	}
+
+	pending := &pendingDial{done: make(chan struct{})}
+	m.dialing[key] = pending
+	m.mutex.Unlock()
+
+	conn, err := m.connect(params)
 
-		// 添加到连接池
-		m.pool.Add(host, conn)
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+	delete(m.dialing, key)
+	pending.err = err
+	close(pending.done)
+	if err != nil {
+		// 释放占用的连接数配额
+		m.released.Broadcast()
+		return nil, err
 	}
 
+	// 添加到连接池
+	m.pool.Add(key, conn)
+	m.pool.Acquire(key)
 	return conn, nil
 }
 
-// ReleaseConnection 释放连接
+// connect 根据连接类型创建连接并建立连接，失败时按配置重连
+func (m *ConnectionManagerImpl) connect(params *HostParams) (Connection, error) {
+	var conn Connection
+	switch params.Type {
+	case ConnectionTypeSSH:
+		sshConn := NewSSHConnection(params.Addr(), params.Port).(*SSHConnection)
+		sshConn.User = params.User
+		sshConn.Password = params.Password
+		sshConn.KeyFile = params.KeyFile
+		sshConn.CertFile = params.CertFile
+		sshConn.KeyPassword = params.KeyPassword
+		sshConn.ForwardAgent = params.ForwardAgent
+		sshConn.IdentityFiles = params.IdentityFiles
+		sshConn.Config = m.sshConfig
+		dial, err := m.jumps.dialer(params.JumpHosts, params.Proxy)
+		if err != nil {
+			return nil, fmt.Errorf("主机 %s 的代理配置错误: %w", params.Host, err)
+		}
+		sshConn.Dial = dial
+		sshConn.MaxSessions = m.sshConfig.MaxSessions
+		sshConn.MaxClients = m.sshConfig.MaxConnectionsPerHost
+		conn = sshConn
+	case ConnectionTypeLocal:
+		conn = NewLocalConnection()
+	case ConnectionTypeDocker:
+		dockerConn := NewDockerConnection(params.Addr())
+		dockerConn.User = params.User
+		if params.DockerBinary != "" {
+			dockerConn.Binary = params.DockerBinary
+		}
+		conn = dockerConn
+	case ConnectionTypeChroot:
+		chrootConn := NewChrootConnection(params.Addr())
+		chrootConn.User = params.User
+		conn = chrootConn
+	case ConnectionTypeNspawn:
+		nspawnConn := NewNspawnConnection(params.Addr())
+		nspawnConn.User = params.User
+		conn = nspawnConn
+	default:
+		return nil, fmt.Errorf("不支持的连接类型: %s", params.Type)
+	}
+
+	if err := m.connectWithRetry(conn); err != nil {
+		return nil, fmt.Errorf("建立连接失败: %w", err)
+	}
+	return conn, nil
+}
+
+// waitCapacityLocked 判断是否可以再建立一个连接，正在建立的连接也计入连接数
+// 达到上限时关闭最久未使用的空闲连接；所有连接都在使用中时等待其他任务释放并返回false，调用方需要重新检查连接池
+func (m *ConnectionManagerImpl) waitCapacityLocked() bool {
+	if m.sshConfig.MaxConnections <= 0 {
+		return true
+	}
+	for m.pool.Len()+len(m.dialing) >= m.sshConfig.MaxConnections {
+		if key, ok := m.pool.LeastRecentlyUsed(); ok {
+			m.closeLocked(key)
+			continue
+		}
+		m.released.Wait()
+		return false
+	}
+	return true
+}
+
+// closeIdleLocked 关闭空闲时间超过idle_timeout和已断开的连接
+func (m *ConnectionManagerImpl) closeIdleLocked() {
+	ttl := defaultIdleTimeout
+	if m.sshConfig.IdleTimeout > 0 {
+		ttl = time.Duration(m.sshConfig.IdleTimeout) * time.Second
+	}
+	for _, key := range m.pool.Idle(ttl) {
+		m.closeLocked(key)
+	}
+	for _, key := range m.pool.Idle(0) {
+		if conn, _ := m.pool.Get(key); conn != nil && !conn.IsConnected() {
+			m.closeLocked(key)
+		}
+	}
+}
+
+// closeLocked 从连接池移除并断开连接，忽略断开时的错误
+func (m *ConnectionManagerImpl) closeLocked(key string) {
+	conn, _ := m.pool.Get(key)
+	m.pool.Remove(key)
+	if conn != nil {
+		conn.Disconnect()
+	}
+}
+
+// ResolveHostParams 使用ssh_config和全局SSH配置补全主机参数，返回实际使用的连接参数
+func (m *ConnectionManagerImpl) ResolveHostParams(params *HostParams) (*HostParams, error) {
+	return m.resolve(params)
+}
+
+// resolve 补全主机参数中未设置的字段
+// 优先级从高到低: 主机参数（来自清单） > ssh_config > 全局SSH配置
+// 本地、docker、chroot和nspawn连接不使用SSH参数，原样返回
+func (m *ConnectionManagerImpl) resolve(params *HostParams) (*HostParams, error) {
+	resolved := *params
+	// 复制切片，补全时追加的私钥不写入调用方的底层数组
+	resolved.IdentityFiles = append([]string(nil), params.IdentityFiles...)
+	if resolved.Type == "" {
+		resolved.Type = resolved.DefaultType()
+	}
+	switch resolved.Type {
+	case ConnectionTypeLocal, ConnectionTypeDocker, ConnectionTypeChroot, ConnectionTypeNspawn:
+		return &resolved, nil
+	}
+
+	if resolved.Type == ConnectionTypeSSH {
+		if err := m.applySSHConfigFile(&resolved); err != nil {
+			return nil, err
+		}
+	}
+
+	if resolved.Port == 0 {
+		resolved.Port = 22
+		if m.sshConfig.Port > 0 {
+			resolved.Port = m.sshConfig.Port
+		}
+	}
+	if resolved.User == "" {
+		resolved.User = m.sshConfig.User
+	}
+	if resolved.Password == "" {
+		resolved.Password = m.sshConfig.Password
+	}
+	// 主机或ssh_config已指定私钥时不使用全局私钥作为主机私钥，全局私钥在主机私钥之后尝试
+	if resolved.KeyFile == "" && len(resolved.IdentityFiles) == 0 {
+		resolved.KeyFile = m.sshConfig.KeyFile
+	}
+	if resolved.KeyPassword == "" {
+		resolved.KeyPassword = m.sshConfig.KeyPassword
+	}
+	if resolved.JumpHosts == nil {
+		resolved.JumpHosts = config.GlobalJumpHosts(m.sshConfig)
+	}
+	if resolved.Proxy == "" {
+		resolved.Proxy = m.sshConfig.Proxy
+	}
+	if resolved.Proxy == config.ProxyNone {
+		resolved.Proxy = ""
+	}
+	return &resolved, nil
+}
+
+// applySSHConfigFile 使用ssh_config中适用于该主机的配置补全未设置的字段
+func (m *ConnectionManagerImpl) applySSHConfigFile(params *HostParams) error {
+	file, err := m.loadSSHConfigFile()
+	if err != nil || file == nil {
+		return err
+	}
+
+	hostCfg := file.Lookup(params.Addr())
+	if params.Address == "" && hostCfg.HostName != "" {
+		params.Address = hostCfg.HostName
+	}
+	if params.Port == 0 {
+		params.Port = hostCfg.Port
+	}
+	if params.User == "" {
+		params.User = hostCfg.User
+	}
+	params.IdentityFiles = append(params.IdentityFiles, hostCfg.IdentityFiles...)
+
+	if params.JumpHosts == nil && hostCfg.ProxyJump != "" {
+		hops, err := config.ParseJumpHosts(hostCfg.ProxyJump)
+		if err != nil {
+			return fmt.Errorf("解析ssh_config中主机 %s 的ProxyJump失败: %w", params.Addr(), err)
+		}
+		// 跳板机本身也可以是ssh_config中的主机别名
+		for i := range hops {
+			hopCfg := file.Lookup(hops[i].Host)
+			if hopCfg.HostName != "" {
+				hops[i].Host = hopCfg.HostName
+			}
+			if hops[i].Port == 0 {
+				hops[i].Port = hopCfg.Port
+			}
+			if hops[i].User == "" {
+				hops[i].User = hopCfg.User
+			}
+			if hops[i].KeyFile == "" && len(hopCfg.IdentityFiles) > 0 {
+				hops[i].KeyFile = hopCfg.IdentityFiles[0]
+			}
+		}
+		params.JumpHosts = hops
+	}
+	return nil
+}
+
+// loadSSHConfigFile 加载配置中指定的ssh_config文件，未配置时返回nil
+func (m *ConnectionManagerImpl) loadSSHConfigFile() (*SSHConfigFile, error) {
+	if m.sshConfig.SSHConfigFile == "" {
+		return nil, nil
+	}
+	m.sshConfigOnce.Do(func() {
+		m.sshConfigFile, m.sshConfigErr = LoadSSHConfigFile(m.sshConfig.SSHConfigFile)
+	})
+	return m.sshConfigFile, m.sshConfigErr
+}
+
+// connectWithRetry 建立连接，失败后最多重连ReconnectAttempts次
+func (m *ConnectionManagerImpl) connectWithRetry(conn Connection) error {
+	interval := time.Second
+	if m.sshConfig.ReconnectInterval > 0 {
+		interval = time.Duration(m.sshConfig.ReconnectInterval) * time.Second
+	}
+
+	var err error
+	for attempt := 0; attempt <= m.sshConfig.ReconnectAttempts; attempt++ {
+		if attempt > 0 {
+			time.Sleep(interval)
+		}
+		if err = conn.Connect(); err == nil {
+			return nil
+		}
+	}
+	return err
+}
+
+// ReleaseConnection 释放连接，连接保留在池中供后续任务复用，空闲超过idle_timeout后关闭
 func (m *ConnectionManagerImpl) ReleaseConnection(conn Connection) {
-	// 当前简单实现，保持连接在池中
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	if m.pool.Release(conn) {
+		m.released.Broadcast()
+	}
 }
 
 // CloseConnection 关闭指定连接
 func (m *ConnectionManagerImpl) CloseConnection(host string, port int, connType ConnectionType) error {
+	params := NewHostParams(host)
+	params.Port = port
+	params.Type = connType
+	return m.CloseHostConnection(params)
+}
+
+// CloseHostConnection 按解析后的主机参数关闭连接
+func (m *ConnectionManagerImpl) CloseHostConnection(params *HostParams) error {
 	m.mutex.Lock()
 	defer m.mutex.Unlock()
 
+	resolved, err := m.resolve(params)
+	if err != nil {
+		return err
+	}
+	key := resolved.Key()
+
 	// 从连接池获取连接
-	conn, err := m.pool.Get(host)
+	conn, err := m.pool.Get(key)
 	if err != nil {
 		return fmt.Errorf("从连接池获取连接失败: %w", err)
 	}
 
 	if conn != nil {
+		// 从连接池移除
+		m.pool.Remove(key)
+		m.released.Broadcast()
+
 		// 断开连接
 		err = conn.Disconnect()
 		if err != nil {
 			return fmt.Errorf("断开连接失败: %w", err)
 		}
-
-		// 从连接池移除
-		m.pool.Remove(host)
 	}
 
 	return nil
@@ -99,13 +402,21 @@ func (m *ConnectionManagerImpl) CloseAll() {
 	defer m.mutex.Unlock()
 
 	// 遍历所有连接并关闭
-	for host, conn := range m.pool.connections {
-		conn.Disconnect()
-		m.pool.Remove(host)
+	for _, key := range m.pool.Keys() {
+		m.closeLocked(key)
 	}
+	m.released.Broadcast()
+
+	// 目标主机的连接关闭后再关闭跳板机连接
+	m.jumps.closeAll()
 }
 
-// CleanIdleConnections 清理空闲连接
+// CleanIdleConnections 关闭空闲时间超过idle_timeout和已断开的连接
+// 获取连接时也会清理，长时间没有任务执行时可以定期调用
 func (m *ConnectionManagerImpl) CleanIdleConnections() {
-	// 当前简单实现，不做任何操作
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	m.closeIdleLocked()
+	m.released.Broadcast()
 }
\ No newline at end of file
@@ -0,0 +1,156 @@
+package connection
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+)
+
+// slowInspectStub 模拟docker inspect：记录被检查的容器，slow容器延迟1秒，broken容器延迟后失败
+const slowInspectStub = `#!/bin/sh
+echo "$4" >> "$DOCKER_STUB_LOG"
+case "$4" in
+slow) sleep 1 ;;
+broken) sleep 0.3; echo "no such container" >&2; exit 1 ;;
+esac
+echo true
+`
+
+func newStubManager(t *testing.T) (*ConnectionManagerImpl, func(container string) *HostParams, func() []string) {
+	t.Helper()
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "docker")
+	if err := os.WriteFile(binary, []byte(slowInspectStub), 0755); err != nil {
+		t.Fatal(err)
+	}
+	log := filepath.Join(dir, "calls")
+	t.Setenv("DOCKER_STUB_LOG", log)
+
+	m := NewConnectionManager(NewPool(), &types.SSHConfig{}).(*ConnectionManagerImpl)
+	params := func(container string) *HostParams {
+		return &HostParams{Host: container, Type: ConnectionTypeDocker, DockerBinary: binary}
+	}
+	calls := func() []string {
+		data, _ := os.ReadFile(log)
+		return strings.Fields(string(data))
+	}
+	return m, params, calls
+}
+
+func TestGetHostConnectionDialsOncePerKey(t *testing.T) {
+	m, params, calls := newStubManager(t)
+
+	var wg sync.WaitGroup
+	conns := make([]Connection, 5)
+	errs := make([]error, 5)
+	for i := range conns {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			conns[i], errs[i] = m.GetHostConnection(params("slow"))
+		}(i)
+	}
+	wg.Wait()
+
+	for i := range conns {
+		if errs[i] != nil {
+			t.Fatalf("caller %d: %v", i, errs[i])
+		}
+		if conns[i] != conns[0] {
+			t.Fatalf("caller %d got a different connection", i)
+		}
+	}
+	if got := calls(); len(got) != 1 {
+		t.Fatalf("inspect calls = %q, want one dial", got)
+	}
+}
+
+func TestGetHostConnectionDoesNotBlockOtherHosts(t *testing.T) {
+	m, params, _ := newStubManager(t)
+
+	slowDone := make(chan error, 1)
+	go func() {
+		_, err := m.GetHostConnection(params("slow"))
+		slowDone <- err
+	}()
+	time.Sleep(100 * time.Millisecond)
+
+	start := time.Now()
+	if _, err := m.GetHostConnection(params("fast")); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > 700*time.Millisecond {
+		t.Fatalf("fast host waited %v for the slow dial", elapsed)
+	}
+	if err := <-slowDone; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetHostConnectionSharesDialFailure(t *testing.T) {
+	m, params, calls := newStubManager(t)
+
+	var wg sync.WaitGroup
+	errs := make([]error, 3)
+	for i := range errs {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, errs[i] = m.GetHostConnection(params("broken"))
+		}(i)
+	}
+	wg.Wait()
+
+	for i, err := range errs {
+		if err == nil || !strings.Contains(err.Error(), "no such container") {
+			t.Fatalf("caller %d: err = %v", i, err)
+		}
+	}
+	if got := calls(); len(got) != 1 {
+		t.Fatalf("inspect calls = %q, want one dial", got)
+	}
+	if len(m.dialing) != 0 || m.pool.Len() != 0 {
+		t.Fatalf("dialing = %d, pool = %d after failure", len(m.dialing), m.pool.Len())
+	}
+}
+
+func TestResolvePrefersSSHConfigIdentity(t *testing.T) {
+	file := writeSSHConfig(t, map[string]string{"config": "Host web1\n    IdentityFile ~/.ssh/web1_key\n"})
+	m := NewConnectionManager(NewPool(), &types.SSHConfig{SSHConfigFile: file, KeyFile: "~/.ssh/id_rsa"}).(*ConnectionManagerImpl)
+
+	identities := make([]string, 1, 4)
+	identities[0] = "~/.ssh/inventory_key"
+	resolved, err := m.ResolveHostParams(&HostParams{Host: "web1", Type: ConnectionTypeSSH, IdentityFiles: identities})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolved.KeyFile != "" {
+		t.Fatalf("KeyFile = %q, global key must not take precedence over ssh_config", resolved.KeyFile)
+	}
+	if want := []string{"~/.ssh/inventory_key", "~/.ssh/web1_key"}; !reflect.DeepEqual(resolved.IdentityFiles, want) {
+		t.Fatalf("IdentityFiles = %q, want %q", resolved.IdentityFiles, want)
+	}
+	if extra := identities[:2][1]; extra != "" {
+		t.Fatalf("resolve wrote %q into the caller's slice", extra)
+	}
+
+	creds := sshCredentials{KeyFile: resolved.KeyFile, IdentityFiles: resolved.IdentityFiles}
+	order := identityFiles(creds, m.sshConfig)
+	if want := []string{"~/.ssh/inventory_key", "~/.ssh/web1_key", "~/.ssh/id_rsa"}; !reflect.DeepEqual(order, want) {
+		t.Fatalf("identity order = %q, want %q", order, want)
+	}
+
+	other, err := m.ResolveHostParams(&HostParams{Host: "db1", Type: ConnectionTypeSSH})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.KeyFile != "~/.ssh/id_rsa" {
+		t.Fatalf("KeyFile = %q, want global key for hosts without ssh_config identities", other.KeyFile)
+	}
+}
@@ -0,0 +1,189 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxOutputLine 单行输出的最大长度，超过时不等待换行直接报告
+const maxOutputLine = 64 << 10
+
+// OutputStream 定义命令输出流
+type OutputStream string
+
+const (
+	// OutputStdout 标准输出
+	OutputStdout OutputStream = "stdout"
+	// OutputStderr 标准错误
+	OutputStderr OutputStream = "stderr"
+)
+
+// OutputFunc 定义命令输出回调，命令执行过程中按行调用，line不包含换行符
+// 标准输出和标准错误可能在不同的goroutine中回调
+type OutputFunc func(stream OutputStream, line string)
+
+// outputKey 上下文中命令输出回调的键
+type outputKey struct{}
+
+// WithOutput 在上下文中设置命令输出回调，供执行命令的模块使用
+func WithOutput(ctx context.Context, output OutputFunc) context.Context {
+	return context.WithValue(ctx, outputKey{}, output)
+}
+
+// OutputFromContext 获取上下文中的命令输出回调，未设置时返回nil
+func OutputFromContext(ctx context.Context) OutputFunc {
+	output, _ := ctx.Value(outputKey{}).(OutputFunc)
+	return output
+}
+
+// CommandOptions 定义执行命令时的可选参数
+type CommandOptions struct {
+	Stdin  string     // 写入命令标准输入的内容，为空时不提供输入
+	Pty    bool       // 是否分配伪终端，标准错误合并到标准输出，输出中的\r\n转换为\n
+	Output OutputFunc // 命令输出回调，执行过程中按行报告输出
+}
+
+// ExecuteCommandWithOutput 执行命令，执行过程中将输出按行报告给output，同时收集完整输出作为结果
+// output为nil时等同于ExecuteCommand，连接不支持流式执行时在命令结束后报告全部输出
+func ExecuteCommandWithOutput(conn Connection, command string, output OutputFunc) (*ConnectionResult, error) {
+	return ExecuteCommandWithOptions(conn, command, &CommandOptions{Output: output})
+}
+
+// ExecuteCommandWithOptions 按opts执行命令，提供标准输入或分配伪终端时要求连接支持流式执行
+func ExecuteCommandWithOptions(conn Connection, command string, opts *CommandOptions) (*ConnectionResult, error) {
+	interactive := opts.Stdin != "" || opts.Pty
+	output := opts.Output
+	if output == nil && !interactive {
+		return conn.ExecuteCommand(command)
+	}
+
+	streamer, ok := conn.(StreamExecutor)
+	if !ok {
+		if interactive {
+			return nil, fmt.Errorf("%s连接不支持标准输入和伪终端", conn.GetType())
+		}
+		result, err := conn.ExecuteCommand(command)
+		if err != nil {
+			return nil, err
+		}
+		reportLines(output, OutputStdout, result.Stdout)
+		reportLines(output, OutputStderr, result.Stderr)
+		return result, nil
+	}
+
+	var stdout, stderr bytes.Buffer
+	streamOpts := &StreamOptions{
+		Stdout: &stdout,
+		Stderr: &stderr,
+		Pty:    opts.Pty,
+	}
+	if opts.Stdin != "" || opts.Pty {
+		streamOpts.Stdin = strings.NewReader(ptyInput(opts.Stdin, opts.Pty))
+	}
+
+	var stdoutLines, stderrLines *lineWriter
+	if output != nil {
+		stdoutLines = newLineWriter(OutputStdout, output)
+		stderrLines = newLineWriter(OutputStderr, output)
+		streamOpts.Stdout = io.MultiWriter(&stdout, stdoutLines)
+		streamOpts.Stderr = io.MultiWriter(&stderr, stderrLines)
+	}
+
+	start := time.Now()
+	exitCode, err := streamer.ExecuteStream(command, streamOpts)
+	if output != nil {
+		stdoutLines.Flush()
+		stderrLines.Flush()
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	result := &ConnectionResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+		Duration: time.Since(start),
+	}
+	if opts.Pty {
+		// 伪终端将输出的\n转换为\r\n
+		result.Stdout = strings.ReplaceAll(result.Stdout, "\r\n", "\n")
+		result.Stderr = strings.ReplaceAll(result.Stderr, "\r\n", "\n")
+	}
+	return result, nil
+}
+
+// ptyInput 构造写入伪终端的输入
+// 伪终端上关闭标准输入不会使命令读到文件结束，需要在输入后发送Ctrl-D，输入不以换行结尾时需要两次
+// 没有输入时同样发送Ctrl-D，避免读取标准输入的命令一直等待
+func ptyInput(input string, pty bool) string {
+	if !pty {
+		return input
+	}
+	if input == "" || strings.HasSuffix(input, "\n") {
+		return input + "\x04"
+	}
+	return input + "\x04\x04"
+}
+
+// reportLines 按行报告已收集的输出
+func reportLines(output OutputFunc, stream OutputStream, text string) {
+	w := newLineWriter(stream, output)
+	w.Write([]byte(text))
+	w.Flush()
+}
+
+// lineWriter 将写入的内容按行报告给输出回调
+type lineWriter struct {
+	mu      sync.Mutex
+	stream  OutputStream
+	output  OutputFunc
+	pending []byte
+}
+
+// newLineWriter 创建按行报告输出的Writer
+func newLineWriter(stream OutputStream, output OutputFunc) *lineWriter {
+	return &lineWriter{stream: stream, output: output}
+}
+
+// Write 实现io.Writer接口，报告完整的行，不完整的行等待后续写入
+func (w *lineWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.pending = append(w.pending, p...)
+	for {
+		i := bytes.IndexByte(w.pending, '\n')
+		if i < 0 {
+			break
+		}
+		w.report(w.pending[:i])
+		w.pending = w.pending[i+1:]
+	}
+	if len(w.pending) >= maxOutputLine {
+		w.report(w.pending)
+		w.pending = nil
+	}
+	return len(p), nil
+}
+
+// Flush 报告剩余的不完整行
+func (w *lineWriter) Flush() {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if len(w.pending) > 0 {
+		w.report(w.pending)
+		w.pending = nil
+	}
+}
+
+// report 报告一行输出，去掉伪终端输出的\r
+func (w *lineWriter) report(line []byte) {
+	w.output(w.stream, strings.TrimSuffix(string(line), "\r"))
+}
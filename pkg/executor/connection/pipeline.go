@@ -0,0 +1,257 @@
+package connection
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// PipelineStep 定义流水线中的一个远程步骤
+type PipelineStep struct {
+	Name    string // 步骤名称，用于报告失败的步骤
+	Command string // 在远程主机上通过/bin/sh执行的命令
+}
+
+// StepResult 定义流水线中一个步骤的执行结果
+type StepResult struct {
+	Name     string
+	Stdout   string
+	Stderr   string
+	ExitCode int
+	Skipped  bool // 前面的步骤失败，该步骤未执行
+}
+
+// pipeliningKey 上下文中是否启用流水线的键
+type pipeliningKey struct{}
+
+// WithPipelining 在上下文中设置是否启用流水线，供执行多个远程步骤的模块使用
+func WithPipelining(ctx context.Context, enabled bool) context.Context {
+	return context.WithValue(ctx, pipeliningKey{}, enabled)
+}
+
+// PipeliningFromContext 获取上下文中是否启用流水线，未设置时不启用
+func PipeliningFromContext(ctx context.Context) bool {
+	enabled, _ := ctx.Value(pipeliningKey{}).(bool)
+	return enabled
+}
+
+// PipelineResult 定义一组步骤的执行结果
+type PipelineResult struct {
+	Stdout     string        // 各步骤的标准输出
+	Stderr     string        // 各步骤的标准错误
+	ExitCode   int           // 失败步骤的退出码，全部成功时为0
+	FailedStep string        // 失败步骤的名称，未启用流水线时无法区分步骤，为空
+	Steps      []*StepResult // 各步骤的结果，与steps一一对应，未启用流水线时为nil
+}
+
+// ExecutePipeline 依次执行多个步骤，某个步骤失败后不再执行后续步骤
+// 启用流水线时通过输出中的标记拆分每个步骤的输出和退出码，可以知道失败的步骤；
+// 未启用时所有步骤用&&连接为一条命令执行。两种方式都只使用一个会话
+func ExecutePipeline(ctx context.Context, conn Connection, steps []PipelineStep) (*PipelineResult, error) {
+	if !PipeliningFromContext(ctx) {
+		return executeJoined(conn, steps)
+	}
+
+	key := pipelineKey()
+	result, err := conn.ExecuteCommand(pipelineScript(key, "", steps))
+	if err != nil {
+		return nil, err
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return mergeSteps(parsePipelineOutput(key, steps, result)), nil
+}
+
+// CopyFileWithSteps 复制文件到远程主机，并对文件执行steps返回的步骤（例如chmod、chown），path为步骤操作的文件路径
+// 启用流水线且连接可以为命令提供标准输入时，文件内容通过标准输入写入目标目录下的临时文件，
+// 设置权限并执行所有步骤后再重命名为目标文件，上传和所有步骤只使用一个会话，失败时删除临时文件；
+// 否则先传输文件，再将所有步骤用&&连接为一条命令执行
+func CopyFileWithSteps(ctx context.Context, conn Connection, localPath, remotePath string, opts *TransferOptions, steps func(path string) []PipelineStep) (*PipelineResult, error) {
+	streamer, ok := conn.(StreamExecutor)
+	if !PipeliningFromContext(ctx) || !ok || !acceptsStdin(conn) {
+		if err := CopyFileWithOptions(conn, localPath, remotePath, opts); err != nil {
+			return nil, err
+		}
+		if post := steps(remotePath); len(post) > 0 {
+			return executeJoined(conn, post)
+		}
+		return &PipelineResult{}, nil
+	}
+
+	src, err := os.Open(localPath)
+	if err != nil {
+		return nil, fmt.Errorf("打开本地文件失败: %w", err)
+	}
+	defer src.Close()
+	info, err := src.Stat()
+	if err != nil {
+		return nil, fmt.Errorf("读取本地文件信息失败: %w", err)
+	}
+	if info.IsDir() {
+		return nil, fmt.Errorf("不支持复制目录: %s", localPath)
+	}
+
+	tmpPath := remoteTempPath(remotePath)
+	tmp := ShellQuote(tmpPath)
+	all := []PipelineStep{
+		{Name: "upload", Command: fmt.Sprintf("umask 077 && cat > %s", tmp)},
+		// 本地读取中断时cat也会正常结束，通过文件大小确认内容完整
+		{Name: "verify", Command: fmt.Sprintf("[ \"$(wc -c < %s)\" -eq %d ] || { echo '上传的文件不完整' >&2; exit 1; }", tmp, info.Size())},
+		{Name: "chmod", Command: fmt.Sprintf("chmod %04o %s", transferMode(opts, info.Mode()), tmp)},
+	}
+	all = append(all, steps(tmpPath)...)
+	all = append(all, PipelineStep{Name: "rename", Command: fmt.Sprintf("mv -f %s %s", tmp, ShellQuote(remotePath))})
+
+	key := pipelineKey()
+	var stdout, stderr bytes.Buffer
+	exitCode, err := streamer.ExecuteStream(pipelineScript(key, "trap "+ShellQuote("rm -f "+tmp)+" EXIT", all), &StreamOptions{
+		Stdin:  io.TeeReader(src, newProgressWriter(io.Discard, info.Size(), opts)),
+		Stdout: &stdout,
+		Stderr: &stderr,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return mergeSteps(parsePipelineOutput(key, all, &ConnectionResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+	})), nil
+}
+
+// JoinSteps 将所有步骤的命令用&&连接为一条命令
+func JoinSteps(steps []PipelineStep) string {
+	commands := make([]string, len(steps))
+	for i, step := range steps {
+		commands[i] = step.Command
+	}
+	return strings.Join(commands, " && ")
+}
+
+// executeJoined 将所有步骤用&&连接为一条命令执行
+func executeJoined(conn Connection, steps []PipelineStep) (*PipelineResult, error) {
+	result, err := conn.ExecuteCommand(JoinSteps(steps))
+	if err != nil {
+		return nil, err
+	}
+	if result.Error != nil {
+		return nil, result.Error
+	}
+	return &PipelineResult{
+		Stdout:   result.Stdout,
+		Stderr:   result.Stderr,
+		ExitCode: result.ExitCode,
+	}, nil
+}
+
+// mergeSteps 合并各步骤的输出，退出码和失败步骤取第一个失败的步骤
+func mergeSteps(steps []*StepResult) *PipelineResult {
+	merged := &PipelineResult{Steps: steps}
+	var stdout, stderr strings.Builder
+	for _, step := range steps {
+		if step.Skipped {
+			continue
+		}
+		stdout.WriteString(step.Stdout)
+		stderr.WriteString(step.Stderr)
+		if step.ExitCode != 0 && merged.FailedStep == "" {
+			merged.ExitCode = step.ExitCode
+			merged.FailedStep = step.Name
+		}
+	}
+	merged.Stdout = stdout.String()
+	merged.Stderr = stderr.String()
+	return merged
+}
+
+// acceptsStdin 判断连接能否为命令提供标准输入以传输文件内容
+// 提权连接使用su和doas时经过伪终端，会改写文件内容，因此提权连接仍先传输文件再执行命令
+func acceptsStdin(conn Connection) bool {
+	switch c := conn.(type) {
+	case *BecomeConnection:
+		return false
+	case *EnvConnection:
+		return acceptsStdin(c.Connection)
+	case *auditStreamConnection:
+		return acceptsStdin(c.Connection)
+	}
+	_, ok := conn.(StreamExecutor)
+	return ok
+}
+
+// pipelineKey 生成标记前缀，每次执行使用不同的前缀，避免与命令输出冲突
+func pipelineKey() string {
+	return "__ANSIBLE_GO_STEP_" + randomHex(8)
+}
+
+// pipelineScript 构造依次执行所有步骤的脚本，prelude不为空时在所有步骤之前执行
+// 每个步骤在子shell中执行，前后在标准输出和标准错误中输出带步骤序号的标记，结束标记前的换行不属于步骤输出，
+// 步骤失败时脚本以该步骤的退出码退出
+func pipelineScript(key, prelude string, steps []PipelineStep) string {
+	var b strings.Builder
+	if prelude != "" {
+		b.WriteString(prelude + "\n")
+	}
+	for i, step := range steps {
+		marker := ShellQuote(fmt.Sprintf("%s:%d", key, i))
+		fmt.Fprintf(&b, "printf '%%s:begin\\n' %s; printf '%%s:begin\\n' %s >&2\n", marker, marker)
+		fmt.Fprintf(&b, "( %s\n); __rc=$?\n", step.Command)
+		fmt.Fprintf(&b, "printf '\\n%%s:end:%%d\\n' %s \"$__rc\"; printf '\\n%%s:end\\n' %s >&2\n", marker, marker)
+		b.WriteString("[ \"$__rc\" -eq 0 ] || exit \"$__rc\"\n")
+	}
+	return "/bin/sh -c " + ShellQuote(b.String())
+}
+
+// parsePipelineOutput 按标记拆分脚本输出，没有开始标记的步骤视为未执行
+// 有开始标记但没有结束标记的步骤（例如脚本被终止）使用剩余的输出和脚本的退出码
+func parsePipelineOutput(key string, steps []PipelineStep, result *ConnectionResult) []*StepResult {
+	results := make([]*StepResult, len(steps))
+	for i, step := range steps {
+		marker := fmt.Sprintf("%s:%d", key, i)
+		stdout, stdoutEnd, ok := stepOutput(result.Stdout, marker)
+		if !ok {
+			results[i] = &StepResult{Name: step.Name, Skipped: true}
+			continue
+		}
+		stderr, _, _ := stepOutput(result.Stderr, marker)
+
+		exitCode := result.ExitCode
+		if stdoutEnd != "" {
+			if code, err := strconv.Atoi(strings.TrimPrefix(stdoutEnd, ":")); err == nil {
+				exitCode = code
+			}
+		}
+		results[i] = &StepResult{
+			Name:     step.Name,
+			Stdout:   stdout,
+			Stderr:   stderr,
+			ExitCode: exitCode,
+		}
+	}
+	return results
+}
+
+// stepOutput 获取输出中某个步骤开始标记和结束标记之间的内容，以及结束标记后同一行的内容
+func stepOutput(output, marker string) (string, string, bool) {
+	begin := marker + ":begin\n"
+	start := strings.Index(output, begin)
+	if start < 0 {
+		return "", "", false
+	}
+	rest := output[start+len(begin):]
+
+	end := strings.Index(rest, "\n"+marker+":end")
+	if end < 0 {
+		return rest, "", true
+	}
+	tail := rest[end+len("\n"+marker+":end"):]
+	if nl := strings.IndexByte(tail, '\n'); nl >= 0 {
+		tail = tail[:nl]
+	}
+	return rest[:end], tail, true
+}
@@ -0,0 +1,279 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+)
+
+func TestParsePipelineOutput(t *testing.T) {
+	const key = "__K"
+	steps := []PipelineStep{{Name: "mkdir"}, {Name: "chmod"}, {Name: "chown"}}
+	tests := []struct {
+		name   string
+		result *ConnectionResult
+		want   []*StepResult
+	}{
+		{
+			name: "all steps",
+			result: &ConnectionResult{
+				Stdout: "__K:0:begin\nmade\n\n__K:0:end:0\n__K:1:begin\n\n__K:1:end:0\n__K:2:begin\nok\n\n__K:2:end:0\n",
+				Stderr: "__K:0:begin\n\n__K:0:end\n__K:1:begin\nwarn\n\n__K:1:end\n__K:2:begin\n\n__K:2:end\n",
+			},
+			want: []*StepResult{
+				{Name: "mkdir", Stdout: "made\n"},
+				{Name: "chmod", Stderr: "warn\n"},
+				{Name: "chown", Stdout: "ok\n"},
+			},
+		},
+		{
+			name: "step without trailing newline",
+			result: &ConnectionResult{
+				Stdout: "__K:0:begin\nno newline\n__K:0:end:0\n__K:1:begin\n\n__K:1:end:0\n__K:2:begin\n\n__K:2:end:0\n",
+				Stderr: "__K:0:begin\nerr\n__K:0:end\n",
+			},
+			want: []*StepResult{
+				{Name: "mkdir", Stdout: "no newline", Stderr: "err"},
+				{Name: "chmod"},
+				{Name: "chown"},
+			},
+		},
+		{
+			name: "failed step stops the pipeline",
+			result: &ConnectionResult{
+				Stdout:   "__K:0:begin\n\n__K:0:end:0\n__K:1:begin\n\n__K:1:end:1\n",
+				Stderr:   "__K:0:begin\n\n__K:0:end\n__K:1:begin\nchmod: denied\n\n__K:1:end\n",
+				ExitCode: 1,
+			},
+			want: []*StepResult{
+				{Name: "mkdir"},
+				{Name: "chmod", Stderr: "chmod: denied\n", ExitCode: 1},
+				{Name: "chown", Skipped: true},
+			},
+		},
+		{
+			// 会话在步骤执行中断开，只收到部分输出，退出码取会话的退出码
+			name: "partial output",
+			result: &ConnectionResult{
+				Stdout:   "__K:0:begin\n\n__K:0:end:0\n__K:1:begin\npart",
+				Stderr:   "__K:0:begin\n\n__K:0:end\n__K:1:begin\n",
+				ExitCode: 137,
+			},
+			want: []*StepResult{
+				{Name: "mkdir"},
+				{Name: "chmod", Stdout: "part", ExitCode: 137},
+				{Name: "chown", Skipped: true},
+			},
+		},
+		{
+			// 结束标记所在的行不完整
+			name: "missing end marker",
+			result: &ConnectionResult{
+				Stdout:   "__K:0:begin\nout\n\n__K:0:e",
+				ExitCode: -1,
+			},
+			want: []*StepResult{
+				{Name: "mkdir", Stdout: "out\n\n__K:0:e", ExitCode: -1},
+				{Name: "chmod", Skipped: true},
+				{Name: "chown", Skipped: true},
+			},
+		},
+	}
+	for _, tt := range tests {
+		got := parsePipelineOutput(key, steps, tt.result)
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s:", tt.name)
+			for i := range got {
+				t.Errorf("  step %d = %+v, want %+v", i, got[i], tt.want[i])
+			}
+		}
+	}
+}
+
+func TestPipelineScriptRoundTrip(t *testing.T) {
+	conn := NewLocalConnection()
+	if err := conn.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	steps := []PipelineStep{
+		{Name: "echo", Command: "echo first; echo warn >&2"},
+		{Name: "printf", Command: "printf 'no newline'"},
+		{Name: "fail", Command: "echo broken >&2; exit 3"},
+		{Name: "never", Command: "echo never"},
+	}
+
+	result, err := ExecutePipeline(WithPipelining(context.Background(), true), conn, steps)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []*StepResult{
+		{Name: "echo", Stdout: "first\n", Stderr: "warn\n"},
+		{Name: "printf", Stdout: "no newline"},
+		{Name: "fail", Stderr: "broken\n", ExitCode: 3},
+		{Name: "never", Skipped: true},
+	}
+	if !reflect.DeepEqual(result.Steps, want) {
+		for i := range result.Steps {
+			t.Errorf("step %d = %+v, want %+v", i, result.Steps[i], want[i])
+		}
+	}
+	if result.ExitCode != 3 || result.FailedStep != "fail" || result.Stdout != "first\nno newline" {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+func TestExecutePipelineWithoutPipeliningJoinsSteps(t *testing.T) {
+	rec := newRecordingConnection()
+	steps := []PipelineStep{
+		{Name: "mkdir", Command: "mkdir -p /srv"},
+		{Name: "chmod", Command: "chmod 0755 /srv"},
+	}
+	if _, err := ExecutePipeline(context.Background(), rec, steps); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"mkdir -p /srv && chmod 0755 /srv"}; !reflect.DeepEqual(rec.commands, want) {
+		t.Fatalf("commands = %q, want %q", rec.commands, want)
+	}
+}
+
+// groupWritable 返回为path增加组写权限的步骤
+func groupWritable(path string) []PipelineStep {
+	return []PipelineStep{{Name: "chmod", Command: "chmod g+w " + ShellQuote(path)}}
+}
+
+func TestCopyFileWithStepsUsesOneSession(t *testing.T) {
+	conn := newTestSSHServer(t, subsystemServe)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte("listen 80\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, pipelining := range []bool{false, true} {
+		remote := filepath.Join(dir, fmt.Sprintf("remote-%v", pipelining))
+		before := testSSHSessions.Load()
+		result, err := CopyFileWithSteps(WithPipelining(context.Background(), pipelining), conn, local, remote, &TransferOptions{Mode: 0640}, groupWritable)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sessions := testSSHSessions.Load() - before
+		if result.ExitCode != 0 {
+			t.Fatalf("pipelining=%v: result = %+v", pipelining, result)
+		}
+		if pipelining && sessions != 1 {
+			t.Fatalf("pipelined copy opened %d sessions, want 1", sessions)
+		}
+		if !pipelining && sessions < 2 {
+			t.Fatalf("separate copy opened %d sessions, want transfer and command sessions", sessions)
+		}
+
+		info, err := os.Stat(remote)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Mode().Perm() != 0660 {
+			t.Fatalf("pipelining=%v: mode = %o, want 660", pipelining, info.Mode().Perm())
+		}
+		if data, _ := os.ReadFile(remote); string(data) != "listen 80\n" {
+			t.Fatalf("pipelining=%v: content = %q", pipelining, data)
+		}
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".remote-*.ansible-go-tmp-*")); len(leftovers) > 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestCopyFileWithStepsFailedStep(t *testing.T) {
+	conn := newTestSSHServer(t, subsystemServe)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(dir, "remote")
+	if err := os.WriteFile(remote, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	failing := func(path string) []PipelineStep {
+		return []PipelineStep{{Name: "chown", Command: "echo 'chown: invalid user' >&2; exit 1"}}
+	}
+	result, err := CopyFileWithSteps(WithPipelining(context.Background(), true), conn, local, remote, nil, failing)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.ExitCode != 1 || result.FailedStep != "chown" || result.Stderr != "chown: invalid user\n" {
+		t.Fatalf("result = %+v, want chown failure", result)
+	}
+	if data, _ := os.ReadFile(remote); string(data) != "old" {
+		t.Fatalf("destination replaced after failed step: %q", data)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".remote.ansible-go-tmp-*")); len(leftovers) > 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestAcceptsStdin(t *testing.T) {
+	local := NewLocalConnection()
+	if !acceptsStdin(local) || !acceptsStdin(NewEnvConnection(local, map[string]string{"A": "1"})) {
+		t.Fatal("local connection should accept stdin")
+	}
+	if acceptsStdin(NewBecomeConnection(local, &types.BecomeConfig{User: "root"})) {
+		t.Fatal("become connection uploads through its own staging directory")
+	}
+	if !acceptsStdin(newRecordingConnection()) {
+		t.Fatal("stream executor should accept stdin")
+	}
+}
+
+// BenchmarkCopyFileWithSteps 比较上传后执行chmod时分开执行和流水线执行的耗时与会话数
+// 服务器应答延迟1ms；流水线脚本在远程多启动几个进程，在没有延迟的回环连接上反而更慢
+func BenchmarkCopyFileWithSteps(b *testing.B) {
+	conn := newTestSSHServerWithLatency(b, subsystemServe, time.Millisecond)
+	dir := b.TempDir()
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte(strings.Repeat("x", 4096)), 0644); err != nil {
+		b.Fatal(err)
+	}
+	remote := filepath.Join(dir, "remote")
+
+	for _, bench := range []struct {
+		name       string
+		pipelining bool
+	}{{"separate", false}, {"pipelined", true}} {
+		b.Run(bench.name, func(b *testing.B) {
+			ctx := WithPipelining(context.Background(), bench.pipelining)
+			before := testSSHSessions.Load()
+			for i := 0; i < b.N; i++ {
+				if _, err := CopyFileWithSteps(ctx, conn, local, remote, &TransferOptions{Mode: 0640}, groupWritable); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(testSSHSessions.Load()-before)/float64(b.N), "sessions/op")
+		})
+	}
+}
+
+func BenchmarkParsePipelineOutput(b *testing.B) {
+	const key = "__K"
+	steps := make([]PipelineStep, 4)
+	var stdout, stderr strings.Builder
+	for i := range steps {
+		steps[i] = PipelineStep{Name: fmt.Sprintf("step%d", i)}
+		fmt.Fprintf(&stdout, "%s:%d:begin\n%s\n\n%s:%d:end:0\n", key, i, strings.Repeat("out ", 64), key, i)
+		fmt.Fprintf(&stderr, "%s:%d:begin\n\n%s:%d:end\n", key, i, key, i)
+	}
+	result := &ConnectionResult{Stdout: stdout.String(), Stderr: stderr.String()}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		parsePipelineOutput(key, steps, result)
+	}
+}
@@ -1,33 +1,137 @@
 package connection
 
-// Pool 定义连接池接口
+import (
+	"sync"
+	"time"
+)
+
+// Pool 定义连接池，记录每个连接的使用情况，可以在多个goroutine中并发使用
 type Pool struct {
-	connections map[string]Connection
+	mutex       sync.Mutex
+	connections map[string]*poolEntry
 }
 
-
+// poolEntry 连接池中的连接及其使用情况
+type poolEntry struct {
+	conn     Connection
+	inUse    int       // 正在使用该连接的任务数
+	lastUsed time.Time // 最后一次获取或释放的时间
+}
 
 // NewPool 创建新的连接池
 func NewPool() *Pool {
 	return &Pool{
-		connections: make(map[string]Connection),
+		connections: make(map[string]*poolEntry),
 	}
 }
 
-// Get 获取连接
-func (p *Pool) Get(host string) (Connection, error) {
-	if conn, ok := p.connections[host]; ok {
-		return conn, nil
+// Get 获取连接，key通常由HostParams.Key生成
+func (p *Pool) Get(key string) (Connection, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if entry, ok := p.connections[key]; ok {
+		return entry.conn, nil
 	}
 	return nil, nil
 }
 
 // Add 添加连接
-func (p *Pool) Add(host string, conn Connection) {
-	p.connections[host] = conn
+func (p *Pool) Add(key string, conn Connection) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	p.connections[key] = &poolEntry{conn: conn, lastUsed: time.Now()}
 }
 
 // Remove 移除连接
-func (p *Pool) Remove(host string) {
-	delete(p.connections, host)
-}
\ No newline at end of file
+func (p *Pool) Remove(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	delete(p.connections, key)
+}
+
+// Len 获取连接数
+func (p *Pool) Len() int {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	return len(p.connections)
+}
+
+// Keys 获取所有连接的键
+func (p *Pool) Keys() []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	keys := make([]string, 0, len(p.connections))
+	for key := range p.connections {
+		keys = append(keys, key)
+	}
+	return keys
+}
+
+// Acquire 将连接标记为使用中
+func (p *Pool) Acquire(key string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	if entry, ok := p.connections[key]; ok {
+		entry.inUse++
+		entry.lastUsed = time.Now()
+	}
+}
+
+// Release 将连接标记为不再被该任务使用，返回连接是否在池中
+func (p *Pool) Release(conn Connection) bool {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	for _, entry := range p.connections {
+		if entry.conn != conn {
+			continue
+		}
+		if entry.inUse > 0 {
+			entry.inUse--
+		}
+		entry.lastUsed = time.Now()
+		return true
+	}
+	return false
+}
+
+// Idle 获取没有任务使用且空闲时间超过ttl的连接的键
+func (p *Pool) Idle(ttl time.Duration) []string {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var keys []string
+	for key, entry := range p.connections {
+		if entry.inUse == 0 && time.Since(entry.lastUsed) > ttl {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// LeastRecentlyUsed 获取没有任务使用的连接中最久未使用的连接的键
+func (p *Pool) LeastRecentlyUsed() (string, bool) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	var (
+		found  bool
+		oldest string
+		last   time.Time
+	)
+	for key, entry := range p.connections {
+		if entry.inUse > 0 {
+			continue
+		}
+		if !found || entry.lastUsed.Before(last) {
+			found, oldest, last = true, key, entry.lastUsed
+		}
+	}
+	return oldest, found
+}
@@ -0,0 +1,63 @@
+package connection
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"time"
+)
+
+// processEnv 构造本机进程的环境变量，在本进程的环境变量基础上添加env，env为空时返回nil使用本进程的环境变量
+func processEnv(env map[string]string) []string {
+	if len(env) == 0 {
+		return nil
+	}
+	return append(os.Environ(), envList(env)...)
+}
+
+// runProcess 执行本机进程并收集输出，用于在本机通过其他命令（docker、chroot等）执行命令的连接
+func runProcess(cmd *exec.Cmd) *ConnectionResult {
+	startTime := time.Now()
+
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err := cmd.Run()
+
+	result := &ConnectionResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		Duration: time.Since(startTime),
+	}
+	if err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			result.ExitCode = exitErr.ExitCode()
+		} else {
+			result.Error = err
+			result.ExitCode = -1
+		}
+	}
+	return result
+}
+
+// streamProcess 以流方式执行本机进程，返回退出码
+func streamProcess(cmd *exec.Cmd, opts *StreamOptions) (int, error) {
+	cmd.Stdout = opts.Stdout
+	cmd.Stderr = opts.Stderr
+	if opts.Stdin != nil {
+		stdin, err := cmd.StdinPipe()
+		if err != nil {
+			return -1, fmt.Errorf("获取标准输入失败: %w", err)
+		}
+		copyStdin(stdin, opts.Stdin)
+	}
+
+	if err := cmd.Run(); err != nil {
+		if exitErr, ok := err.(*exec.ExitError); ok {
+			return exitErr.ExitCode(), nil
+		}
+		return -1, fmt.Errorf("执行命令失败: %w", err)
+	}
+	return 0, nil
+}
@@ -0,0 +1,250 @@
+package connection
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// SOCKS5协议常量，见RFC 1928和RFC 1929
+const (
+	socks5Version      = 0x05
+	socks5AuthNone     = 0x00
+	socks5AuthPassword = 0x02
+	socks5NoAcceptable = 0xff
+	socks5CmdConnect   = 0x01
+	socks5AddrIPv4     = 0x01
+	socks5AddrDomain   = 0x03
+	socks5AddrIPv6     = 0x04
+)
+
+// socks5Errors SOCKS5服务器的应答码
+var socks5Errors = map[byte]string{
+	0x01: "代理服务器内部错误",
+	0x02: "代理规则不允许该连接",
+	0x03: "网络不可达",
+	0x04: "主机不可达",
+	0x05: "连接被拒绝",
+	0x06: "TTL超时",
+	0x07: "不支持的命令",
+	0x08: "不支持的地址类型",
+}
+
+// proxyDialer 获取经过代理建立连接的函数，握手超过timeout时中止
+func proxyDialer(proxy *url.URL, timeout time.Duration) dialFunc {
+	return func(network, addr string) (net.Conn, error) {
+		conn, err := net.DialTimeout(network, proxy.Host, timeout)
+		if err != nil {
+			return nil, fmt.Errorf("连接代理 %s 失败: %w", proxy.Host, err)
+		}
+		if timeout > 0 {
+			conn.SetDeadline(time.Now().Add(timeout))
+		}
+
+		if proxy.Scheme == "http" {
+			conn, err = httpConnect(conn, proxy, addr)
+		} else {
+			err = socks5Connect(conn, proxy, addr)
+		}
+		if err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("通过代理 %s 连接 %s 失败: %w", proxy.Host, addr, err)
+		}
+
+		conn.SetDeadline(time.Time{})
+		return conn, nil
+	}
+}
+
+// socks5Connect 在到SOCKS5代理的连接上完成认证并请求连接addr
+// socks5由本机解析主机名，socks5h将主机名交给代理服务器解析
+func socks5Connect(conn net.Conn, proxy *url.URL, addr string) error {
+	host, portStr, err := net.SplitHostPort(addr)
+	if err != nil {
+		return err
+	}
+	port, err := strconv.Atoi(portStr)
+	if err != nil {
+		return fmt.Errorf("端口格式不正确: %s", portStr)
+	}
+
+	// 协商认证方式
+	methods := []byte{socks5AuthNone}
+	if proxy.User != nil {
+		methods = append(methods, socks5AuthPassword)
+	}
+	if _, err := conn.Write(append([]byte{socks5Version, byte(len(methods))}, methods...)); err != nil {
+		return err
+	}
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取认证方式失败: %w", err)
+	}
+	if reply[0] != socks5Version {
+		return fmt.Errorf("代理服务器不是SOCKS5服务器")
+	}
+
+	switch reply[1] {
+	case socks5AuthNone:
+	case socks5AuthPassword:
+		if proxy.User == nil {
+			return fmt.Errorf("代理服务器要求认证，但未提供用户名和密码")
+		}
+		if err := socks5Authenticate(conn, proxy.User); err != nil {
+			return err
+		}
+	case socks5NoAcceptable:
+		return fmt.Errorf("代理服务器不接受提供的认证方式")
+	default:
+		return fmt.Errorf("代理服务器选择了不支持的认证方式: %d", reply[1])
+	}
+
+	// 请求连接目标地址
+	req := []byte{socks5Version, socks5CmdConnect, 0x00}
+	ip := net.ParseIP(host)
+	if ip == nil && proxy.Scheme == "socks5" {
+		addrs, err := net.LookupIP(host)
+		if err != nil {
+			return fmt.Errorf("解析主机 %s 失败: %w", host, err)
+		}
+		ip = addrs[0]
+	}
+	switch {
+	case ip == nil:
+		if len(host) > 255 {
+			return fmt.Errorf("主机名过长: %s", host)
+		}
+		req = append(req, socks5AddrDomain, byte(len(host)))
+		req = append(req, host...)
+	case ip.To4() != nil:
+		req = append(req, socks5AddrIPv4)
+		req = append(req, ip.To4()...)
+	default:
+		req = append(req, socks5AddrIPv6)
+		req = append(req, ip.To16()...)
+	}
+	req = binary.BigEndian.AppendUint16(req, uint16(port))
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	// 读取应答，绑定地址的长度由地址类型决定
+	header := make([]byte, 4)
+	if _, err := io.ReadFull(conn, header); err != nil {
+		return fmt.Errorf("读取连接应答失败: %w", err)
+	}
+	if header[1] != 0x00 {
+		if msg, ok := socks5Errors[header[1]]; ok {
+			return errors.New(msg)
+		}
+		return fmt.Errorf("代理服务器返回错误: %d", header[1])
+	}
+
+	var addrLen int
+	switch header[3] {
+	case socks5AddrIPv4:
+		addrLen = net.IPv4len
+	case socks5AddrIPv6:
+		addrLen = net.IPv6len
+	case socks5AddrDomain:
+		n := make([]byte, 1)
+		if _, err := io.ReadFull(conn, n); err != nil {
+			return fmt.Errorf("读取连接应答失败: %w", err)
+		}
+		addrLen = int(n[0])
+	default:
+		return fmt.Errorf("代理服务器返回了不支持的地址类型: %d", header[3])
+	}
+	if _, err := io.ReadFull(conn, make([]byte, addrLen+2)); err != nil {
+		return fmt.Errorf("读取连接应答失败: %w", err)
+	}
+	return nil
+}
+
+// socks5Authenticate 使用用户名和密码向SOCKS5代理认证
+func socks5Authenticate(conn net.Conn, user *url.Userinfo) error {
+	username := user.Username()
+	password, _ := user.Password()
+	if len(username) > 255 || len(password) > 255 {
+		return fmt.Errorf("代理用户名或密码过长")
+	}
+
+	req := []byte{0x01, byte(len(username))}
+	req = append(req, username...)
+	req = append(req, byte(len(password)))
+	req = append(req, password...)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+
+	reply := make([]byte, 2)
+	if _, err := io.ReadFull(conn, reply); err != nil {
+		return fmt.Errorf("读取认证结果失败: %w", err)
+	}
+	if reply[1] != 0x00 {
+		return fmt.Errorf("代理认证失败")
+	}
+	return nil
+}
+
+// httpConnect 在到HTTP代理的连接上使用CONNECT请求建立到addr的隧道
+func httpConnect(conn net.Conn, proxy *url.URL, addr string) (net.Conn, error) {
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: make(http.Header),
+	}
+	if proxy.User != nil {
+		password, _ := proxy.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		return conn, err
+	}
+
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		return conn, fmt.Errorf("读取代理应答失败: %w", err)
+	}
+	// 成功的CONNECT应答没有正文，应忽略Content-Length和Transfer-Encoding（RFC 7231 4.3.6），
+	// 关闭正文会把隧道中的数据当作正文读掉，因此只在失败时关闭
+	if resp.StatusCode != http.StatusOK {
+		resp.Body.Close()
+	}
+	switch {
+	case resp.StatusCode == http.StatusProxyAuthRequired && proxy.User == nil:
+		return conn, fmt.Errorf("代理服务器要求认证，但未提供用户名和密码")
+	case resp.StatusCode == http.StatusProxyAuthRequired:
+		return conn, fmt.Errorf("代理认证失败")
+	case resp.StatusCode != http.StatusOK:
+		return conn, fmt.Errorf("代理服务器返回 %s", resp.Status)
+	}
+
+	// SSH服务器会先发送版本信息，可能已被读入缓冲区
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn 先读取缓冲区中已读入的数据的连接
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+// Read 实现io.Reader接口
+func (c *bufferedConn) Read(p []byte) (int, error) {
+	return c.reader.Read(p)
+}
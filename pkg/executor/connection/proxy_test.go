@@ -0,0 +1,281 @@
+package connection
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+// newBannerServer 启动目标服务器，连接建立后先发送banner（与SSH服务器一致），再原样返回收到的数据
+func newBannerServer(t *testing.T) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				io.WriteString(conn, "SSH-2.0-test\r\n")
+				io.Copy(conn, conn)
+			}()
+		}
+	}()
+	return listener.Addr().String()
+}
+
+// socks5Server 进程内的SOCKS5代理，user不为空时要求用户名密码认证
+type socks5Server struct {
+	user, password string
+	requested      chan string // 客户端请求连接的地址
+}
+
+func newSOCKS5Server(t *testing.T, user, password string) (*socks5Server, string) {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	s := &socks5Server{user: user, password: password, requested: make(chan string, 10)}
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			go s.serve(conn)
+		}
+	}()
+	return s, listener.Addr().String()
+}
+
+func (s *socks5Server) serve(conn net.Conn) {
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return
+	}
+	methods := make([]byte, header[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return
+	}
+	want := byte(socks5AuthNone)
+	if s.user != "" {
+		want = socks5AuthPassword
+	}
+	if !strings.ContainsRune(string(methods), rune(want)) {
+		conn.Write([]byte{socks5Version, socks5NoAcceptable})
+		return
+	}
+	conn.Write([]byte{socks5Version, want})
+
+	if want == socks5AuthPassword {
+		readField := func() string {
+			n, _ := r.ReadByte()
+			b := make([]byte, n)
+			io.ReadFull(r, b)
+			return string(b)
+		}
+		r.ReadByte() // 子协商版本
+		user, password := readField(), readField()
+		if user != s.user || password != s.password {
+			conn.Write([]byte{0x01, 0x01})
+			return
+		}
+		conn.Write([]byte{0x01, 0x00})
+	}
+
+	req := make([]byte, 4)
+	if _, err := io.ReadFull(r, req); err != nil {
+		return
+	}
+	var host string
+	switch req[3] {
+	case socks5AddrIPv4:
+		ip := make([]byte, net.IPv4len)
+		io.ReadFull(r, ip)
+		host = net.IP(ip).String()
+	case socks5AddrDomain:
+		n, _ := r.ReadByte()
+		name := make([]byte, n)
+		io.ReadFull(r, name)
+		host = string(name)
+	}
+	portBytes := make([]byte, 2)
+	io.ReadFull(r, portBytes)
+	addr := net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(portBytes))))
+	s.requested <- addr
+
+	target, err := net.Dial("tcp", strings.Replace(addr, "target.internal", "127.0.0.1", 1))
+	if err != nil {
+		conn.Write([]byte{socks5Version, 0x05, 0x00, socks5AddrIPv4, 0, 0, 0, 0, 0, 0})
+		return
+	}
+	defer target.Close()
+	conn.Write([]byte{socks5Version, 0x00, 0x00, socks5AddrIPv4, 127, 0, 0, 1, 0, 0})
+	go io.Copy(target, r)
+	io.Copy(conn, target)
+}
+
+// newHTTPProxy 启动进程内的HTTP CONNECT代理，auth不为空时要求该Proxy-Authorization
+// net/http的200应答带有Transfer-Encoding: chunked，客户端必须忽略，否则会读掉隧道中的banner
+func newHTTPProxy(t *testing.T, auth string) string {
+	t.Helper()
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodConnect {
+			http.Error(w, "CONNECT only", http.StatusMethodNotAllowed)
+			return
+		}
+		if auth != "" && r.Header.Get("Proxy-Authorization") != auth {
+			w.Header().Set("Proxy-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusProxyAuthRequired)
+			return
+		}
+		target, err := net.Dial("tcp", r.Host)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadGateway)
+			return
+		}
+		defer target.Close()
+		w.WriteHeader(http.StatusOK)
+		conn, buf, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		buf.Flush()
+		go io.Copy(target, buf)
+		io.Copy(conn, target)
+	})}
+	go server.Serve(listener)
+	t.Cleanup(func() { server.Close() })
+	return listener.Addr().String()
+}
+
+// checkTunnel 确认经过代理的连接先收到目标服务器的banner，之后可以双向传输
+func checkTunnel(t *testing.T, conn net.Conn) {
+	t.Helper()
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	if banner, err := r.ReadString('\n'); err != nil || banner != "SSH-2.0-test\r\n" {
+		t.Fatalf("banner = %q, %v", banner, err)
+	}
+	io.WriteString(conn, "ping\n")
+	if echo, err := r.ReadString('\n'); err != nil || echo != "ping\n" {
+		t.Fatalf("echo = %q, %v", echo, err)
+	}
+}
+
+func mustParseURL(t *testing.T, raw string) *url.URL {
+	t.Helper()
+	u, err := url.Parse(raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return u
+}
+
+func TestSOCKS5ProxyWithoutAuth(t *testing.T) {
+	target := newBannerServer(t)
+	server, addr := newSOCKS5Server(t, "", "")
+
+	conn, err := proxyDialer(mustParseURL(t, "socks5://"+addr), 5*time.Second)("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTunnel(t, conn)
+	if got := <-server.requested; got != target {
+		t.Fatalf("requested %s, want %s", got, target)
+	}
+}
+
+func TestSOCKS5hProxySendsHostname(t *testing.T) {
+	target := newBannerServer(t)
+	_, port, _ := net.SplitHostPort(target)
+	server, addr := newSOCKS5Server(t, "", "")
+
+	conn, err := proxyDialer(mustParseURL(t, "socks5h://"+addr), 5*time.Second)("tcp", "target.internal:"+port)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTunnel(t, conn)
+	if got := <-server.requested; got != "target.internal:"+port {
+		t.Fatalf("requested %s, want hostname resolved by the proxy", got)
+	}
+}
+
+func TestSOCKS5ProxyWithPassword(t *testing.T) {
+	target := newBannerServer(t)
+	_, addr := newSOCKS5Server(t, "alice", "s3cret")
+
+	conn, err := proxyDialer(mustParseURL(t, "socks5://alice:s3cret@"+addr), 5*time.Second)("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTunnel(t, conn)
+
+	_, err = proxyDialer(mustParseURL(t, "socks5://alice:wrong@"+addr), 5*time.Second)("tcp", target)
+	if err == nil || !strings.Contains(err.Error(), "代理认证失败") {
+		t.Fatalf("wrong password: err = %v", err)
+	}
+
+	_, err = proxyDialer(mustParseURL(t, "socks5://"+addr), 5*time.Second)("tcp", target)
+	if err == nil || !strings.Contains(err.Error(), "不接受") {
+		t.Fatalf("missing credentials: err = %v", err)
+	}
+}
+
+func TestHTTPConnectProxy(t *testing.T) {
+	target := newBannerServer(t)
+	addr := newHTTPProxy(t, "")
+
+	conn, err := proxyDialer(mustParseURL(t, "http://"+addr), 5*time.Second)("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTunnel(t, conn)
+}
+
+func TestHTTPConnectProxyAuth(t *testing.T) {
+	target := newBannerServer(t)
+	// base64("bob:pw")
+	addr := newHTTPProxy(t, "Basic Ym9iOnB3")
+
+	conn, err := proxyDialer(mustParseURL(t, "http://bob:pw@"+addr), 5*time.Second)("tcp", target)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkTunnel(t, conn)
+
+	_, err = proxyDialer(mustParseURL(t, "http://bob:wrong@"+addr), 5*time.Second)("tcp", target)
+	if err == nil || !strings.Contains(err.Error(), "代理认证失败") {
+		t.Fatalf("wrong password: err = %v", err)
+	}
+
+	_, err = proxyDialer(mustParseURL(t, "http://"+addr), 5*time.Second)("tcp", target)
+	if err == nil || !strings.Contains(err.Error(), "未提供用户名和密码") {
+		t.Fatalf("missing credentials: err = %v", err)
+	}
+}
@@ -1,24 +1,48 @@
 package connection
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
-	"io/ioutil"
 	"sync"
 	"time"
 
 	"github.com/ape902/ansible-go/pkg/config/types"
 	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
 )
 
 // SSHConnection 定义SSH连接结构
 type SSHConnection struct {
-	Client   *ssh.Client
-	Host     string
-	Port     int
-	User     string
-	Password string
-	LastUsed time.Time
-	IsInUse  bool
+	Client        *ssh.Client
+	Host          string
+	Port          int
+	User          string
+	Password      string
+	KeyFile       string
+	CertFile      string           // 与KeyFile配对的证书文件
+	KeyPassword   string
+	ForwardAgent  bool             // 是否将本地ssh-agent转发到远程主机
+	IdentityFiles []string         // 额外的私钥文件，在KeyFile之后尝试
+	Config        *types.SSHConfig // 全局SSH配置，决定认证顺序、默认私钥和超时时间
+	Dial          dialFunc         // 建立底层连接的函数，为nil时直接连接，经过跳板机时由连接管理器设置
+	LastUsed      time.Time
+	IsInUse       bool
+	MaxSessions   int // 每个客户端上同时打开的最大会话数，为0时使用10，与OpenSSH服务器MaxSessions的默认值一致
+	MaxClients    int // 会话数达到上限时最多建立的客户端数，为0时使用1，超出后等待会话释放
+
+	mutex       sync.Mutex
+	idle        *sync.Cond   // 会话释放或客户端断开时通知等待的任务
+	clients     []*sshClient // 到目标主机的客户端，Client为其中第一个
+	dialing     int          // 正在建立的客户端数
+	clientLimit int          // 服务器拒绝建立更多客户端后的客户端数上限，为0时使用MaxClients
+}
+
+// sshClient 到目标主机的一个SSH客户端及其会话使用情况
+type sshClient struct {
+	client   *ssh.Client
+	sessions int  // 正在使用的会话数
+	dead     bool // 客户端已断开
 }
 
 // NewSSHConnection 创建新的SSH连接
@@ -83,55 +107,19 @@ func (p *SSHConnectionPool) GetConnection(host string, port int, user string, pa
 		}
 	}
 
-	// 创建新连接
-	var authMethods []ssh.AuthMethod
-
-	// 自动选择认证方式或根据UseKeyAuth字段选择认证方式
-	// 1. 如果UseKeyAuth为true，优先使用密钥认证
-	// 2. 如果密码为空且密钥文件不为空，使用密钥认证
-	// 3. 如果密码不为空且UseKeyAuth为false，使用密码认证
-	useKeyAuth := p.sshConfig.UseKeyAuth
-	keyPath := keyFile
-	if keyPath == "" {
-		keyPath = p.sshConfig.KeyFile
-	}
-	
-	// 如果密码为空且密钥文件不为空，自动使用密钥认证
-	if password == "" && keyPath != "" {
-		useKeyAuth = true
-	}
-	
-	if useKeyAuth && keyPath != "" {
-		// 使用密钥认证
-		key, err := loadPrivateKey(keyPath, p.sshConfig.KeyPassword)
-		if err != nil {
-			return nil, fmt.Errorf("加载SSH私钥失败: %w", err)
-		}
-		authMethods = append(authMethods, ssh.PublicKeys(key))
-	} else if password != "" {
-		// 使用密码认证
-		authMethods = append(authMethods, ssh.Password(password))
-	}
-
-	// 如果没有认证方法，返回错误
-	if len(authMethods) == 0 {
-		return nil, fmt.Errorf("未提供SSH认证方法")
-	}
-
-	// 创建SSH配置
-	config := &ssh.ClientConfig{
-		User:            user,
-		Auth:            authMethods,
-		Timeout:         p.timeout,
-		HostKeyCallback: getHostKeyCallback(p.sshConfig),
+	// 使用统一的SSH拨号逻辑建立连接
+	creds := sshCredentials{
+		User:        user,
+		Password:    password,
+		KeyFile:     keyFile,
+		KeyPassword: p.sshConfig.KeyPassword,
 	}
 
-	// 尝试连接
 	var client *ssh.Client
 	var err error
 
 	for i := 0; i <= p.maxRetries; i++ {
-		client, err = ssh.Dial("tcp", fmt.Sprintf("%s:%d", host, port), config)
+		client, err = dialSSH(nil, host, port, creds, p.sshConfig)
 		if err == nil {
 			break
 		}
@@ -142,7 +130,7 @@ func (p *SSHConnectionPool) GetConnection(host string, port int, user string, pa
 	}
 
 	if err != nil {
-		return nil, fmt.Errorf("SSH连接失败: %w", err)
+		return nil, err
 	}
 
 	// 创建新的连接对象
@@ -152,6 +140,8 @@ func (p *SSHConnectionPool) GetConnection(host string, port int, user string, pa
 		Port:     port,
 		User:     user,
 		Password: password,
+		KeyFile:  keyFile,
+		Config:   p.sshConfig,
 		LastUsed: time.Now(),
 		IsInUse:  true,
 	}
@@ -213,237 +203,320 @@ func (p *SSHConnectionPool) CleanIdleConnections() {
 	}
 }
 
-// Connect 实现Connection接口的Connect方法
-func (conn *SSHConnection) Connect() error {
-	// 从连接实例获取认证信息
-	config := &ssh.ClientConfig{
-		User:            conn.User,
-		Auth:            []ssh.AuthMethod{ssh.Password(conn.Password)}, // 使用连接实例中的密码
-		HostKeyCallback: ssh.InsecureIgnoreHostKey(), // 始终禁用主机密钥检查，避免首次连接时的交互确认
-		Timeout:         time.Second * 10,
-	}
+// 未配置时使用的会话和客户端数量上限
+const (
+	defaultMaxSessions = 10
+	defaultMaxClients  = 1
+)
 
-	// 建立连接
-	client, err := ssh.Dial("tcp", fmt.Sprintf("%s:%d", conn.Host, conn.Port), config)
+// Connect 实现Connection接口的Connect方法，建立到目标主机的客户端
+// 会话数超过上限时，后续的客户端在需要时由newSession建立
+func (conn *SSHConnection) Connect() error {
+	client, err := conn.dial()
 	if err != nil {
-		return fmt.Errorf("SSH连接失败: %w", err)
+		return err
 	}
 
-	conn.Client = client
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	conn.addClientLocked(client)
 	return nil
 }
 
-// Disconnect 实现Connection接口的Disconnect方法
-func (conn *SSHConnection) Disconnect() error {
-	if conn.Client != nil {
-		return conn.Client.Close()
-	}
-	return nil
+// dial 建立一个新的SSH客户端
+func (conn *SSHConnection) dial() (*ssh.Client, error) {
+	return dialSSH(conn.Dial, conn.Host, conn.Port, sshCredentials{
+		User:          conn.User,
+		Password:      conn.Password,
+		KeyFile:       conn.KeyFile,
+		CertFile:      conn.CertFile,
+		KeyPassword:   conn.KeyPassword,
+		IdentityFiles: conn.IdentityFiles,
+		ForwardAgent:  conn.ForwardAgent,
+	}, conn.Config)
 }
 
-// IsConnected 实现Connection接口的IsConnected方法
-func (conn *SSHConnection) IsConnected() bool {
-	return conn.Client != nil
+// addClientLocked 在持有锁时添加客户端，并开始监测客户端是否断开
+func (conn *SSHConnection) addClientLocked(client *ssh.Client) *sshClient {
+	c := &sshClient{client: client}
+	conn.clients = append(conn.clients, c)
+	if conn.Client == nil || len(conn.clients) == 1 {
+		conn.Client = client
+	}
+
+	go conn.watch(c)
+	go keepAlive(client, conn.Config)
+	return c
 }
 
-// GetType 实现Connection接口的GetType方法
-func (conn *SSHConnection) GetType() ConnectionType {
-	return ConnectionTypeSSH
+// watch 在客户端断开后（包括keepalive超时主动关闭）将其标记为已断开
+func (conn *SSHConnection) watch(c *sshClient) {
+	c.client.Wait()
+
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	c.dead = true
+	conn.cond().Broadcast()
 }
 
-// ExecuteCommand 实现Connection接口的ExecuteCommand方法
-func (conn *SSHConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
-	// 创建会话
-	session, err := conn.Client.NewSession()
-	if err != nil {
-		return nil, fmt.Errorf("创建SSH会话失败: %w", err)
+// cond 获取等待会话释放的条件变量，需要持有锁
+func (conn *SSHConnection) cond() *sync.Cond {
+	if conn.idle == nil {
+		conn.idle = sync.NewCond(&conn.mutex)
 	}
-	defer session.Close()
+	return conn.idle
+}
 
-	// 获取标准输出和标准错误
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		return nil, fmt.Errorf("获取标准输出失败: %w", err)
+// Disconnect 实现Connection接口的Disconnect方法，关闭所有客户端
+func (conn *SSHConnection) Disconnect() error {
+	conn.mutex.Lock()
+	clients := conn.clients
+	conn.clients = nil
+	conn.clientLimit = 0
+	conn.mutex.Unlock()
+
+	var firstErr error
+	for _, c := range clients {
+		if err := c.client.Close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
 	}
+	return firstErr
+}
 
-	stderr, err := session.StderrPipe()
-	if err != nil {
-		return nil, fmt.Errorf("获取标准错误失败: %w", err)
+// IsConnected 实现Connection接口的IsConnected方法，所有客户端都已断开时返回false
+func (conn *SSHConnection) IsConnected() bool {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	for _, c := range conn.clients {
+		if !c.dead {
+			return true
+		}
 	}
+	return false
+}
 
-	// 执行命令
-	err = session.Start(command)
-	if err != nil {
-		return nil, fmt.Errorf("启动命令失败: %w", err)
-	}
+// acquire 获取一个可以打开新会话的客户端并占用一个会话配额
+// 所有客户端的会话数都达到上限时，在允许的范围内建立新的客户端，否则等待其他会话释放
+// 所有客户端都已断开时重新连接，命令尚未开始执行，重连后可以安全地执行
+func (conn *SSHConnection) acquire() (*sshClient, error) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
 
-	// 读取输出
-	stdoutBytes := make([]byte, 0)
-	buf := make([]byte, 1024)
-	for {
-		n, err := stdout.Read(buf)
-		if n > 0 {
-			stdoutBytes = append(stdoutBytes, buf[:n]...)
-		}
-		if err != nil {
-			break
-		}
+	maxSessions := conn.MaxSessions
+	if maxSessions <= 0 {
+		maxSessions = defaultMaxSessions
 	}
 
-	stderrBytes := make([]byte, 0)
-	buf = make([]byte, 1024)
 	for {
-		n, err := stderr.Read(buf)
-		if n > 0 {
-			stderrBytes = append(stderrBytes, buf[:n]...)
+		conn.removeDeadLocked()
+		for _, c := range conn.clients {
+			if c.sessions < maxSessions {
+				c.sessions++
+				return c, nil
+			}
 		}
-		if err != nil {
-			break
-		}
-	}
 
-	// 等待命令完成
-	start := time.Now()
-	err = session.Wait()
-	duration := time.Since(start)
-	exitCode := 0
-	if err != nil {
-		if exitErr, ok := err.(*ssh.ExitError); ok {
-			exitCode = exitErr.ExitStatus()
-		} else {
-			return nil, fmt.Errorf("等待命令完成失败: %w", err)
+		if len(conn.clients)+conn.dialing < conn.maxClientsLocked() {
+			conn.dialing++
+			conn.mutex.Unlock()
+			client, err := conn.dial()
+			conn.mutex.Lock()
+			conn.dialing--
+
+			if err != nil {
+				if len(conn.clients) == 0 {
+					return nil, fmt.Errorf("连接已断开，重新连接失败: %w", err)
+				}
+				// 服务器拒绝更多连接，只使用已有的客户端
+				conn.clientLimit = len(conn.clients)
+				continue
+			}
+			c := conn.addClientLocked(client)
+			c.sessions++
+			return c, nil
 		}
-	}
 
-	return &ConnectionResult{
-		Stdout:   string(stdoutBytes),
-		Stderr:   string(stderrBytes),
-		ExitCode: exitCode,
-		Duration: duration,
-	}, nil
+		conn.cond().Wait()
+	}
 }
 
-// 加载私钥
-func loadPrivateKey(keyPath, keyPassword string) (ssh.Signer, error) {
-	key, err := readPrivateKeyFile(keyPath)
-	if err != nil {
-		return nil, err
+// maxClientsLocked 获取允许建立的客户端数
+func (conn *SSHConnection) maxClientsLocked() int {
+	if conn.clientLimit > 0 {
+		return conn.clientLimit
+	}
+	if conn.MaxClients > 0 {
+		return conn.MaxClients
 	}
+	return defaultMaxClients
+}
 
-	var signer ssh.Signer
-	if keyPassword != "" {
-		signer, err = ssh.ParsePrivateKeyWithPassphrase(key, []byte(keyPassword))
-	} else {
-		signer, err = ssh.ParsePrivateKey(key)
+// removeDeadLocked 移除已断开的客户端
+func (conn *SSHConnection) removeDeadLocked() {
+	live := conn.clients[:0]
+	for _, c := range conn.clients {
+		if c.dead {
+			c.client.Close()
+			continue
+		}
+		live = append(live, c)
+	}
+	for i := len(live); i < len(conn.clients); i++ {
+		conn.clients[i] = nil
 	}
+	conn.clients = live
 
-	if err != nil {
-		return nil, err
+	if len(live) == 0 {
+		// 所有客户端都已断开，重新连接后按配置重新尝试建立多个客户端
+		conn.clientLimit = 0
+	} else {
+		conn.Client = live[0].client
 	}
+}
 
-	return signer, nil
+// release 释放会话配额，唤醒等待的任务
+func (conn *SSHConnection) release(c *sshClient) {
+	conn.mutex.Lock()
+	defer conn.mutex.Unlock()
+	c.sessions--
+	conn.cond().Broadcast()
 }
 
-// 读取私钥文件
-func readPrivateKeyFile(keyPath string) ([]byte, error) {
-	return ioutil.ReadFile(keyPath)
+// markDead 将客户端标记为已断开并关闭
+func (conn *SSHConnection) markDead(c *sshClient) {
+	conn.mutex.Lock()
+	c.dead = true
+	conn.mutex.Unlock()
+	c.client.Close()
 }
 
-// getHostKeyCallback 获取主机密钥验证回调函数
-func getHostKeyCallback(config *types.SSHConfig) ssh.HostKeyCallback {
-	// 如果配置了禁用主机密钥检查，直接返回InsecureIgnoreHostKey
-	if config != nil && config.DisableHostKeyChecking {
-		return ssh.InsecureIgnoreHostKey()
-	}
+// newSession 创建会话，返回关闭会话并释放会话配额的函数，该函数可以重复调用
+// 服务器拒绝打开通道时直接返回错误，其他错误说明客户端已失效，换用其他客户端或重连后重试一次
+func (conn *SSHConnection) newSession() (*ssh.Session, func(), error) {
+	for attempt := 0; ; attempt++ {
+		c, err := conn.acquire()
+		if err != nil {
+			return nil, nil, err
+		}
 
-	// 默认使用InsecureIgnoreHostKey，以避免首次连接时的交互确认
-	// 在生产环境中，应该实现更安全的主机密钥验证机制
-	return ssh.InsecureIgnoreHostKey()
-}
+		session, err := c.client.NewSession()
+		if err == nil {
+			var once sync.Once
+			return session, func() {
+				once.Do(func() {
+					session.Close()
+					conn.release(c)
+				})
+			}, nil
+		}
+		conn.release(c)
 
-// CopyFile 实现Connection接口的CopyFile方法
-func (conn *SSHConnection) CopyFile(localPath, remotePath string) error {
-	// 读取本地文件
-	content, err := ioutil.ReadFile(localPath)
-	if err != nil {
-		return fmt.Errorf("读取本地文件失败: %w", err)
+		var openErr *ssh.OpenChannelError
+		if errors.As(err, &openErr) || attempt > 0 {
+			return nil, nil, fmt.Errorf("创建SSH会话失败: %w", err)
+		}
+		conn.markDead(c)
 	}
+}
 
-	// 创建远程会话
-	session, err := conn.Client.NewSession()
-	if err != nil {
-		return fmt.Errorf("创建SSH会话失败: %w", err)
-	}
-	defer session.Close()
+// GetType 实现Connection接口的GetType方法
+func (conn *SSHConnection) GetType() ConnectionType {
+	return ConnectionTypeSSH
+}
 
-	// 创建远程文件
-	cmd := fmt.Sprintf("cat > %s", remotePath)
-	stdin, err := session.StdinPipe()
+// ExecuteCommand 实现Connection接口的ExecuteCommand方法
+func (conn *SSHConnection) ExecuteCommand(command string) (*ConnectionResult, error) {
+	// 创建会话
+	session, done, err := conn.newSession()
 	if err != nil {
-		return fmt.Errorf("获取标准输入失败: %w", err)
+		return nil, err
 	}
+	defer done()
 
-	// 启动命令
-	err = session.Start(cmd)
-	if err != nil {
-		return fmt.Errorf("启动命令失败: %w", err)
+	// 请求在远程主机上设置SSH_AUTH_SOCK
+	if conn.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return nil, fmt.Errorf("请求agent转发失败: %w", err)
+		}
 	}
 
-	// 写入文件内容
-	_, err = stdin.Write(content)
-	if err != nil {
-		return fmt.Errorf("写入文件内容失败: %w", err)
-	}
-	stdin.Close()
+	// 标准输出和标准错误由会话并发读取，避免命令写满其中一个管道时阻塞
+	var stdout, stderr bytes.Buffer
+	session.Stdout = &stdout
+	session.Stderr = &stderr
 
-	// 等待命令完成
-	err = session.Wait()
+	// 执行命令
+	start := time.Now()
+	err = session.Run(command)
+	duration := time.Since(start)
+	exitCode := 0
 	if err != nil {
-		return fmt.Errorf("等待命令完成失败: %w", err)
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			exitCode = exitErr.ExitStatus()
+		} else {
+			return nil, fmt.Errorf("执行命令失败: %w", err)
+		}
 	}
 
-	return nil
+	return &ConnectionResult{
+		Stdout:   stdout.String(),
+		Stderr:   stderr.String(),
+		ExitCode: exitCode,
+		Duration: duration,
+	}, nil
 }
 
-// FetchFile 实现Connection接口的FetchFile方法
-func (conn *SSHConnection) FetchFile(remotePath, localPath string) error {
-	// 创建远程会话
-	session, err := conn.Client.NewSession()
+// ExecuteStream 实现StreamExecutor接口，以流方式执行命令
+func (conn *SSHConnection) ExecuteStream(command string, opts *StreamOptions) (int, error) {
+	session, done, err := conn.newSession()
 	if err != nil {
-		return fmt.Errorf("创建SSH会话失败: %w", err)
+		return -1, err
 	}
-	defer session.Close()
+	defer done()
 
-	// 获取标准输出
-	stdout, err := session.StdoutPipe()
-	if err != nil {
-		return fmt.Errorf("获取标准输出失败: %w", err)
+	if conn.ForwardAgent {
+		if err := agent.RequestAgentForwarding(session); err != nil {
+			return -1, fmt.Errorf("请求agent转发失败: %w", err)
+		}
 	}
 
-	// 启动命令
-	cmd := fmt.Sprintf("cat %s", remotePath)
-	err = session.Start(cmd)
-	if err != nil {
-		return fmt.Errorf("启动命令失败: %w", err)
+	if opts.Pty {
+		// 关闭回显，避免写入的输入出现在输出中
+		modes := ssh.TerminalModes{ssh.ECHO: 0}
+		if err := session.RequestPty("xterm", 40, 200, modes); err != nil {
+			return -1, fmt.Errorf("请求伪终端失败: %w", err)
+		}
 	}
 
-	// 读取文件内容
-	content, err := ioutil.ReadAll(stdout)
-	if err != nil {
-		return fmt.Errorf("读取文件内容失败: %w", err)
-	}
+	command = setSessionEnv(session, opts.Env, command)
 
-	// 等待命令完成
-	err = session.Wait()
-	if err != nil {
-		return fmt.Errorf("等待命令完成失败: %w", err)
+	session.Stdout = opts.Stdout
+	session.Stderr = opts.Stderr
+	if opts.Stdin != nil {
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return -1, fmt.Errorf("获取标准输入失败: %w", err)
+		}
+		copyStdin(stdin, opts.Stdin)
 	}
 
-	// 写入本地文件
-	err = ioutil.WriteFile(localPath, content, 0644)
-	if err != nil {
-		return fmt.Errorf("写入本地文件失败: %w", err)
+	if err := session.Run(command); err != nil {
+		if exitErr, ok := err.(*ssh.ExitError); ok {
+			return exitErr.ExitStatus(), nil
+		}
+		return -1, fmt.Errorf("执行命令失败: %w", err)
 	}
+	return 0, nil
+}
 
-	return nil
+// setSessionEnv 通过session.Setenv设置环境变量，返回要执行的命令
+// 服务端通常只接受AcceptEnv中列出的变量，有变量被拒绝时在命令前添加env前缀设置全部变量
+func setSessionEnv(session *ssh.Session, env map[string]string, command string) string {
+	for _, name := range envNames(env) {
+		if err := session.Setenv(name, env[name]); err != nil {
+			return envCommand(env, command)
+		}
+	}
+	return command
 }
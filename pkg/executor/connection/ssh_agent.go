@@ -0,0 +1,65 @@
+package connection
+
+import (
+	"fmt"
+	"net"
+	"os"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"golang.org/x/crypto/ssh"
+	"golang.org/x/crypto/ssh/agent"
+)
+
+// agentSocket 获取ssh-agent的套接字路径，未启用或未运行ssh-agent时返回空
+func agentSocket(cfg *types.SSHConfig) string {
+	if cfg.DisableAgent {
+		return ""
+	}
+	return os.Getenv("SSH_AUTH_SOCK")
+}
+
+// sshAgent 定义到本地ssh-agent的连接
+type sshAgent struct {
+	conn   net.Conn
+	client agent.ExtendedAgent
+}
+
+// dialAgent 连接本地ssh-agent，未启用或未运行ssh-agent时返回nil
+func dialAgent(cfg *types.SSHConfig) (*sshAgent, error) {
+	socket := agentSocket(cfg)
+	if socket == "" {
+		return nil, nil
+	}
+
+	conn, err := net.Dial("unix", socket)
+	if err != nil {
+		return nil, fmt.Errorf("连接ssh-agent失败: %w", err)
+	}
+	return &sshAgent{conn: conn, client: agent.NewClient(conn)}, nil
+}
+
+// Signers 获取ssh-agent中的所有密钥
+func (a *sshAgent) Signers() ([]ssh.Signer, error) {
+	if a == nil {
+		return nil, nil
+	}
+	return a.client.Signers()
+}
+
+// Close 关闭到ssh-agent的连接
+func (a *sshAgent) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.conn.Close()
+}
+
+// forwardAgent 将远程主机上的agent转发请求转发到本地ssh-agent
+// 每个会话还需要调用agent.RequestAgentForwarding才会在远程主机上设置SSH_AUTH_SOCK
+func forwardAgent(client *ssh.Client, cfg *types.SSHConfig) error {
+	socket := agentSocket(cfg)
+	if socket == "" {
+		return fmt.Errorf("未找到ssh-agent，请检查SSH_AUTH_SOCK环境变量")
+	}
+	return agent.ForwardToRemote(client, socket)
+}
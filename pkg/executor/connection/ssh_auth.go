@@ -0,0 +1,331 @@
+package connection
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"golang.org/x/crypto/ssh"
+)
+
+// defaultSSHTimeout 未配置超时时间时的默认连接超时
+const defaultSSHTimeout = 10 * time.Second
+
+// defaultIdentityFiles 未配置任何私钥时尝试的默认私钥，与OpenSSH一致
+var defaultIdentityFiles = []string{
+	"~/.ssh/id_rsa",
+	"~/.ssh/id_ecdsa",
+	"~/.ssh/id_ed25519",
+}
+
+// sshCredentials 定义建立SSH连接使用的认证信息
+type sshCredentials struct {
+	User          string   // 用户名
+	Password      string   // 密码
+	KeyFile       string   // 主机指定的私钥文件，优先于全局配置中的私钥
+	CertFile      string   // 与KeyFile配对的证书文件
+	KeyPassword   string   // 私钥密码
+	IdentityFiles []string // 主机额外的私钥文件，例如ssh_config中的IdentityFile
+	ForwardAgent  bool     // 是否将本地ssh-agent转发到远程主机
+}
+
+// dialSSH 使用统一的认证逻辑建立SSH连接
+// dial为nil时直接连接，否则通过dial建立底层连接（例如经过跳板机）
+func dialSSH(dial dialFunc, host string, port int, creds sshCredentials, cfg *types.SSHConfig) (*ssh.Client, error) {
+	if cfg == nil {
+		cfg = &types.SSHConfig{}
+	}
+
+	// ssh-agent只在认证期间使用，agent转发时按需重新连接
+	ag, agentErr := dialAgent(cfg)
+	defer ag.Close()
+	agentSigners, err := ag.Signers()
+	if err != nil {
+		agentErr = fmt.Errorf("读取ssh-agent密钥失败: %w", err)
+	}
+
+	clientConfig, err := newSSHClientConfig(creds, cfg, agentSigners)
+	if err != nil {
+		if agentErr != nil {
+			return nil, fmt.Errorf("%w (%v)", err, agentErr)
+		}
+		return nil, err
+	}
+
+	client, err := dialClient(dial, net.JoinHostPort(host, fmt.Sprint(port)), clientConfig)
+	if err != nil {
+		return nil, fmt.Errorf("SSH连接失败: %w", err)
+	}
+
+	if creds.ForwardAgent {
+		if err := forwardAgent(client, cfg); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("启用agent转发失败: %w", err)
+		}
+	}
+	return client, nil
+}
+
+// dialClient 建立底层连接并完成SSH握手，握手超过超时时间时中止
+func dialClient(dial dialFunc, addr string, config *ssh.ClientConfig) (*ssh.Client, error) {
+	if dial == nil {
+		return ssh.Dial("tcp", addr, config)
+	}
+
+	conn, err := dial("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+	if config.Timeout > 0 {
+		conn.SetDeadline(time.Now().Add(config.Timeout))
+	}
+	c, chans, reqs, err := ssh.NewClientConn(conn, addr, config)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	conn.SetDeadline(time.Time{})
+	return ssh.NewClient(c, chans, reqs), nil
+}
+
+// newSSHClientConfig 根据认证信息和全局SSH配置创建客户端配置
+func newSSHClientConfig(creds sshCredentials, cfg *types.SSHConfig, agentSigners []ssh.Signer) (*ssh.ClientConfig, error) {
+	authMethods, err := sshAuthMethods(creds, cfg, agentSigners)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ssh.ClientConfig{
+		User:            creds.User,
+		Auth:            authMethods,
+		Timeout:         sshTimeout(cfg),
+		HostKeyCallback: getHostKeyCallback(cfg),
+	}, nil
+}
+
+// sshTimeout 获取连接超时时间，未配置时使用默认值
+func sshTimeout(cfg *types.SSHConfig) time.Duration {
+	if cfg.Timeout > 0 {
+		return time.Duration(cfg.Timeout) * time.Second
+	}
+	return defaultSSHTimeout
+}
+
+// sshAuthMethods 构建认证方式列表，SSH客户端按顺序尝试
+//  1. UseKeyAuth为true时优先使用私钥认证，密码和键盘交互认证作为回退
+//  2. UseKeyAuth为false时优先使用密码和键盘交互认证，私钥认证作为回退
+//  3. 未配置密码时始终使用私钥认证
+//
+// 显式配置了私钥时先尝试配置的私钥再尝试ssh-agent中的密钥，避免agent中的密钥过多时
+// 服务器在尝试到配置的私钥之前因MaxAuthTries断开连接；未配置私钥时ssh-agent中的密钥先于默认私钥尝试。
+// SSH客户端对同一种认证方式只尝试一次，因此所有密钥合并为一个publickey认证方式
+func sshAuthMethods(creds sshCredentials, cfg *types.SSHConfig, agentSigners []ssh.Signer) ([]ssh.AuthMethod, error) {
+	fileSigners, keyErr := loadIdentities(identityFiles(creds, cfg), certFiles(creds, cfg), creds.KeyPassword)
+	var signers []ssh.Signer
+	if hasExplicitIdentity(creds, cfg) {
+		signers = append(append(signers, fileSigners...), agentSigners...)
+	} else {
+		signers = append(append(signers, agentSigners...), fileSigners...)
+	}
+
+	var keyMethods, passwordMethods []ssh.AuthMethod
+	if len(signers) > 0 {
+		keyMethods = append(keyMethods, ssh.PublicKeys(signers...))
+	}
+	if creds.Password != "" {
+		passwordMethods = append(passwordMethods,
+			ssh.Password(creds.Password),
+			ssh.KeyboardInteractive(passwordChallenge(creds.Password)),
+		)
+	}
+
+	var methods []ssh.AuthMethod
+	if cfg.UseKeyAuth {
+		methods = append(keyMethods, passwordMethods...)
+	} else {
+		methods = append(passwordMethods, keyMethods...)
+	}
+
+	if len(methods) == 0 {
+		if keyErr != nil {
+			return nil, fmt.Errorf("未提供SSH认证方法: %w", keyErr)
+		}
+		return nil, fmt.Errorf("未提供SSH认证方法")
+	}
+	return methods, nil
+}
+
+// identityFiles 获取按顺序尝试的私钥文件列表
+// 依次为主机指定的私钥、主机额外的私钥、全局配置的私钥和identity_files，均未配置时使用默认私钥
+func identityFiles(creds sshCredentials, cfg *types.SSHConfig) []string {
+	files := make([]string, 0, len(cfg.IdentityFiles)+2)
+	seen := make(map[string]bool)
+	add := func(path string) {
+		if path != "" && !seen[path] {
+			seen[path] = true
+			files = append(files, path)
+		}
+	}
+
+	add(creds.KeyFile)
+	for _, path := range creds.IdentityFiles {
+		add(path)
+	}
+	add(cfg.KeyFile)
+	for _, path := range cfg.IdentityFiles {
+		add(path)
+	}
+
+	// 未配置私钥时，只有在使用密钥认证或没有密码时才尝试默认私钥
+	if len(files) == 0 && (cfg.UseKeyAuth || creds.Password == "") {
+		for _, path := range defaultIdentityFiles {
+			add(path)
+		}
+	}
+	return files
+}
+
+// hasExplicitIdentity 判断主机或全局配置中是否显式指定了私钥
+func hasExplicitIdentity(creds sshCredentials, cfg *types.SSHConfig) bool {
+	return creds.KeyFile != "" || len(creds.IdentityFiles) > 0 || cfg.KeyFile != "" || len(cfg.IdentityFiles) > 0
+}
+
+// certFiles 获取显式配置的私钥与证书文件的对应关系，主机的证书与主机的私钥配对，全局的证书与全局的私钥配对
+func certFiles(creds sshCredentials, cfg *types.SSHConfig) map[string]string {
+	certs := make(map[string]string)
+	if creds.KeyFile != "" && creds.CertFile != "" {
+		certs[creds.KeyFile] = creds.CertFile
+	}
+	if _, ok := certs[cfg.KeyFile]; !ok && cfg.KeyFile != "" && cfg.CertFile != "" {
+		certs[cfg.KeyFile] = cfg.CertFile
+	}
+	return certs
+}
+
+// loadIdentities 按顺序加载私钥，跳过无法加载的私钥
+// 私钥有对应的证书时先尝试证书再尝试私钥本身，与OpenSSH一致，未显式配置证书时使用私钥文件名加-cert.pub
+// 只有所有私钥都无法加载时才返回错误
+func loadIdentities(paths []string, certs map[string]string, keyPassword string) ([]ssh.Signer, error) {
+	signers := make([]ssh.Signer, 0, len(paths))
+	var errs []string
+	for _, path := range paths {
+		signer, err := loadPrivateKey(path, keyPassword)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", path, err))
+			continue
+		}
+
+		certSigner, err := loadCertificate(path, certs[path], signer)
+		if err != nil {
+			errs = append(errs, fmt.Sprintf("%s: %v", certs[path], err))
+		} else if certSigner != nil {
+			signers = append(signers, certSigner)
+		}
+		signers = append(signers, signer)
+	}
+
+	if len(signers) == 0 && len(errs) > 0 {
+		return nil, fmt.Errorf("加载SSH私钥失败: %s", strings.Join(errs, "; "))
+	}
+	return signers, nil
+}
+
+// loadPrivateKey 加载私钥，私钥已加密时使用keyPassword解密
+func loadPrivateKey(keyPath, keyPassword string) (ssh.Signer, error) {
+	key, err := readPrivateKeyFile(keyPath)
+	if err != nil {
+		return nil, err
+	}
+
+	signer, err := ssh.ParsePrivateKey(key)
+	if err == nil {
+		return signer, nil
+	}
+
+	var missing *ssh.PassphraseMissingError
+	if !errors.As(err, &missing) {
+		return nil, err
+	}
+	if keyPassword == "" {
+		return nil, fmt.Errorf("私钥已加密，但未提供私钥密码")
+	}
+	return ssh.ParsePrivateKeyWithPassphrase(key, []byte(keyPassword))
+}
+
+// loadCertificate 加载私钥对应的OpenSSH用户证书，返回使用证书认证的签名器
+// certPath为空时尝试私钥文件名加-cert.pub，该文件不存在时返回nil
+func loadCertificate(keyPath, certPath string, signer ssh.Signer) (ssh.Signer, error) {
+	explicit := certPath != ""
+	if !explicit {
+		certPath = keyPath + "-cert.pub"
+	}
+
+	path, err := expandPath(certPath)
+	if err != nil {
+		return nil, err
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		if !explicit && os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+
+	key, _, _, _, err := ssh.ParseAuthorizedKey(data)
+	if err != nil {
+		return nil, fmt.Errorf("解析证书失败: %w", err)
+	}
+	cert, ok := key.(*ssh.Certificate)
+	if !ok {
+		return nil, fmt.Errorf("不是OpenSSH证书")
+	}
+	if cert.CertType != ssh.UserCert {
+		return nil, fmt.Errorf("不是用户证书")
+	}
+
+	certSigner, err := ssh.NewCertSigner(cert, signer)
+	if err != nil {
+		return nil, fmt.Errorf("证书与私钥不匹配: %w", err)
+	}
+	return certSigner, nil
+}
+
+// readPrivateKeyFile 读取私钥文件，路径支持~展开
+func readPrivateKeyFile(keyPath string) ([]byte, error) {
+	path, err := expandPath(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return ioutil.ReadFile(path)
+}
+
+// expandPath 将路径开头的~展开为当前用户的主目录
+func expandPath(path string) (string, error) {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path, nil
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("获取用户主目录失败: %w", err)
+	}
+	return filepath.Join(home, strings.TrimPrefix(path, "~")), nil
+}
+
+// passwordChallenge 使用密码回答键盘交互认证中的所有问题
+func passwordChallenge(password string) ssh.KeyboardInteractiveChallenge {
+	return func(user, instruction string, questions []string, echos []bool) ([]string, error) {
+		answers := make([]string, len(questions))
+		for i := range questions {
+			answers[i] = password
+		}
+		return answers, nil
+	}
+}
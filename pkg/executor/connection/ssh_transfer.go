@@ -0,0 +1,329 @@
+package connection
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/pkg/sftp"
+)
+
+// CopyFile 实现Connection接口的CopyFile方法，保留本地文件权限
+func (conn *SSHConnection) CopyFile(localPath, remotePath string) error {
+	return conn.CopyFileWithOptions(localPath, remotePath, nil)
+}
+
+// FetchFile 实现Connection接口的FetchFile方法，保留远程文件权限
+func (conn *SSHConnection) FetchFile(remotePath, localPath string) error {
+	return conn.FetchFileWithOptions(remotePath, localPath, nil)
+}
+
+// CopyFileWithOptions 以流式方式复制文件到远程主机
+// 优先使用SFTP，远程主机未启用SFTP子系统时使用SCP
+// 文件先写入同目录下的临时文件，完成后重命名为目标文件，避免目标文件处于写入一半的状态
+func (conn *SSHConnection) CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error {
+	src, err := os.Open(localPath)
+	if err != nil {
+		return fmt.Errorf("打开本地文件失败: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("读取本地文件信息失败: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("不支持复制目录: %s", localPath)
+	}
+	mode := transferMode(opts, info.Mode())
+
+	client, done, err := conn.newSFTPClient()
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		// SFTP子系统不可用，使用SCP
+		return conn.scpUpload(src, info.Size(), mode, remotePath, opts)
+	}
+
+	tmpPath, err := sftpUpload(client, src, info.Size(), mode, remotePath, opts)
+	// 后续命令需要新的会话，先释放当前会话的配额
+	done()
+	if err != nil || tmpPath == "" {
+		return err
+	}
+	// 服务器不支持posix-rename扩展，SFTP的rename不能覆盖已存在的文件，与SCP一样使用mv重命名
+	return conn.renameUploaded(tmpPath, remotePath, mode)
+}
+
+// FetchFileWithOptions 以流式方式从远程主机获取文件
+// 优先使用SFTP，远程主机未启用SFTP子系统时使用SCP，本地文件同样先写入临时文件再重命名
+func (conn *SSHConnection) FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error {
+	client, done, err := conn.newSFTPClient()
+	if err != nil {
+		return err
+	}
+	if client == nil {
+		return conn.scpDownload(remotePath, localPath, opts)
+	}
+	defer done()
+
+	src, err := client.Open(remotePath)
+	if err != nil {
+		return fmt.Errorf("打开远程文件失败: %w", err)
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return fmt.Errorf("读取远程文件信息失败: %w", err)
+	}
+	if info.IsDir() {
+		return fmt.Errorf("不支持获取目录: %s", remotePath)
+	}
+
+	return writeLocalFile(localPath, src, info.Size(), transferMode(opts, info.Mode()), opts)
+}
+
+// errSubsystemRejected 服务器拒绝子系统请求时x/crypto/ssh返回的错误信息
+const errSubsystemRejected = "ssh: subsystem request failed"
+
+// newSFTPClient 创建SFTP客户端，返回关闭客户端并释放会话配额的函数
+// 只有服务器拒绝sftp子系统请求时才返回nil客户端，由调用方改用SCP，其他错误直接返回
+func (conn *SSHConnection) newSFTPClient() (*sftp.Client, func(), error) {
+	session, done, err := conn.newSession()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	client, err := func() (*sftp.Client, error) {
+		stdin, err := session.StdinPipe()
+		if err != nil {
+			return nil, fmt.Errorf("获取标准输入失败: %w", err)
+		}
+		stdout, err := session.StdoutPipe()
+		if err != nil {
+			return nil, fmt.Errorf("获取标准输出失败: %w", err)
+		}
+		if err := session.RequestSubsystem("sftp"); err != nil {
+			return nil, err
+		}
+		return sftp.NewClientPipe(stdout, stdin)
+	}()
+	if err != nil {
+		done()
+		if err.Error() == errSubsystemRejected {
+			return nil, nil, nil
+		}
+		return nil, nil, fmt.Errorf("创建SFTP客户端失败: %w", err)
+	}
+	return client, func() {
+		client.Close()
+		done()
+	}, nil
+}
+
+// sftpUpload 通过SFTP上传文件
+// 临时文件创建后先设置权限再写入内容，避免文件内容在写入期间以服务器默认权限暴露。
+// 服务器支持posix-rename扩展时直接覆盖目标文件，否则返回已写入的临时文件路径，由调用方重命名；失败时删除临时文件
+func sftpUpload(client *sftp.Client, src io.Reader, size int64, mode os.FileMode, remotePath string, opts *TransferOptions) (tmp string, err error) {
+	tmpPath := remoteTempPath(remotePath)
+
+	dst, err := client.OpenFile(tmpPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL)
+	if err != nil {
+		return "", fmt.Errorf("创建远程临时文件失败: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			dst.Close()
+			client.Remove(tmpPath)
+		}
+	}()
+
+	if err = dst.Chmod(mode); err != nil {
+		return "", fmt.Errorf("设置远程文件权限失败: %w", err)
+	}
+	if _, err = io.Copy(newProgressWriter(dst, size, opts), src); err != nil {
+		return "", fmt.Errorf("写入远程文件失败: %w", err)
+	}
+	if err = dst.Close(); err != nil {
+		return "", fmt.Errorf("写入远程文件失败: %w", err)
+	}
+
+	if _, ok := client.HasExtension("posix-rename@openssh.com"); !ok {
+		return tmpPath, nil
+	}
+	if err = client.PosixRename(tmpPath, remotePath); err != nil {
+		return "", fmt.Errorf("重命名远程文件失败: %w", err)
+	}
+	return "", nil
+}
+
+// renameUploaded 设置临时文件的权限后重命名为目标文件，失败时删除临时文件
+func (conn *SSHConnection) renameUploaded(tmpPath, remotePath string, mode os.FileMode) error {
+	tmp := ShellQuote(tmpPath)
+	result, err := conn.ExecuteCommand(fmt.Sprintf("chmod %04o %s && mv -f %s %s || { rm -f %s; exit 1; }",
+		mode, tmp, tmp, ShellQuote(remotePath), tmp))
+	if err != nil {
+		conn.removeRemote(tmpPath)
+		return fmt.Errorf("重命名远程文件失败: %w", err)
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("重命名远程文件失败: %s", strings.TrimSpace(result.Stderr))
+	}
+	return nil
+}
+
+// scpUpload 通过SCP协议上传文件到临时文件，再使用mv重命名
+// scp创建文件时受远程umask影响，重命名前再次设置权限
+func (conn *SSHConnection) scpUpload(src io.Reader, size int64, mode os.FileMode, remotePath string, opts *TransferOptions) error {
+	tmpPath := remoteTempPath(remotePath)
+
+	session, done, err := conn.newSession()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("获取标准输入失败: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("获取标准输出失败: %w", err)
+	}
+	reader := bufio.NewReader(stdout)
+
+	if err := session.Start("scp -t " + ShellQuote(tmpPath)); err != nil {
+		return fmt.Errorf("启动scp失败: %w", err)
+	}
+
+	err = func() error {
+		if err := scpReadAck(reader); err != nil {
+			return err
+		}
+		if _, err := fmt.Fprintf(stdin, "C%04o %d %s\n", mode, size, path.Base(tmpPath)); err != nil {
+			return err
+		}
+		if err := scpReadAck(reader); err != nil {
+			return err
+		}
+		if _, err := io.CopyN(newProgressWriter(stdin, size, opts), src, size); err != nil {
+			return err
+		}
+		if _, err := stdin.Write([]byte{0}); err != nil {
+			return err
+		}
+		return scpReadAck(reader)
+	}()
+	stdin.Close()
+	waitErr := session.Wait()
+	// 后续命令需要新的会话，先释放当前会话的配额
+	done()
+	if err != nil {
+		conn.removeRemote(tmpPath)
+		return fmt.Errorf("scp上传文件失败: %w", err)
+	}
+	if waitErr != nil {
+		conn.removeRemote(tmpPath)
+		return fmt.Errorf("scp上传文件失败: %w", waitErr)
+	}
+
+	return conn.renameUploaded(tmpPath, remotePath, mode)
+}
+
+// scpDownload 通过SCP协议下载文件
+func (conn *SSHConnection) scpDownload(remotePath, localPath string, opts *TransferOptions) error {
+	session, done, err := conn.newSession()
+	if err != nil {
+		return err
+	}
+	defer done()
+
+	stdin, err := session.StdinPipe()
+	if err != nil {
+		return fmt.Errorf("获取标准输入失败: %w", err)
+	}
+	stdout, err := session.StdoutPipe()
+	if err != nil {
+		return fmt.Errorf("获取标准输出失败: %w", err)
+	}
+	reader := bufio.NewReader(stdout)
+
+	if err := session.Start("scp -f " + ShellQuote(remotePath)); err != nil {
+		return fmt.Errorf("启动scp失败: %w", err)
+	}
+	defer stdin.Close()
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fmt.Errorf("scp下载文件失败: %w", err)
+	}
+
+	// 读取文件头: C<mode> <size> <name>
+	header, err := reader.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("scp下载文件失败: %w", err)
+	}
+	if header[0] == 1 || header[0] == 2 {
+		return fmt.Errorf("scp下载文件失败: %s", strings.TrimSpace(header[1:]))
+	}
+	fields := strings.SplitN(strings.TrimSpace(header), " ", 3)
+	if len(fields) != 3 || !strings.HasPrefix(fields[0], "C") {
+		return fmt.Errorf("scp下载文件失败: 无法解析文件头 %q", header)
+	}
+	fileMode, err := strconv.ParseUint(fields[0][1:], 8, 32)
+	if err != nil {
+		return fmt.Errorf("scp下载文件失败: 无法解析文件权限 %q", fields[0])
+	}
+	size, err := strconv.ParseInt(fields[1], 10, 64)
+	if err != nil {
+		return fmt.Errorf("scp下载文件失败: 无法解析文件大小 %q", fields[1])
+	}
+
+	if _, err := stdin.Write([]byte{0}); err != nil {
+		return fmt.Errorf("scp下载文件失败: %w", err)
+	}
+
+	if err := writeLocalFile(localPath, io.LimitReader(reader, size), size, transferMode(opts, os.FileMode(fileMode)), opts); err != nil {
+		return err
+	}
+
+	if err := scpReadAck(reader); err != nil {
+		return fmt.Errorf("scp下载文件失败: %w", err)
+	}
+	stdin.Write([]byte{0})
+	return nil
+}
+
+// scpReadAck 读取SCP协议的确认，0表示成功，1和2后跟错误信息
+func scpReadAck(reader *bufio.Reader) error {
+	b, err := reader.ReadByte()
+	if err != nil {
+		return err
+	}
+	if b == 0 {
+		return nil
+	}
+	msg, _ := reader.ReadString('\n')
+	msg = strings.TrimSpace(msg)
+	if msg == "" {
+		msg = "未知错误"
+	}
+	return errors.New(msg)
+}
+
+// removeRemote 删除远程临时文件，忽略错误
+func (conn *SSHConnection) removeRemote(remotePath string) {
+	conn.ExecuteCommand("rm -f " + ShellQuote(remotePath))
+}
+
+// remoteTempPath 生成与目标文件同目录的临时文件路径
+func remoteTempPath(remotePath string) string {
+	return path.Join(path.Dir(remotePath), "."+path.Base(remotePath)+".ansible-go-tmp-"+randomHex(6))
+}
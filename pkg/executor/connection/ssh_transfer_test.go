@@ -0,0 +1,272 @@
+package connection
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"errors"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/pkg/sftp"
+	"golang.org/x/crypto/ssh"
+)
+
+// testSubsystem 测试服务器处理sftp子系统请求的方式
+type testSubsystem int
+
+const (
+	subsystemServe  testSubsystem = iota // 提供SFTP服务
+	subsystemReject                      // 拒绝子系统请求
+	subsystemHangup                      // 接受请求后立即关闭通道
+)
+
+// testSSHSessions 测试服务器累计打开的会话数
+var testSSHSessions atomic.Int64
+
+// newTestSSHServer 启动进程内的SSH服务器，返回连接该服务器的SSHConnection
+// exec请求在本机通过/bin/sh执行
+func newTestSSHServer(t testing.TB, subsystem testSubsystem) *SSHConnection {
+	t.Helper()
+	return newTestSSHServerWithLatency(t, subsystem, 0)
+}
+
+// latencyConn 写入的数据延迟delay后才发送给对端，模拟服务器应答的网络延迟，不限制吞吐量
+type latencyConn struct {
+	net.Conn
+	delay   time.Duration
+	packets chan latencyPacket
+}
+
+type latencyPacket struct {
+	data []byte
+	due  time.Time
+}
+
+func newLatencyConn(conn net.Conn, delay time.Duration) *latencyConn {
+	c := &latencyConn{Conn: conn, delay: delay, packets: make(chan latencyPacket, 1024)}
+	go func() {
+		for p := range c.packets {
+			time.Sleep(time.Until(p.due))
+			if _, err := conn.Write(p.data); err != nil {
+				return
+			}
+		}
+	}()
+	return c
+}
+
+func (c *latencyConn) Write(p []byte) (int, error) {
+	c.packets <- latencyPacket{data: append([]byte(nil), p...), due: time.Now().Add(c.delay)}
+	return len(p), nil
+}
+
+// newTestSSHServerWithLatency 与newTestSSHServer相同，服务器的每次写入延迟delay
+func newTestSSHServerWithLatency(t testing.TB, subsystem testSubsystem, delay time.Duration) *SSHConnection {
+	t.Helper()
+	_, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := ssh.NewSignerFromKey(priv)
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &ssh.ServerConfig{
+		PasswordCallback: func(c ssh.ConnMetadata, password []byte) (*ssh.Permissions, error) {
+			return nil, nil
+		},
+	}
+	config.AddHostKey(signer)
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { listener.Close() })
+
+	go func() {
+		for {
+			nc, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			if delay > 0 {
+				nc = newLatencyConn(nc, delay)
+			}
+			go serveTestSSH(nc, config, subsystem)
+		}
+	}()
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	portNum, _ := strconv.Atoi(port)
+	conn := &SSHConnection{
+		Host:     host,
+		Port:     portNum,
+		User:     "tester",
+		Password: "secret",
+		Config:   &types.SSHConfig{HostKeyChecking: HostKeyCheckingOff, DisableAgent: true},
+	}
+	if err := conn.Connect(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Disconnect() })
+	return conn
+}
+
+func serveTestSSH(nc net.Conn, config *ssh.ServerConfig, subsystem testSubsystem) {
+	_, chans, reqs, err := ssh.NewServerConn(nc, config)
+	if err != nil {
+		return
+	}
+	go ssh.DiscardRequests(reqs)
+	for newChannel := range chans {
+		channel, requests, err := newChannel.Accept()
+		if err != nil {
+			continue
+		}
+		testSSHSessions.Add(1)
+		go func() {
+			defer channel.Close()
+			for req := range requests {
+				if req.Type == "exec" {
+					req.Reply(true, nil)
+					serveTestExec(channel, req.Payload)
+					return
+				}
+				if req.Type != "subsystem" || subsystem == subsystemReject {
+					req.Reply(false, nil)
+					continue
+				}
+				req.Reply(true, nil)
+				if subsystem == subsystemServe {
+					if server, err := sftp.NewServer(channel); err == nil {
+						server.Serve()
+					}
+				}
+				return
+			}
+		}()
+	}
+}
+
+// serveTestExec 执行exec请求中的命令并返回退出码
+func serveTestExec(channel ssh.Channel, payload []byte) {
+	var req struct{ Command string }
+	if err := ssh.Unmarshal(payload, &req); err != nil {
+		return
+	}
+	cmd := exec.Command("/bin/sh", "-c", req.Command)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = channel, channel, channel.Stderr()
+	status := 0
+	if err := cmd.Run(); err != nil {
+		status = 255
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			status = exitErr.ExitCode()
+		}
+	}
+	channel.SendRequest("exit-status", false, ssh.Marshal(struct{ Status uint32 }{uint32(status)}))
+}
+
+func TestSFTPUploadSetsModeAndContent(t *testing.T) {
+	conn := newTestSSHServer(t, subsystemServe)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte("secret data"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	remote := filepath.Join(dir, "remote")
+	if err := conn.CopyFileWithOptions(local, remote, &TransferOptions{Mode: 0600}); err != nil {
+		t.Fatal(err)
+	}
+	info, err := os.Stat(remote)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Fatalf("remote mode = %o, want 600", info.Mode().Perm())
+	}
+	if data, _ := os.ReadFile(remote); string(data) != "secret data" {
+		t.Fatalf("remote content = %q", data)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".remote.ansible-go-tmp-*")); len(leftovers) > 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestNewSFTPClientFallsBackOnlyWhenRejected(t *testing.T) {
+	client, done, err := newTestSSHServer(t, subsystemReject).newSFTPClient()
+	if client != nil || done != nil || err != nil {
+		t.Fatalf("rejected subsystem: client=%v err=%v, want SCP fallback", client, err)
+	}
+
+	client, _, err = newTestSSHServer(t, subsystemHangup).newSFTPClient()
+	if client != nil || err == nil {
+		t.Fatalf("broken subsystem: client=%v err=%v, want error", client, err)
+	}
+}
+
+func TestSFTPUploadWithoutPosixRename(t *testing.T) {
+	if err := sftp.SetSFTPExtensions("statvfs@openssh.com"); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		sftp.SetSFTPExtensions("hardlink@openssh.com", "posix-rename@openssh.com", "statvfs@openssh.com")
+	})
+
+	conn := newTestSSHServer(t, subsystemServe)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	remote := filepath.Join(dir, "remote")
+	if err := os.WriteFile(remote, []byte("old"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.CopyFileWithOptions(local, remote, &TransferOptions{Mode: 0600}); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(remote); string(data) != "new" {
+		t.Fatalf("remote content = %q, want existing file replaced", data)
+	}
+	if info, _ := os.Stat(remote); info.Mode().Perm() != 0600 {
+		t.Fatalf("remote mode = %o, want 600", info.Mode().Perm())
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".remote.ansible-go-tmp-*")); len(leftovers) > 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
+
+func TestSFTPUploadRenameFailureKeepsTarget(t *testing.T) {
+	conn := newTestSSHServer(t, subsystemServe)
+	dir := t.TempDir()
+	local := filepath.Join(dir, "local")
+	if err := os.WriteFile(local, []byte("new"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	// 目标是非空目录，重命名失败
+	remote := filepath.Join(dir, "remote")
+	if err := os.MkdirAll(filepath.Join(remote, "keep"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := conn.CopyFileWithOptions(local, remote, nil); err == nil {
+		t.Fatal("rename over a non-empty directory succeeded")
+	}
+	if _, err := os.Stat(filepath.Join(remote, "keep")); err != nil {
+		t.Fatalf("target removed after failed rename: %v", err)
+	}
+	if leftovers, _ := filepath.Glob(filepath.Join(dir, ".remote.ansible-go-tmp-*")); len(leftovers) > 0 {
+		t.Fatalf("temporary files left behind: %v", leftovers)
+	}
+}
@@ -0,0 +1,314 @@
+package connection
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// sshConfigMaxIncludeDepth Include指令的最大嵌套层数
+const sshConfigMaxIncludeDepth = 16
+
+// sshConfigBlock 定义ssh_config中的一个Host或Match块
+type sshConfigBlock struct {
+	hostPatterns  []string          // Host块的主机模式
+	matchAll      bool              // Match all
+	matchPatterns []string          // Match host的主机模式
+	unsupported   bool              // 包含不支持的Match条件，始终不匹配
+	options       []sshConfigOption // 块内的配置项，按出现顺序排列
+}
+
+// sshConfigOption 定义ssh_config中的一个配置项
+type sshConfigOption struct {
+	key   string // 小写的关键字
+	value string // 参数
+}
+
+// SSHConfigFile 定义解析后的OpenSSH客户端配置文件
+type SSHConfigFile struct {
+	blocks []*sshConfigBlock
+}
+
+// SSHHostConfig 定义ssh_config中适用于某个主机的配置
+type SSHHostConfig struct {
+	HostName      string   // 实际连接地址
+	User          string   // 用户名
+	Port          int      // 端口号
+	IdentityFiles []string // 私钥文件，按出现顺序排列
+	ProxyJump     string   // 跳板机，OpenSSH ProxyJump格式
+}
+
+// LoadSSHConfigFile 加载OpenSSH客户端配置文件，支持Host、Match host/all和Include
+func LoadSSHConfigFile(file string) (*SSHConfigFile, error) {
+	cfg := &SSHConfigFile{}
+	// 第一个块为全局块，出现在任何Host和Match之前的配置项适用于所有主机
+	global := &sshConfigBlock{matchAll: true}
+	cfg.blocks = append(cfg.blocks, global)
+
+	if err := cfg.parseFile(file, global, 0); err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// parseFile 解析单个配置文件，current为当前所在的块
+func (c *SSHConfigFile) parseFile(file string, current *sshConfigBlock, depth int) error {
+	if depth > sshConfigMaxIncludeDepth {
+		return fmt.Errorf("ssh_config的Include嵌套层数过多: %s", file)
+	}
+
+	filePath, err := expandPath(file)
+	if err != nil {
+		return err
+	}
+	f, err := os.Open(filePath)
+	if err != nil {
+		return fmt.Errorf("打开ssh_config文件失败: %w", err)
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		key, args := splitSSHConfigLine(scanner.Text())
+		if key == "" {
+			continue
+		}
+
+		switch key {
+		case "host":
+			current = &sshConfigBlock{hostPatterns: args}
+			c.blocks = append(c.blocks, current)
+		case "match":
+			current = parseMatchBlock(args)
+			c.blocks = append(c.blocks, current)
+		case "include":
+			for _, pattern := range args {
+				if err := c.include(pattern, current, depth); err != nil {
+					return fmt.Errorf("%s:%d: %w", filePath, lineNo, err)
+				}
+			}
+		default:
+			if len(args) == 0 {
+				return fmt.Errorf("%s:%d: 配置项 %s 缺少参数", filePath, lineNo, key)
+			}
+			current.options = append(current.options, sshConfigOption{key: key, value: strings.Join(args, " ")})
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("读取ssh_config文件失败: %w", err)
+	}
+	return nil
+}
+
+// include 解析Include指令，相对路径相对于~/.ssh目录
+func (c *SSHConfigFile) include(pattern string, current *sshConfigBlock, depth int) error {
+	expanded, err := expandPath(pattern)
+	if err != nil {
+		return err
+	}
+	if !filepath.IsAbs(expanded) {
+		sshDir, err := expandPath("~/.ssh")
+		if err != nil {
+			return err
+		}
+		expanded = filepath.Join(sshDir, expanded)
+	}
+
+	files, err := filepath.Glob(expanded)
+	if err != nil {
+		return fmt.Errorf("Include路径格式不正确: %s", pattern)
+	}
+	for _, file := range files {
+		if err := c.parseFile(file, current, depth+1); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// parseMatchBlock 解析Match指令，只支持all、host和originalhost条件
+func parseMatchBlock(args []string) *sshConfigBlock {
+	block := &sshConfigBlock{}
+	for i := 0; i < len(args); i++ {
+		switch strings.ToLower(args[i]) {
+		case "all":
+			block.matchAll = true
+		case "host", "originalhost":
+			if i+1 < len(args) {
+				i++
+				block.matchPatterns = append(block.matchPatterns, strings.Split(args[i], ",")...)
+			}
+		default:
+			block.unsupported = true
+			return block
+		}
+	}
+	return block
+}
+
+// splitSSHConfigLine 拆分配置行为小写的关键字和参数，支持"key value"和"key=value"格式及双引号
+func splitSSHConfigLine(line string) (string, []string) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", nil
+	}
+
+	end := strings.IndexAny(line, " \t=")
+	if end < 0 {
+		return strings.ToLower(line), nil
+	}
+	key := strings.ToLower(line[:end])
+	rest := strings.TrimLeft(line[end:], " \t")
+	rest = strings.TrimPrefix(rest, "=")
+
+	var args []string
+	var current strings.Builder
+	inQuote, hasToken := false, false
+	for _, r := range rest {
+		switch {
+		case r == '"':
+			inQuote = !inQuote
+			hasToken = true
+		case (r == ' ' || r == '\t') && !inQuote:
+			if hasToken {
+				args = append(args, current.String())
+				current.Reset()
+				hasToken = false
+			}
+		case r == '#' && !inQuote && !hasToken:
+			// 行尾注释
+			return key, args
+		default:
+			current.WriteRune(r)
+			hasToken = true
+		}
+	}
+	if hasToken {
+		args = append(args, current.String())
+	}
+	return key, args
+}
+
+// matches 判断块是否适用于主机
+// originalHost为清单中的主机地址，hostName为经过前面的HostName替换后的地址
+func (b *sshConfigBlock) matches(originalHost, hostName string) bool {
+	switch {
+	case b.unsupported:
+		return false
+	case b.hostPatterns != nil:
+		return matchHostPatterns(b.hostPatterns, originalHost)
+	case b.matchPatterns != nil:
+		return matchHostPatterns(b.matchPatterns, hostName)
+	default:
+		return b.matchAll
+	}
+}
+
+// matchHostPatterns 判断主机是否匹配模式列表，支持*和?通配符以及!否定
+func matchHostPatterns(patterns []string, host string) bool {
+	matched := false
+	for _, pattern := range patterns {
+		negate := strings.HasPrefix(pattern, "!")
+		pattern = strings.TrimPrefix(pattern, "!")
+		ok, err := path.Match(strings.ToLower(pattern), strings.ToLower(host))
+		if err != nil || !ok {
+			continue
+		}
+		if negate {
+			return false
+		}
+		matched = true
+	}
+	return matched
+}
+
+// Lookup 获取适用于主机的配置，与OpenSSH一致，每个配置项使用第一个匹配的值，IdentityFile累加
+func (c *SSHConfigFile) Lookup(host string) *SSHHostConfig {
+	result := &SSHHostConfig{}
+	seen := make(map[string]bool)
+
+	for _, block := range c.blocks {
+		hostName := host
+		if result.HostName != "" {
+			hostName = result.HostName
+		}
+		if !block.matches(host, hostName) {
+			continue
+		}
+
+		for _, opt := range block.options {
+			if opt.key == "identityfile" {
+				result.IdentityFiles = append(result.IdentityFiles, opt.value)
+				continue
+			}
+			if seen[opt.key] {
+				continue
+			}
+			seen[opt.key] = true
+
+			switch opt.key {
+			case "hostname":
+				result.HostName = opt.value
+			case "user":
+				result.User = opt.value
+			case "port":
+				if port, err := strconv.Atoi(opt.value); err == nil {
+					result.Port = port
+				}
+			case "proxyjump":
+				result.ProxyJump = opt.value
+			}
+		}
+	}
+
+	// 展开路径中的%h、%r、%d和%%
+	for i, file := range result.IdentityFiles {
+		result.IdentityFiles[i] = expandSSHConfigTokens(file, host, result)
+	}
+	if result.HostName != "" {
+		result.HostName = expandSSHConfigTokens(result.HostName, host, result)
+	}
+	return result
+}
+
+// expandSSHConfigTokens 展开ssh_config中的%h、%r、%d和%%
+func expandSSHConfigTokens(value, host string, cfg *SSHHostConfig) string {
+	if !strings.Contains(value, "%") {
+		return value
+	}
+
+	hostName := host
+	if cfg.HostName != "" && !strings.Contains(cfg.HostName, "%") {
+		hostName = cfg.HostName
+	}
+	home, _ := os.UserHomeDir()
+
+	var b strings.Builder
+	for i := 0; i < len(value); i++ {
+		if value[i] != '%' || i+1 >= len(value) {
+			b.WriteByte(value[i])
+			continue
+		}
+		i++
+		switch value[i] {
+		case 'h':
+			b.WriteString(hostName)
+		case 'r':
+			b.WriteString(cfg.User)
+		case 'd':
+			b.WriteString(home)
+		case '%':
+			b.WriteByte('%')
+		default:
+			b.WriteByte('%')
+			b.WriteByte(value[i])
+		}
+	}
+	return b.String()
+}
@@ -0,0 +1,162 @@
+package connection
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeSSHConfig 在临时的~/.ssh目录中写入ssh_config及其Include的文件，返回主配置文件路径
+func writeSSHConfig(t *testing.T, files map[string]string) string {
+	t.Helper()
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	sshDir := filepath.Join(home, ".ssh")
+	for name, content := range files {
+		path := filepath.Join(sshDir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return filepath.Join(sshDir, "config")
+}
+
+func TestSSHConfigLookup(t *testing.T) {
+	file := writeSSHConfig(t, map[string]string{
+		"config": `
+User global-default-is-overridden-by-first-match
+Include conf.d/*.conf
+
+Host web-* !web-legacy
+    User deploy
+    IdentityFile ~/.ssh/web_%h
+
+Host db1
+    HostName 10.0.0.5
+    Port 2222
+
+Match host 10.0.0.5
+    User dba
+    ProxyJump bastion
+
+Host bastion
+    HostName bastion.example.com
+    User jump
+
+Match exec "true"
+    User never
+
+Host *
+    User fallback
+    IdentityFile %d/.ssh/id_ed25519
+`,
+		"conf.d/extra.conf": `
+Host included
+    HostName inc.example.com
+    Port=2200
+`,
+	})
+	cfg, err := LoadSSHConfigFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	home := os.Getenv("HOME")
+
+	tests := []struct {
+		host string
+		want SSHHostConfig
+	}{
+		{
+			// 全局块在所有Host之前，第一个匹配的User生效
+			host: "web-1",
+			want: SSHHostConfig{
+				User:          "global-default-is-overridden-by-first-match",
+				IdentityFiles: []string{"~/.ssh/web_web-1", home + "/.ssh/id_ed25519"},
+			},
+		},
+		{
+			// !否定排除了web-*块
+			host: "web-legacy",
+			want: SSHHostConfig{
+				User:          "global-default-is-overridden-by-first-match",
+				IdentityFiles: []string{home + "/.ssh/id_ed25519"},
+			},
+		},
+		{
+			// Match host使用前面HostName替换后的地址
+			host: "db1",
+			want: SSHHostConfig{
+				HostName:      "10.0.0.5",
+				Port:          2222,
+				User:          "global-default-is-overridden-by-first-match",
+				ProxyJump:     "bastion",
+				IdentityFiles: []string{home + "/.ssh/id_ed25519"},
+			},
+		},
+		{
+			host: "included",
+			want: SSHHostConfig{
+				HostName:      "inc.example.com",
+				Port:          2200,
+				User:          "global-default-is-overridden-by-first-match",
+				IdentityFiles: []string{home + "/.ssh/id_ed25519"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		if got := cfg.Lookup(tt.host); !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Lookup(%q) = %+v, want %+v", tt.host, *got, tt.want)
+		}
+	}
+}
+
+func TestSSHConfigLookupFirstMatchWins(t *testing.T) {
+	file := writeSSHConfig(t, map[string]string{
+		"config": `
+Host app?.example.com
+    User app
+    HostName %h.internal
+
+Match host *.internal
+    Port 2022
+    User ignored
+
+Match user root
+    Port 1
+
+Host *
+    User fallback
+    Port 22
+`,
+	})
+	cfg, err := LoadSSHConfigFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		host string
+		want SSHHostConfig
+	}{
+		// Match host匹配替换后的地址，User已由Host块设置
+		{"app1.example.com", SSHHostConfig{HostName: "app1.example.com.internal", User: "app", Port: 2022}},
+		{"app10.example.com", SSHHostConfig{User: "fallback", Port: 22}},
+		{"APP2.EXAMPLE.COM", SSHHostConfig{HostName: "APP2.EXAMPLE.COM.internal", User: "app", Port: 2022}},
+	}
+	for _, tt := range tests {
+		if got := cfg.Lookup(tt.host); !reflect.DeepEqual(*got, tt.want) {
+			t.Errorf("Lookup(%q) = %+v, want %+v", tt.host, *got, tt.want)
+		}
+	}
+}
+
+func TestSSHConfigIncludeDepth(t *testing.T) {
+	file := writeSSHConfig(t, map[string]string{"config": "Include config\n"})
+	if _, err := LoadSSHConfigFile(file); err == nil {
+		t.Fatal("recursive Include accepted")
+	}
+}
@@ -0,0 +1,27 @@
+package connection
+
+import "io"
+
+// StreamOptions 定义以流方式执行命令时的输入输出
+type StreamOptions struct {
+	Stdin  io.Reader         // 标准输入，为nil时不提供输入
+	Stdout io.Writer         // 标准输出
+	Stderr io.Writer         // 标准错误，使用伪终端时输出合并到Stdout
+	Pty    bool              // 是否分配伪终端
+	Env    map[string]string // 执行命令时设置的环境变量
+}
+
+// StreamExecutor 定义支持以流方式执行命令的连接，提权等需要交互的命令依赖该接口
+type StreamExecutor interface {
+	// ExecuteStream 执行命令并返回退出码，输出在执行过程中写入opts中的Writer
+	ExecuteStream(command string, opts *StreamOptions) (int, error)
+}
+
+// copyStdin 将输入复制到命令的标准输入，完成后关闭标准输入
+// 复制在单独的goroutine中进行，命令结束时无需等待输入结束
+func copyStdin(stdin io.WriteCloser, input io.Reader) {
+	go func() {
+		io.Copy(stdin, input)
+		stdin.Close()
+	}()
+}
@@ -0,0 +1,140 @@
+package connection
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// progressMinSize 小于该大小的文件不报告传输进度
+const progressMinSize = 1 << 20
+
+// progressInterval 两次进度报告之间的最小间隔
+const progressInterval = time.Second
+
+// ProgressFunc 定义文件传输进度回调，transferred为已传输字节数，total为文件总大小
+type ProgressFunc func(transferred, total int64)
+
+// TransferOptions 定义文件传输选项
+type TransferOptions struct {
+	// Mode 目标文件权限，为0时保留源文件权限
+	Mode os.FileMode
+
+	// Progress 传输进度回调，只对不小于1MB的文件报告，为nil时不报告
+	Progress ProgressFunc
+}
+
+// FileTransferer 定义支持传输选项的连接
+type FileTransferer interface {
+	// CopyFileWithOptions 复制文件到远程主机
+	CopyFileWithOptions(localPath, remotePath string, opts *TransferOptions) error
+
+	// FetchFileWithOptions 从远程主机获取文件
+	FetchFileWithOptions(remotePath, localPath string, opts *TransferOptions) error
+}
+
+// CopyFileWithOptions 使用传输选项复制文件，连接不支持传输选项时使用CopyFile
+func CopyFileWithOptions(conn Connection, localPath, remotePath string, opts *TransferOptions) error {
+	if t, ok := conn.(FileTransferer); ok {
+		return t.CopyFileWithOptions(localPath, remotePath, opts)
+	}
+	return conn.CopyFile(localPath, remotePath)
+}
+
+// FetchFileWithOptions 使用传输选项获取文件，连接不支持传输选项时使用FetchFile
+func FetchFileWithOptions(conn Connection, remotePath, localPath string, opts *TransferOptions) error {
+	if t, ok := conn.(FileTransferer); ok {
+		return t.FetchFileWithOptions(remotePath, localPath, opts)
+	}
+	return conn.FetchFile(remotePath, localPath)
+}
+
+// transferProgressKey 上下文中传输进度回调的键
+type transferProgressKey struct{}
+
+// WithTransferProgress 在上下文中设置传输进度回调，供文件传输模块使用
+func WithTransferProgress(ctx context.Context, progress ProgressFunc) context.Context {
+	return context.WithValue(ctx, transferProgressKey{}, progress)
+}
+
+// TransferProgressFromContext 获取上下文中的传输进度回调，未设置时返回nil
+func TransferProgressFromContext(ctx context.Context) ProgressFunc {
+	progress, _ := ctx.Value(transferProgressKey{}).(ProgressFunc)
+	return progress
+}
+
+// progressWriter 在写入时报告传输进度，限制报告频率
+type progressWriter struct {
+	w           io.Writer
+	total       int64
+	transferred int64
+	progress    ProgressFunc
+	lastReport  time.Time
+}
+
+// newProgressWriter 创建报告进度的Writer，不需要报告进度时直接返回w
+func newProgressWriter(w io.Writer, total int64, opts *TransferOptions) io.Writer {
+	if opts == nil || opts.Progress == nil || total < progressMinSize {
+		return w
+	}
+	return &progressWriter{w: w, total: total, progress: opts.Progress, lastReport: time.Now()}
+}
+
+// Write 实现io.Writer接口
+func (p *progressWriter) Write(b []byte) (int, error) {
+	n, err := p.w.Write(b)
+	p.transferred += int64(n)
+	if p.transferred >= p.total || time.Since(p.lastReport) >= progressInterval {
+		p.lastReport = time.Now()
+		p.progress(p.transferred, p.total)
+	}
+	return n, err
+}
+
+// transferMode 获取目标文件权限
+func transferMode(opts *TransferOptions, sourceMode os.FileMode) os.FileMode {
+	if opts != nil && opts.Mode != 0 {
+		return opts.Mode.Perm()
+	}
+	return sourceMode.Perm()
+}
+
+// writeLocalFile 将内容写入本地临时文件，完成后重命名为目标文件
+func writeLocalFile(localPath string, src io.Reader, size int64, mode os.FileMode, opts *TransferOptions) (err error) {
+	dir := filepath.Dir(localPath)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("创建本地目录失败: %w", err)
+	}
+
+	tmp, err := os.CreateTemp(dir, "."+filepath.Base(localPath)+".ansible-go-tmp-*")
+	if err != nil {
+		return fmt.Errorf("创建本地临时文件失败: %w", err)
+	}
+	defer func() {
+		if err != nil {
+			tmp.Close()
+			os.Remove(tmp.Name())
+		}
+	}()
+
+	written, err := io.Copy(newProgressWriter(tmp, size, opts), src)
+	if err != nil {
+		return fmt.Errorf("写入本地文件失败: %w", err)
+	}
+	if written != size {
+		return fmt.Errorf("写入本地文件失败: 期望%d字节，实际%d字节", size, written)
+	}
+	if err = tmp.Chmod(mode); err != nil {
+		return fmt.Errorf("设置本地文件权限失败: %w", err)
+	}
+	if err = tmp.Close(); err != nil {
+		return fmt.Errorf("写入本地文件失败: %w", err)
+	}
+	if err = os.Rename(tmp.Name(), localPath); err != nil {
+		return fmt.Errorf("重命名本地文件失败: %w", err)
+	}
+	return nil
+}
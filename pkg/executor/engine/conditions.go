@@ -0,0 +1,115 @@
+package engine
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/models"
+)
+
+// applyResultOverrides 根据changed_when和failed_when表达式覆盖任务结果的Changed和Failed标记
+func applyResultOverrides(spec *types.TaskSpec, result *models.TaskResult) error {
+	if spec == nil || result == nil {
+		return nil
+	}
+	if spec.ChangedWhen == "" && spec.FailedWhen == "" {
+		return nil
+	}
+
+	data := resultData(result)
+
+	if spec.ChangedWhen != "" {
+		changed, err := evalCondition(spec.ChangedWhen, data)
+		if err != nil {
+			return fmt.Errorf("计算changed_when失败: %w", err)
+		}
+		result.Changed = changed
+	}
+
+	if spec.FailedWhen != "" {
+		failed, err := evalCondition(spec.FailedWhen, data)
+		if err != nil {
+			return fmt.Errorf("计算failed_when失败: %w", err)
+		}
+		result.Failed = failed
+	}
+
+	return nil
+}
+
+// resultData 构建表达式中可以引用的结果变量
+func resultData(result *models.TaskResult) map[string]interface{} {
+	return map[string]interface{}{
+		"rc":           result.ExitCode,
+		"stdout":       result.Stdout,
+		"stderr":       result.Stderr,
+		"stdout_lines": splitLines(result.Stdout),
+		"stderr_lines": splitLines(result.Stderr),
+		"changed":      result.Changed,
+		"failed":       result.Failed,
+	}
+}
+
+// splitLines 将输出按行拆分，忽略末尾换行
+func splitLines(output string) []string {
+	output = strings.TrimRight(output, "\r\n")
+	if output == "" {
+		return []string{}
+	}
+	return strings.Split(output, "\n")
+}
+
+// evalCondition 计算条件表达式
+// 表达式使用Go模板语法，可以省略外层的{{ }}，例如:
+//
+//	changed_when: "ne .rc 0"
+//	failed_when: "{{ and (ne .rc 0) (not (contains .stderr \"already exists\")) }}"
+func evalCondition(expr string, data map[string]interface{}) (bool, error) {
+	expr = strings.TrimSpace(expr)
+	if b, err := strconv.ParseBool(expr); err == nil {
+		return b, nil
+	}
+	if !strings.Contains(expr, "{{") {
+		expr = "{{ " + expr + " }}"
+	}
+
+	tmpl, err := template.New("condition").Funcs(conditionFuncs).Option("missingkey=error").Parse(expr)
+	if err != nil {
+		return false, fmt.Errorf("解析表达式失败: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return false, fmt.Errorf("执行表达式失败: %w", err)
+	}
+
+	value := strings.TrimSpace(buf.String())
+	b, err := strconv.ParseBool(value)
+	if err != nil {
+		return false, fmt.Errorf("表达式结果必须是布尔值，实际为: %q", value)
+	}
+	return b, nil
+}
+
+// conditionFuncs 条件表达式可用的函数
+var conditionFuncs = template.FuncMap{
+	"contains":  strings.Contains,
+	"hasPrefix": strings.HasPrefix,
+	"hasSuffix": strings.HasSuffix,
+	"match": func(pattern, s string) (bool, error) {
+		return regexp.MatchString(pattern, s)
+	},
+	"in": func(item string, list []string) bool {
+		for _, v := range list {
+			if v == item {
+				return true
+			}
+		}
+		return false
+	},
+}
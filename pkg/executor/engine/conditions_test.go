@@ -0,0 +1,112 @@
+package engine
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/models"
+)
+
+func TestEvalCondition(t *testing.T) {
+	data := resultData(&models.TaskResult{
+		ExitCode: 1,
+		Stdout:   "ok\nalready exists\n",
+		Stderr:   "warning\n",
+	})
+	for _, tt := range []struct {
+		expr string
+		want bool
+	}{
+		{"true", true},
+		{" False ", false},
+		{"eq .rc 1", true},
+		{"{{ ne .rc 0 }}", true},
+		{"{{ and (ne .rc 0) (not (contains .stderr \"warning\")) }}", false},
+		{"eq (len .stdout_lines) 2", true},
+		{"in \"already exists\" .stdout_lines", true},
+		{"eq (len .stderr_lines) 1", true},
+		{"match \"^ok\" .stdout", true},
+		{"hasSuffix .stderr \"\\n\"", true},
+	} {
+		got, err := evalCondition(tt.expr, data)
+		if err != nil {
+			t.Errorf("evalCondition(%q): %v", tt.expr, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("evalCondition(%q) = %v, want %v", tt.expr, got, tt.want)
+		}
+	}
+}
+
+func TestEvalConditionErrors(t *testing.T) {
+	data := resultData(&models.TaskResult{})
+	for _, tt := range []struct {
+		expr string
+		want string
+	}{
+		// 任务变量不在表达式数据中
+		{"eq .app_port 80", "map has no entry for key"},
+		{"{{ eq .rc", "解析表达式失败"},
+		{".stdout", "表达式结果必须是布尔值"},
+	} {
+		_, err := evalCondition(tt.expr, data)
+		if err == nil || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("evalCondition(%q) error = %v, want %q", tt.expr, err, tt.want)
+		}
+	}
+}
+
+func TestApplyResultOverrides(t *testing.T) {
+	for _, tt := range []struct {
+		name        string
+		spec        types.TaskSpec
+		result      models.TaskResult
+		wantChanged bool
+		wantFailed  bool
+	}{
+		{
+			name:        "no overrides",
+			result:      models.TaskResult{Changed: true, Failed: true},
+			wantChanged: true,
+			wantFailed:  true,
+		},
+		{
+			name:   "diff exit code 1 is not a failure",
+			spec:   types.TaskSpec{ChangedWhen: "false", FailedWhen: "gt .rc 1"},
+			result: models.TaskResult{ExitCode: 1, Changed: true, Failed: true},
+		},
+		{
+			name:        "changed from output",
+			spec:        types.TaskSpec{ChangedWhen: "{{ in \"created\" .stdout_lines }}"},
+			result:      models.TaskResult{Stdout: "checked\ncreated\n"},
+			wantChanged: true,
+		},
+		{
+			name:        "failed keeps changed",
+			spec:        types.TaskSpec{FailedWhen: "contains .stderr \"fatal\""},
+			result:      models.TaskResult{Stderr: "fatal: denied", Changed: true},
+			wantChanged: true,
+			wantFailed:  true,
+		},
+	} {
+		result := tt.result
+		if err := applyResultOverrides(&tt.spec, &result); err != nil {
+			t.Errorf("%s: %v", tt.name, err)
+			continue
+		}
+		if result.Changed != tt.wantChanged || result.Failed != tt.wantFailed {
+			t.Errorf("%s: changed = %v, failed = %v, want %v, %v", tt.name, result.Changed, result.Failed, tt.wantChanged, tt.wantFailed)
+		}
+	}
+}
+
+func TestApplyResultOverridesError(t *testing.T) {
+	spec := &types.TaskSpec{FailedWhen: "eq .app_port 80"}
+	result := &models.TaskResult{}
+	err := applyResultOverrides(spec, result)
+	if err == nil || !strings.Contains(err.Error(), "计算failed_when失败") {
+		t.Fatalf("err = %v, want failed_when error", err)
+	}
+}
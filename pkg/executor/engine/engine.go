@@ -59,6 +59,7 @@ type ExecutionEngine struct {
 	varStore        *vars.Store
 	options         ExecutionOptions
 	executorFactory ExecutorFactory
+	hostResolver    HostResolver
 	running         bool
 	mutex           sync.RWMutex
 	workers         int
@@ -67,6 +68,9 @@ type ExecutionEngine struct {
 	wg              sync.WaitGroup
 }
 
+// HostResolver 根据清单中的主机名解析连接参数
+type HostResolver func(host string) *connection.HostParams
+
 // ExecutorFactory 定义执行器工厂接口
 type ExecutorFactory interface {
 	// CreateExecutor 创建执行器
@@ -190,16 +194,36 @@ func (e *ExecutionEngine) ExecuteTask(task *models.Task, taskCtx *models.TaskCon
 	defer cancel()
 
 	// 获取连接
-	conn, err := e.connManager.GetConnection(task.Host, 22, connection.ConnectionTypeSSH) // 默认使用SSH连接，端口22
+	conn, err := e.connManager.GetHostConnection(e.ResolveHost(task.Host))
 	if err != nil {
 		task.Status = models.TaskStatusFailed
 		task.Error = fmt.Errorf("获取连接失败: %w", err)
+		// 无法建立连接视为主机不可达
+		task.Result = &models.TaskResult{
+			Failed:      true,
+			Unreachable: true,
+			Extra:       make(map[string]string),
+		}
 		endTime := time.Now()
 		task.EndTime = &endTime
 		return err
 	}
 	defer e.connManager.ReleaseConnection(conn)
 
+	// 启用审计时记录每条命令，审计连接在最内层，记录的是提权和环境变量处理后实际发送的命令
+	if recorder := connection.AuditFromContext(execCtx); recorder != nil {
+		conn = connection.NewAuditConnection(conn, recorder, task.Host, task.ID)
+	}
+
+	// 需要提权时，执行器的所有命令和文件传输都经过提权连接
+	if task.Become != nil {
+		conn = connection.NewBecomeConnection(conn, task.Become)
+	}
+	// 环境变量在提权之后设置，避免被sudo等重置
+	if len(task.Environment) > 0 {
+		conn = connection.NewEnvConnection(conn, task.Environment)
+	}
+
 	// 获取执行器
 	executor, err := e.executorFactory.CreateExecutor(task.Spec.Module)
 	if err != nil {
@@ -225,6 +249,11 @@ func (e *ExecutionEngine) ExecuteTask(task *models.Task, taskCtx *models.TaskCon
 		}
 	}
 
+	// 根据changed_when和failed_when覆盖结果
+	if err == nil {
+		err = applyResultOverrides(task.Spec, result)
+	}
+
 	// 更新任务状态和结果
 	endTime := time.Now()
 	task.EndTime = &endTime
@@ -284,6 +313,27 @@ func (e *ExecutionEngine) SetVerbose(verbose bool) {
 	e.options.Debug = verbose
 }
 
+// SetHostResolver 设置主机连接参数解析函数
+func (e *ExecutionEngine) SetHostResolver(resolver HostResolver) {
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	e.hostResolver = resolver
+}
+
+// ResolveHost 解析主机的连接参数，未设置解析函数时使用默认SSH参数
+func (e *ExecutionEngine) ResolveHost(host string) *connection.HostParams {
+	e.mutex.RLock()
+	resolver := e.hostResolver
+	e.mutex.RUnlock()
+
+	if resolver != nil {
+		if params := resolver(host); params != nil {
+			return params
+		}
+	}
+	return connection.NewHostParams(host)
+}
+
 // GetConnectionManager 获取连接管理器
 func (e *ExecutionEngine) GetConnectionManager() connection.ConnectionManager {
 	return e.connManager
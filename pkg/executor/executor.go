@@ -3,6 +3,7 @@ package executor
 import (
 	"context"
 	"fmt"
+	"strings"
 	"sync"
 	"time"
 
@@ -23,16 +24,72 @@ type Executor struct {
 	connPool   *connection.Pool
 	engine     *engine.ExecutionEngine
 	logger     *logger.Logger
+	callbacks  []*subscriber
+	cbMutex    sync.Mutex
+
+	ignoreUnreachable bool
+	retryFile         string
+	becomePassword    string
+	vault             *vars.SecurityManager
+	auditDir          string
+	auditMaxOutput    int
+}
+
+// Options 定义执行器选项
+type Options struct {
+	// 日志记录器，为空时创建默认的控制台日志记录器
+	Logger *logger.Logger
+	// 额外注册的运行事件回调
+	Callbacks []Callback
+	// 是否禁用默认的控制台输出回调
+	DisableConsole bool
+	// 执行引擎选项，为空时使用engine.DefaultExecutionOptions
+	Engine *engine.ExecutionOptions
+	// 不可达主机不视为运行失败
+	IgnoreUnreachable bool
+	// 运行结束后写入失败和不可达主机列表的文件路径，为空时不写入
+	RetryFile string
+	// 提权密码，优先于配置文件中的become.password，通常由命令行提示输入
+	BecomePassword string
+	// vault密码，用于解密!ENCRYPTED:开头的提权密码，为空时不解密
+	VaultPassword string
+	// 审计目录，每次运行在该目录下创建一个JSON Lines文件，记录发送到主机的每条命令，为空时不记录
+	AuditDir string
+	// 审计记录中标准输出和标准错误各自保留的最大字节数，0表示使用connection.DefaultAuditMaxOutput
+	AuditMaxOutput int
 }
 
 // NewExecutor 创建新的执行器
 func NewExecutor(cfg *config.Config) *Executor {
+	return NewExecutorWithOptions(cfg, Options{})
+}
+
+// NewExecutorWithOptions 使用指定选项创建新的执行器
+func NewExecutorWithOptions(cfg *config.Config, opts Options) *Executor {
 	connPool := connection.NewPool()
 	executorFactory := executors.NewExecutorFactory()
 	queue := models.NewPriorityTaskQueue()
 	connManager := connection.NewConnectionManager(connPool, &cfg.SSH)
 	localVarStore := vars.NewStore()
-	return &Executor{
+
+	log := opts.Logger
+	if log == nil {
+		log = logger.New()
+	}
+	engineOptions := engine.DefaultExecutionOptions
+	if opts.Engine != nil {
+		engineOptions = *opts.Engine
+	}
+
+	callbacks := make([]*subscriber, 0, len(opts.Callbacks)+1)
+	if !opts.DisableConsole {
+		callbacks = append(callbacks, newSubscriber(NewConsoleCallback(log)))
+	}
+	for _, cb := range opts.Callbacks {
+		callbacks = append(callbacks, newSubscriber(cb))
+	}
+
+	e := &Executor{
 		config:     cfg,
 		varManager: vars.NewManager(),
 		connPool:   connPool,
@@ -41,20 +98,102 @@ func NewExecutor(cfg *config.Config) *Executor {
 			connManager,
 			localVarStore,
 			executorFactory,
-			engine.DefaultExecutionOptions,
+			engineOptions,
 		),
-		logger: logger.New(),
+		logger:            log,
+		callbacks:         callbacks,
+		ignoreUnreachable: opts.IgnoreUnreachable,
+		retryFile:         opts.RetryFile,
+		becomePassword:    opts.BecomePassword,
+		auditDir:          opts.AuditDir,
+		auditMaxOutput:    opts.AuditMaxOutput,
+	}
+	if opts.VaultPassword != "" {
+		e.vault = vars.NewSecurityManager(opts.VaultPassword)
 	}
+
+	// 按清单解析每个主机的连接参数
+	e.engine.SetHostResolver(e.resolveHost)
+
+	return e
+}
+
+// AddCallback 注册运行事件回调
+func (e *Executor) AddCallback(cb Callback) {
+	e.cbMutex.Lock()
+	defer e.cbMutex.Unlock()
+	e.callbacks = append(e.callbacks, newSubscriber(cb))
 }
 
-// Execute 执行playbook
+// emit 将事件加入所有已注册回调的派发队列，不等待回调处理
+func (e *Executor) emit(event *Event) {
+	if event.Time.IsZero() {
+		event.Time = time.Now()
+	}
+
+	e.cbMutex.Lock()
+	callbacks := e.callbacks
+	e.cbMutex.Unlock()
+
+	for _, s := range callbacks {
+		s.send(event)
+	}
+}
+
+// flushCallbacks 等待已发出的事件被所有回调处理完成
+func (e *Executor) flushCallbacks() {
+	e.cbMutex.Lock()
+	callbacks := e.callbacks
+	e.cbMutex.Unlock()
+
+	for _, s := range callbacks {
+		s.flush()
+	}
+}
+
+// withTransferProgress 在任务上下文中设置文件传输进度回调，将进度作为事件通知回调
+func (e *Executor) withTransferProgress(ctx context.Context, host, taskID string) context.Context {
+	return connection.WithTransferProgress(ctx, func(transferred, total int64) {
+		e.emit(&Event{Type: EventTransferProgress, Host: host, Task: taskID, Transferred: transferred, Total: total})
+	})
+}
+
+// withOutput 在任务上下文中设置命令输出回调，将命令执行过程中的每行输出作为事件通知回调
+func (e *Executor) withOutput(ctx context.Context, host, taskID string) context.Context {
+	return connection.WithOutput(ctx, func(stream connection.OutputStream, line string) {
+		e.emit(&Event{Type: EventTaskOutput, Host: host, Task: taskID, Stream: string(stream), Line: line})
+	})
+}
+
+// Execute 执行playbook文件
 func (e *Executor) Execute(playbookPath string) error {
+	_, err := e.RunFile(context.Background(), playbookPath)
+	return err
+}
+
+// RunFile 加载并执行playbook文件，返回每个主机和任务的结构化结果
+func (e *Executor) RunFile(ctx context.Context, playbookPath string) (*RunResult, error) {
 	// 加载playbook
 	taskConfig, err := config.LoadPlaybook(playbookPath)
 	if err != nil {
-		return err
+		return nil, err
+	}
+
+	return e.run(ctx, taskConfig, playbookPath)
+}
+
+// Run 执行在内存中构建的playbook，返回每个主机和任务的结构化结果
+// 取消ctx后不再派发新任务，已在执行的任务会运行至结束
+func (e *Executor) Run(ctx context.Context, playbook *types.TaskConfig) (*RunResult, error) {
+	if err := config.ValidatePlaybook(playbook); err != nil {
+		return nil, err
 	}
 
+	return e.run(ctx, playbook, "")
+}
+
+// run 执行已加载的playbook
+func (e *Executor) run(ctx context.Context, taskConfig *types.TaskConfig, playbookPath string) (*RunResult, error) {
 	// 初始化变量
 	localVarStore := vars.NewStore()
 
@@ -63,12 +202,47 @@ func (e *Executor) Execute(playbookPath string) error {
 		localVarStore.Set(k, v)
 	}
 
+	// 启用审计时记录本次运行发送到主机的每条命令
+	auditFile, recorder, err := e.openAudit(taskConfig)
+	if err != nil {
+		return nil, err
+	}
+	if recorder != nil {
+		defer auditFile.Close()
+		ctx = connection.WithAudit(ctx, recorder)
+		e.logger.Info("审计记录写入文件: %s", auditFile.Name())
+	}
+
+	e.emit(&Event{Type: EventPlaybookStart, Playbook: playbookPath})
+
 	// 执行任务
-	return e.executeTasks(taskConfig, localVarStore, playbookPath)
+	state := newRunState(playbookPath, taskConfig.Name)
+	if auditFile != nil {
+		state.result.AuditFile = auditFile.Name()
+	}
+	err = e.executeTasks(ctx, taskConfig, localVarStore, playbookPath, state)
+	result := state.finish()
+
+	// 返回前保证回调已处理本次运行的全部事件
+	e.flushCallbacks()
+
+	if recorder != nil {
+		if auditErr := recorder.Err(); auditErr != nil {
+			e.logger.Warning("写入审计记录失败: %v", auditErr)
+		}
+	}
+
+	if e.retryFile != "" {
+		if retryErr := writeRetryFile(e.retryFile, result); retryErr != nil {
+			e.logger.Warning("写入重试文件失败: %v", retryErr)
+		}
+	}
+
+	return result, err
 }
 
 // executeTasks 执行任务列表
-func (e *Executor) executeTasks(taskConfig *types.TaskConfig, varStore *vars.Store, playbookPath string) error {
+func (e *Executor) executeTasks(runCtx context.Context, taskConfig *types.TaskConfig, varStore *vars.Store, playbookPath string, state *runState) error {
 	// 检查主机组是否存在
 	hosts := make([]string, 0)
 	e.logger.Info("开始解析主机组，共有 %d 个主机组", len(taskConfig.Hosts))
@@ -88,8 +262,7 @@ func (e *Executor) executeTasks(taskConfig *types.TaskConfig, varStore *vars.Sto
 				e.logger.Info("从主机组 %s 添加 %d 个主机", groupName, len(hostList))
 				
 				for i, hostInfo := range hostList {
-					e.logger.Info("主机 #%d: %s (端口: %d, 连接类型: %s)",
-						i, hostInfo.Host, hostInfo.Port, hostInfo.ConnectionType)
+					e.logHostParams(i, hostInfo.Host)
 					
 					// 检查主机是否已经添加，避免重复
 					duplicateFound := false
@@ -112,12 +285,16 @@ func (e *Executor) executeTasks(taskConfig *types.TaskConfig, varStore *vars.Sto
 			e.logger.IncreaseIndent()
 
 			for i, hostInfo := range hostList {
-				e.logger.Info("主机 #%d: %s (端口: %d, 连接类型: %s)",
-					i, hostInfo.Host, hostInfo.Port, hostInfo.ConnectionType)
+				e.logHostParams(i, hostInfo.Host)
 				hosts = append(hosts, hostInfo.Host)
 			}
 
 			e.logger.DecreaseIndent()
+		} else if connection.IsLocalAddress(hostGroup) {
+			// 未在清单中定义的localhost作为隐式主机，使用本地连接
+			e.logger.Info("使用隐式本地主机 %s", hostGroup)
+			e.logHostParams(0, hostGroup)
+			hosts = append(hosts, hostGroup)
 		} else {
 			e.logger.Warning("警告: 主机组 %s 不存在", hostGroup)
 		}
@@ -131,74 +308,61 @@ func (e *Executor) executeTasks(taskConfig *types.TaskConfig, varStore *vars.Sto
 		return fmt.Errorf("没有找到可用的主机")
 	}
 
-	// 添加SSH连接预检查
+	e.emit(&Event{Type: EventPlayStart, Playbook: playbookPath, Play: taskConfig.Name, Hosts: hosts})
+
+	// 运行结束时通过recap事件汇总各主机的执行统计
+	state.addHosts(hosts)
+	defer func() {
+		e.emit(&Event{Type: EventRecap, Playbook: playbookPath, Play: taskConfig.Name, Stats: state.result.Stats()})
+	}()
+
+	// 添加SSH连接预检查，连接失败的主机标记为不可达，不再参与后续任务
 	e.logger.Info("开始进行SSH连接预检查...")
 	e.logger.IncreaseIndent()
-	
+
 	// 使用WaitGroup等待所有连接检查完成
 	var connWg sync.WaitGroup
 	connErrors := make(map[string]error)
 	var connMutex sync.Mutex
-	
+
 	// 对每个主机进行连接检查
 	for _, host := range hosts {
 		connWg.Add(1)
 		go func(h string) {
 			defer connWg.Done()
-			
-			// 声明连接变量
-			var conn connection.Connection
-			var err error
-			
-			// 尝试获取连接
-			conn, err = e.engine.GetConnectionManager().GetConnection(h, 22, connection.ConnectionTypeSSH)
-			if err != nil {
-				e.logger.Error("主机 %s 连接失败: %v", h, err)
-				connMutex.Lock()
-				connErrors[h] = err
-				connMutex.Unlock()
-				return
-			}
-			
-			// 检查连接是否成功
-			if !conn.IsConnected() {
-				e.logger.Error("主机 %s 连接状态检查失败", h)
-				connMutex.Lock()
-				connErrors[h] = fmt.Errorf("连接状态检查失败")
-				connMutex.Unlock()
-				return
-			}
-			
-			// 执行简单命令验证连接
-			_, err = conn.ExecuteCommand("echo 'Connection test'")
-			if err != nil {
-				e.logger.Error("主机 %s 连接测试失败: %v", h, err)
+
+			if err := e.checkHost(runCtx, h); err != nil {
 				connMutex.Lock()
 				connErrors[h] = err
 				connMutex.Unlock()
 				return
 			}
-			
+
 			e.logger.Success("主机 %s 连接成功", h)
-			
-			// 释放连接
-			e.engine.GetConnectionManager().ReleaseConnection(conn)
 		}(host)
 	}
-	
+
 	// 等待所有连接检查完成
 	connWg.Wait()
-	
-	// 检查是否有连接错误
+
+	// 标记不可达主机
 	if len(connErrors) > 0 {
-		e.logger.Error("SSH连接预检查失败，有 %d 个主机连接失败", len(connErrors))
-		// 不再重复输出每个主机的错误信息，因为在连接检查过程中已经输出过
-		return fmt.Errorf("SSH连接预检查失败，有 %d 个主机连接失败", len(connErrors))
+		for _, h := range hosts {
+			if err, ok := connErrors[h]; ok {
+				e.markUnreachable(state, h, err)
+			}
+		}
+		e.logger.Warning("SSH连接预检查完成，有 %d 个主机不可达，将跳过这些主机", len(connErrors))
+	} else {
+		e.logger.Success("SSH连接预检查完成，所有主机连接成功")
 	}
-	
-	e.logger.Success("SSH连接预检查完成，所有主机连接成功")
 	e.logger.DecreaseIndent()
-	
+
+	hosts = state.reachableHosts(hosts)
+	if len(hosts) == 0 {
+		return e.unreachableError(state)
+	}
+
 	// 创建任务上下文
 	ctx := &models.TaskContext{
 		Hosts:    hosts,
@@ -211,138 +375,273 @@ func (e *Executor) executeTasks(taskConfig *types.TaskConfig, varStore *vars.Sto
 		workerCount = e.config.SSH.MaxParallel
 	}
 
-	// 创建任务通道
-	taskChan := make(chan *models.Task, len(hosts)*len(taskConfig.Tasks))
-	errChan := make(chan error, len(hosts)*len(taskConfig.Tasks))
+	var errs []error
+	var errMutex sync.Mutex
 
-	// 使用互斥锁保护任务通道的关闭操作
-	var taskChanMutex sync.Mutex
-	taskChanClosed := false
+	// 按主机维护有序队列，控制同一主机上的任务串行执行及任务级throttle
+	sched := newScheduler()
 
-	// 用于跟踪任务处理的WaitGroup
-	var taskWg sync.WaitGroup
+	// 记录发生变更的任务触发的处理器
+	notified := newNotifications()
 
-	// 安全地发送任务到通道
-	sendTask := func(task *models.Task) {
-		taskChanMutex.Lock()
-		defer taskChanMutex.Unlock()
-		if !taskChanClosed {
-			taskChan <- task
-		} else {
-			e.logger.Warning("任务通道已关闭，无法发送任务 %s 到主机 %s", task.ID, task.Host)
+	// 按playbook顺序将任务加入各主机的队列
+	for _, taskSpec := range taskConfig.Tasks {
+		for taskName, spec := range taskSpec {
+			for _, host := range hosts {
+				modelsTask := &models.Task{
+					ID:       taskName,
+					Spec:     &spec,
+					Status:   models.TaskStatusPending,
+					Priority: models.TaskPriorityNormal,
+					Host:     host,
+					Vars:     make(map[string]interface{}),
+					FilePath: playbookPath,
+				}
+				sched.add(modelsTask)
+			}
 		}
 	}
 
-	// 启动工作池
+	// 启动工作池，工作协程只会拿到可以立即执行的任务
 	var wg sync.WaitGroup
 	for i := 0; i < workerCount; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for task := range taskChan {
-				// 标记任务开始处理
-				taskWg.Add(1)
-
-				// 创建上下文，包含任务上下文和执行引擎
-				taskExecCtx := context.WithValue(context.Background(), "taskContext", ctx)
-				taskExecCtx = context.WithValue(taskExecCtx, "engine", e.engine)
-
-				// 执行任务
-				err := e.engine.ExecuteTask(task, ctx, taskExecCtx)
+			for {
+				task, ok := sched.next()
+				if !ok {
+					return
+				}
+				err := e.runTask(runCtx, taskConfig, task, ctx, hosts, state, sched, notified)
+				sched.done(task)
 				if err != nil {
-					e.logger.Error("在主机 %s 上执行任务 %s 失败: %v", task.Host, task.ID, err)
-					errChan <- err
-				} else if task.Result != nil {
-					// 输出任务执行结果
-					if task.Result.Stdout != "" {
-						e.logger.Output(task.Host, task.ID, task.Result.Stdout)
-					}
-					if task.Result.Stderr != "" {
-						e.logger.Error("主机 %s 上的任务 %s 的错误输出:", task.Host, task.ID)
-						e.logger.Output(task.Host, task.ID, task.Result.Stderr)
-					}
-
-					// 处理导入的任务
-					if task.Result.ImportedTasks != nil && len(task.Result.ImportedTasks) > 0 {
-						e.logger.Info("处理导入的任务，共 %d 个任务集", len(task.Result.ImportedTasks))
-						for _, importedTaskSpec := range task.Result.ImportedTasks {
-							for taskName, spec := range importedTaskSpec {
-								for _, host := range hosts {
-									importedTask := &models.Task{
-										ID:       taskName,
-										Spec:     &spec,
-										Status:   models.TaskStatusPending,
-										Priority: models.TaskPriorityNormal,
-										Host:     host,
-										Vars:     make(map[string]interface{}),
-										FilePath: task.FilePath,
-									}
-									sendTask(importedTask)
-								}
-							}
-						}
-					}
+					errMutex.Lock()
+					errs = append(errs, err)
+					errMutex.Unlock()
 				}
-
-				// 标记任务处理完成
-				taskWg.Done()
 			}
 		}()
 	}
 
-	// 分发任务
-	for _, taskSpec := range taskConfig.Tasks {
-		for taskName, spec := range taskSpec {
-			for _, host := range hosts {
-				modelsTask := &models.Task{
-					ID:       taskName,
-					Spec:     &spec,
-					Status:   models.TaskStatusPending,
-					Priority: models.TaskPriorityNormal,
-					Host:     host,
-					Vars:     make(map[string]interface{}),
-					FilePath: playbookPath,
+	// 等待所有任务（包括动态导入的任务）执行完成
+	wg.Wait()
+
+	// 运行被取消时跳过处理器
+	if err := runCtx.Err(); err != nil {
+		return fmt.Errorf("运行已取消: %w", err)
+	}
+
+	// 执行被触发的处理器
+	errs = append(errs, e.runHandlers(runCtx, taskConfig, hosts, ctx, state, notified)...)
+
+	if len(errs) > 0 {
+		return fmt.Errorf("执行任务时发生错误: %v", errs)
+	}
+
+	return e.unreachableError(state)
+}
+
+// runTask 在工作协程中执行调度器分派的任务，返回需要计入运行错误的错误
+// 运行已取消或主机已不可达时不执行任务，任务导入的任务追加到各主机队列的末尾
+func (e *Executor) runTask(runCtx context.Context, taskConfig *types.TaskConfig, task *models.Task, ctx *models.TaskContext, hosts []string, state *runState, sched *scheduler, notified *notifications) error {
+	// 运行已取消，不再执行新任务
+	if runCtx.Err() != nil {
+		task.Status = models.TaskStatusCancelled
+		return nil
+	}
+
+	// 主机已不可达，跳过后续任务
+	if state.isUnreachable(task.Host) {
+		task.Status = models.TaskStatusSkipped
+		return nil
+	}
+
+	// 创建上下文，包含任务上下文和执行引擎
+	taskExecCtx := context.WithValue(runCtx, "taskContext", ctx)
+	taskExecCtx = context.WithValue(taskExecCtx, "engine", e.engine)
+	taskExecCtx = e.withTransferProgress(taskExecCtx, task.Host, task.ID)
+	taskExecCtx = e.withOutput(taskExecCtx, task.Host, task.ID)
+	taskExecCtx = connection.WithPipelining(taskExecCtx, e.config.SSH.Pipelining)
+
+	// 执行任务
+	e.emit(&Event{Type: EventTaskStart, Host: task.Host, Task: task.ID, Module: task.Spec.Module})
+	err := e.executeTask(taskConfig, task, ctx, taskExecCtx)
+	status := e.reportResult(state, task, err, "")
+	if status == ResultStatusUnreachable {
+		e.markUnreachable(state, task.Host, err)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	if task.Result == nil {
+		return nil
+	}
+
+	// 记录需要触发的处理器
+	if status == ResultStatusChanged && len(task.Spec.Notify) > 0 {
+		notified.notify(task.Host, task.Spec.Notify)
+	}
+
+	// 处理导入的任务
+	if len(task.Result.ImportedTasks) > 0 {
+		e.logger.Info("处理导入的任务，共 %d 个任务集", len(task.Result.ImportedTasks))
+		for _, importedTaskSpec := range task.Result.ImportedTasks {
+			for taskName, spec := range importedTaskSpec {
+				for _, host := range hosts {
+					sched.add(&models.Task{
+						ID:       taskName,
+						Spec:     &spec,
+						Status:   models.TaskStatusPending,
+						Priority: models.TaskPriorityNormal,
+						Host:     host,
+						Vars:     make(map[string]interface{}),
+						FilePath: task.FilePath,
+					})
 				}
-				sendTask(modelsTask)
 			}
 		}
 	}
+	return nil
+}
 
-	// 等待所有任务添加完成并处理完成
-	// 使用一个额外的goroutine来安全关闭通道
-	go func() {
-		// 等待所有任务处理完成
-		// 这里使用taskWg来跟踪所有任务（包括动态导入的任务）
-		taskWg.Wait()
+// checkHost 检查主机连接是否可用，连接测试失败时按配置重连
+func (e *Executor) checkHost(ctx context.Context, host string) error {
+	connManager := e.engine.GetConnectionManager()
+	params := e.resolveHost(host)
 
-		// 确保所有导入的任务也有机会被处理
-		// 添加一个小延迟，确保所有导入任务都被添加到队列
-		time.Sleep(100 * time.Millisecond)
+	interval := time.Second
+	if e.config.SSH.ReconnectInterval > 0 {
+		interval = time.Duration(e.config.SSH.ReconnectInterval) * time.Second
+	}
 
-		// 安全地关闭任务通道
-		taskChanMutex.Lock()
-		if !taskChanClosed {
-			taskChanClosed = true
-			close(taskChan)
+	var err error
+	for attempt := 0; attempt <= e.config.SSH.ReconnectAttempts; attempt++ {
+		if attempt > 0 {
+			e.logger.Warning("主机 %s 第 %d 次重连...", host, attempt)
+			// 丢弃失效的连接，重新建立
+			connManager.CloseHostConnection(params)
+			time.Sleep(interval)
 		}
-		taskChanMutex.Unlock()
-	}()
 
-	// 等待所有工作协程完成
-	wg.Wait()
-	close(errChan)
+		// 连接管理器在建立连接时已按配置重连，失败即视为不可达
+		var conn connection.Connection
+		conn, err = connManager.GetHostConnection(params)
+		if err != nil {
+			e.logger.Error("主机 %s 连接失败: %v", host, err)
+			return err
+		}
 
-	// 检查是否有错误发生
-	var errs []error
-	for err := range errChan {
-		errs = append(errs, err)
+		// 检查连接是否成功
+		if !conn.IsConnected() {
+			connManager.ReleaseConnection(conn)
+			err = fmt.Errorf("连接状态检查失败")
+			e.logger.Error("主机 %s 连接状态检查失败", host)
+			continue
+		}
+
+		// 执行简单命令验证连接，启用审计时同样记录
+		var testConn connection.Connection = conn
+		if recorder := connection.AuditFromContext(ctx); recorder != nil {
+			testConn = connection.NewAuditConnection(conn, recorder, host, "")
+		}
+		_, err = testConn.ExecuteCommand("echo 'Connection test'")
+		connManager.ReleaseConnection(conn)
+		if err != nil {
+			e.logger.Error("主机 %s 连接测试失败: %v", host, err)
+			continue
+		}
+		return nil
 	}
 
-	if len(errs) > 0 {
-		return fmt.Errorf("执行任务时发生错误: %v", errs)
+	// 不保留失效的连接
+	connManager.CloseHostConnection(params)
+	return err
+}
+
+// logHostParams 输出主机实际使用的连接参数
+func (e *Executor) logHostParams(i int, host string) {
+	params, err := e.engine.GetConnectionManager().ResolveHostParams(e.resolveHost(host))
+	if err != nil {
+		e.logger.Warning("主机 #%d: %s (解析连接参数失败: %v)", i, host, err)
+		return
+	}
+	if params.Type == connection.ConnectionTypeLocal {
+		e.logger.Info("主机 #%d: %s (连接类型: %s)", i, host, params.Type)
+		return
+	}
+	switch params.Type {
+	case connection.ConnectionTypeDocker:
+		e.logger.Info("主机 #%d: %s (容器: %s, 用户: %s, 连接类型: %s)", i, host, params.Addr(), params.User, params.Type)
+		return
+	case connection.ConnectionTypeChroot, connection.ConnectionTypeNspawn:
+		e.logger.Info("主机 #%d: %s (根目录: %s, 用户: %s, 连接类型: %s)", i, host, params.Addr(), params.User, params.Type)
+		return
 	}
+	e.logger.Info("主机 #%d: %s (地址: %s, 端口: %d, 用户: %s, 连接类型: %s)",
+		i, host, params.Addr(), params.Port, params.User, params.Type)
+}
 
-	return nil
+// markUnreachable 将主机标记为不可达并发出事件，重复标记会被忽略
+func (e *Executor) markUnreachable(state *runState, host string, err error) {
+	if !state.markUnreachable(host) {
+		return
+	}
+
+	event := &Event{Type: EventHostUnreachable, Host: host}
+	if err != nil {
+		event.Error = err.Error()
+	}
+	e.emit(event)
+}
+
+// unreachableError 根据不可达主机生成运行错误，设置了IgnoreUnreachable时返回nil
+func (e *Executor) unreachableError(state *runState) error {
+	hosts := state.unreachableHosts()
+	if len(hosts) == 0 || e.ignoreUnreachable {
+		return nil
+	}
+	return fmt.Errorf("有 %d 个主机不可达: %s", len(hosts), strings.Join(hosts, ", "))
+}
+
+// reportResult 计算任务结果状态，记录执行结果并发出结果事件
+func (e *Executor) reportResult(state *runState, task *models.Task, err error, handler string) ResultStatus {
+	status := resultStatus(task, err)
+
+	rec := &TaskRecord{
+		Host:    task.Host,
+		Task:    task.ID,
+		Module:  task.Spec.Module,
+		Handler: handler,
+		Status:  status,
+		Result:  task.Result,
+	}
+	if err != nil {
+		rec.Error = err.Error()
+	} else if task.Error != nil {
+		rec.Error = task.Error.Error()
+	}
+	if task.StartTime != nil {
+		rec.StartTime = *task.StartTime
+	}
+	if task.EndTime != nil {
+		rec.EndTime = *task.EndTime
+	}
+	state.record(rec)
+
+	e.emit(&Event{
+		Type:    EventTaskResult,
+		Host:    rec.Host,
+		Task:    rec.Task,
+		Module:  rec.Module,
+		Handler: rec.Handler,
+		Status:  rec.Status,
+		Result:  rec.Result,
+		Error:   rec.Error,
+	})
+
+	return status
 }
 
 // SetVerboseMode 设置详细输出模式
@@ -35,11 +35,16 @@ func (e *CommandExecutor) Execute(ctx context.Context, task *models.Task, conn c
 	// 替换变量
 	cmdStr = replaceVars(cmdStr, task.Vars, varStore)
 
+	opts, err := commandOptions(ctx, task, varStore)
+	if err != nil {
+		return nil, err
+	}
+
 	// 记录开始时间
 	startTime := time.Now()
 
 	// 执行命令
-	result, err := conn.ExecuteCommand(cmdStr)
+	result, err := connection.ExecuteCommandWithOptions(conn, cmdStr, opts)
 	if err != nil {
 		return nil, fmt.Errorf("执行命令失败: %w", err)
 	}
@@ -64,6 +69,29 @@ func (e *CommandExecutor) Execute(ctx context.Context, task *models.Task, conn c
 	return taskResult, nil
 }
 
+// commandOptions 解析command和shell模块共用的tty和stdin参数
+func commandOptions(ctx context.Context, task *models.Task, varStore *vars.Store) (*connection.CommandOptions, error) {
+	opts := &connection.CommandOptions{Output: connection.OutputFromContext(ctx)}
+
+	if tty, ok := task.Spec.Args["tty"]; ok {
+		ttyBool, ok := tty.(bool)
+		if !ok {
+			return nil, fmt.Errorf("tty参数必须是布尔类型")
+		}
+		opts.Pty = ttyBool
+	}
+
+	if stdin, ok := task.Spec.Args["stdin"]; ok {
+		stdinStr, ok := stdin.(string)
+		if !ok {
+			return nil, fmt.Errorf("stdin参数必须是字符串类型")
+		}
+		opts.Stdin = replaceVars(stdinStr, task.Vars, varStore)
+	}
+
+	return opts, nil
+}
+
 // replaceVars 替换命令中的变量
 func replaceVars(cmd string, taskVars map[string]interface{}, varStore *vars.Store) string {
 	// 先使用任务变量替换
@@ -3,6 +3,8 @@ package executors
 import (
 	"context"
 	"fmt"
+	"os"
+	"strconv"
 	"time"
 
 	"github.com/ape902/ansible-go/pkg/executor/connection"
@@ -49,35 +51,10 @@ func (e *CopyExecutor) Execute(ctx context.Context, task *models.Task, conn conn
 	startTime := time.Now()
 
 	// 复制文件到远程主机
-	err := conn.CopyFile(srcStr, destStr)
-	if err != nil {
+	if err := copyFileToRemote(ctx, task, conn, srcStr, destStr); err != nil {
 		return nil, fmt.Errorf("复制文件失败: %w", err)
 	}
 
-	// 处理权限设置
-	if mode, ok := task.Spec.Args["mode"]; ok {
-		var modeStr string
-		switch v := mode.(type) {
-		case string:
-			modeStr = v
-		case int:
-			modeStr = fmt.Sprintf("%o", v)
-		default:
-			return nil, fmt.Errorf("mode参数必须是字符串或整数类型")
-		}
-		cmdStr := fmt.Sprintf("chmod %s %s", modeStr, destStr)
-
-		// 执行命令
-		result, err := conn.ExecuteCommand(cmdStr)
-		if err != nil {
-			return nil, fmt.Errorf("设置文件权限失败: %w", err)
-		}
-
-		if result.ExitCode != 0 {
-			return nil, fmt.Errorf("设置文件权限失败: %s", result.Stderr)
-		}
-	}
-
 	// 计算执行时间
 	duration := time.Since(startTime)
 
@@ -98,3 +75,56 @@ func (e *CopyExecutor) Execute(ctx context.Context, task *models.Task, conn conn
 
 	return taskResult, nil
 }
+
+// copyFileToRemote 复制文件到远程主机并按mode、owner、group参数设置权限和所有者
+// 八进制权限在传输时直接设置，符号权限或连接不支持传输选项时使用chmod命令；
+// 传输后的命令通过CopyFileWithSteps执行，启用流水线时与上传使用同一个会话
+func copyFileToRemote(ctx context.Context, task *models.Task, conn connection.Connection, localPath, remotePath string) error {
+	opts := &connection.TransferOptions{Progress: connection.TransferProgressFromContext(ctx)}
+
+	var modeStr string
+	if mode, ok := task.Spec.Args["mode"]; ok {
+		switch v := mode.(type) {
+		case string:
+			modeStr = v
+		case int:
+			modeStr = fmt.Sprintf("%o", v)
+		default:
+			return fmt.Errorf("mode参数必须是字符串或整数类型")
+		}
+		if perm, err := strconv.ParseUint(modeStr, 8, 32); err == nil {
+			opts.Mode = os.FileMode(perm).Perm()
+		}
+	}
+	if _, ok := conn.(connection.FileTransferer); ok && opts.Mode != 0 {
+		modeStr = ""
+	}
+	owner, _ := task.Spec.Args["owner"].(string)
+	group, _ := task.Spec.Args["group"].(string)
+
+	steps := func(path string) []connection.PipelineStep {
+		var steps []connection.PipelineStep
+		if modeStr != "" {
+			steps = append(steps, connection.PipelineStep{Name: "chmod", Command: fmt.Sprintf("chmod %s %s", connection.ShellQuote(modeStr), connection.ShellQuote(path))})
+		}
+		if owner != "" {
+			steps = append(steps, connection.PipelineStep{Name: "chown", Command: fmt.Sprintf("chown %s %s", connection.ShellQuote(owner), connection.ShellQuote(path))})
+		}
+		if group != "" {
+			steps = append(steps, connection.PipelineStep{Name: "chgrp", Command: fmt.Sprintf("chgrp %s %s", connection.ShellQuote(group), connection.ShellQuote(path))})
+		}
+		return steps
+	}
+
+	result, err := connection.CopyFileWithSteps(ctx, conn, localPath, remotePath, opts, steps)
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		if result.FailedStep != "" {
+			return fmt.Errorf("%s失败: %s", result.FailedStep, result.Stderr)
+		}
+		return fmt.Errorf("设置文件权限失败: %s", result.Stderr)
+	}
+	return nil
+}
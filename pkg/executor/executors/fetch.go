@@ -59,7 +59,8 @@ func (e *FetchExecutor) Execute(ctx context.Context, task *models.Task, conn con
 	}
 
 	// 从远程主机获取文件
-	err := conn.FetchFile(srcStr, flatDest)
+	opts := &connection.TransferOptions{Progress: connection.TransferProgressFromContext(ctx)}
+	err := connection.FetchFileWithOptions(conn, srcStr, flatDest, opts)
 	if err != nil {
 		return nil, fmt.Errorf("获取文件失败: %w", err)
 	}
@@ -49,6 +49,7 @@ func (e *FileExecutor) Execute(ctx context.Context, task *models.Task, conn conn
 	// 根据操作类型执行不同的命令
 	var cmdStr string
 	var changed bool
+	var steps []connection.PipelineStep
 
 	switch state {
 	case "absent":
@@ -70,6 +71,7 @@ func (e *FileExecutor) Execute(ctx context.Context, task *models.Task, conn conn
 	default:
 		return nil, fmt.Errorf("不支持的state类型: %s", state)
 	}
+	steps = append(steps, connection.PipelineStep{Name: state, Command: cmdStr})
 
 	// 处理权限设置
 	if mode, ok := task.Spec.Args["mode"]; ok {
@@ -82,14 +84,14 @@ func (e *FileExecutor) Execute(ctx context.Context, task *models.Task, conn conn
 		default:
 			return nil, fmt.Errorf("mode参数必须是字符串或整数类型")
 		}
-		cmdStr = fmt.Sprintf("%s && chmod %s %s", cmdStr, modeStr, pathStr)
+		steps = append(steps, connection.PipelineStep{Name: "chmod", Command: fmt.Sprintf("chmod %s %s", modeStr, pathStr)})
 		changed = true
 	}
 
 	// 处理所有者设置
 	if owner, ok := task.Spec.Args["owner"]; ok {
 		if ownerStr, ok := owner.(string); ok && ownerStr != "" {
-			cmdStr = fmt.Sprintf("%s && chown %s %s", cmdStr, ownerStr, pathStr)
+			steps = append(steps, connection.PipelineStep{Name: "chown", Command: fmt.Sprintf("chown %s %s", ownerStr, pathStr)})
 			changed = true
 		}
 	}
@@ -97,16 +99,17 @@ func (e *FileExecutor) Execute(ctx context.Context, task *models.Task, conn conn
 	// 处理组设置
 	if group, ok := task.Spec.Args["group"]; ok {
 		if groupStr, ok := group.(string); ok && groupStr != "" {
-			cmdStr = fmt.Sprintf("%s && chgrp %s %s", cmdStr, groupStr, pathStr)
+			steps = append(steps, connection.PipelineStep{Name: "chgrp", Command: fmt.Sprintf("chgrp %s %s", groupStr, pathStr)})
 			changed = true
 		}
 	}
 
-	// 执行命令
-	result, err := conn.ExecuteCommand(cmdStr)
+	// 执行所有步骤，只使用一个会话
+	result, err := connection.ExecutePipeline(ctx, conn, steps)
 	if err != nil {
 		return nil, fmt.Errorf("执行文件操作失败: %w", err)
 	}
+	cmdStr = connection.JoinSteps(steps)
 
 	// 计算执行时间
 	duration := time.Since(startTime)
@@ -126,6 +129,9 @@ func (e *FileExecutor) Execute(ctx context.Context, task *models.Task, conn conn
 	taskResult.Extra["path"] = pathStr
 	taskResult.Extra["state"] = state
 	taskResult.Extra["command"] = cmdStr
+	if result.FailedStep != "" {
+		taskResult.Extra["failed_step"] = result.FailedStep
+	}
 
 	return taskResult, nil
 }
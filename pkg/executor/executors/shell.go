@@ -46,11 +46,16 @@ func (e *ShellExecutor) Execute(ctx context.Context, task *models.Task, conn con
 	// 构建完整命令
 	cmdStr := fmt.Sprintf("%s -c '%s'", shellType, escapeQuotes(scriptStr))
 
+	opts, err := commandOptions(ctx, task, varStore)
+	if err != nil {
+		return nil, err
+	}
+
 	// 记录开始时间
 	startTime := time.Now()
 
 	// 执行命令
-	result, err := conn.ExecuteCommand(cmdStr)
+	result, err := connection.ExecuteCommandWithOptions(conn, cmdStr, opts)
 	if err != nil {
 		return nil, fmt.Errorf("执行Shell脚本失败: %w", err)
 	}
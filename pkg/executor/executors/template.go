@@ -96,35 +96,15 @@ func (e *TemplateExecutor) Execute(ctx context.Context, task *models.Task, conn
 	}
 	tmpFile.Close()
 
-	// 复制文件到远程主机
-	err = conn.CopyFile(tmpFile.Name(), destStr)
-	if err != nil {
-		return nil, fmt.Errorf("复制文件到远程主机失败: %w", err)
+	// 临时文件默认权限为0600，未指定mode时远程文件使用0644
+	if err := os.Chmod(tmpFile.Name(), 0644); err != nil {
+		return nil, fmt.Errorf("设置临时文件权限失败: %w", err)
 	}
 
-	// 处理权限设置
-	var cmdStr string
-	if mode, ok := task.Spec.Args["mode"]; ok {
-		var modeStr string
-		switch v := mode.(type) {
-		case string:
-			modeStr = v
-		case int:
-			modeStr = fmt.Sprintf("%o", v)
-		default:
-			return nil, fmt.Errorf("mode参数必须是字符串或整数类型")
-		}
-		cmdStr = fmt.Sprintf("chmod %s %s", modeStr, destStr)
-		
-		// 执行命令
-		result, err := conn.ExecuteCommand(cmdStr)
-		if err != nil {
-			return nil, fmt.Errorf("设置文件权限失败: %w", err)
-		}
-		
-		if result.ExitCode != 0 {
-			return nil, fmt.Errorf("设置文件权限失败: %s", result.Stderr)
-		}
+	// 复制文件到远程主机并设置权限
+	err = copyFileToRemote(ctx, task, conn, tmpFile.Name(), destStr)
+	if err != nil {
+		return nil, fmt.Errorf("复制文件到远程主机失败: %w", err)
 	}
 
 	// 计算执行时间
@@ -0,0 +1,87 @@
+package executor
+
+import (
+	"context"
+	"sync"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/connection"
+	"github.com/ape902/ansible-go/pkg/executor/models"
+)
+
+// notifications 记录每个主机上被触发的处理器
+type notifications struct {
+	mutex sync.Mutex
+	hosts map[string]map[string]bool // 键为主机，值为处理器名称集合
+}
+
+// newNotifications 创建新的处理器触发记录
+func newNotifications() *notifications {
+	return &notifications{hosts: make(map[string]map[string]bool)}
+}
+
+// notify 记录某主机上被触发的处理器
+func (n *notifications) notify(host string, handlers []string) {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	if n.hosts[host] == nil {
+		n.hosts[host] = make(map[string]bool)
+	}
+	for _, name := range handlers {
+		n.hosts[host][name] = true
+	}
+}
+
+// isNotified 判断某主机上的处理器是否被触发
+func (n *notifications) isNotified(host, handler string) bool {
+	n.mutex.Lock()
+	defer n.mutex.Unlock()
+	return n.hosts[host][handler]
+}
+
+// runHandlers 在所有任务结束后，按声明顺序在被触发的主机上执行处理器
+// 同一处理器在一个主机上被多个任务触发时只执行一次，不可达的主机跳过
+func (e *Executor) runHandlers(runCtx context.Context, taskConfig *types.TaskConfig, hosts []string, ctx *models.TaskContext, state *runState, notified *notifications) []error {
+	var errs []error
+
+	for _, handler := range taskConfig.Handlers {
+		for _, host := range hosts {
+			if runCtx.Err() != nil {
+				return errs
+			}
+			if !notified.isNotified(host, handler.Name) || state.isUnreachable(host) {
+				continue
+			}
+
+			task := &models.Task{
+				ID: handler.Name,
+				Spec: &types.TaskSpec{
+					Name:   handler.Name,
+					Module: handler.Module,
+					Args:   handler.Args,
+				},
+				Status:   models.TaskStatusPending,
+				Priority: models.TaskPriorityNormal,
+				Host:     host,
+				Vars:     make(map[string]interface{}),
+			}
+
+			e.emit(&Event{Type: EventHandlerRun, Host: host, Handler: handler.Name, Module: handler.Module})
+
+			taskExecCtx := context.WithValue(runCtx, "taskContext", ctx)
+			taskExecCtx = context.WithValue(taskExecCtx, "engine", e.engine)
+			taskExecCtx = e.withTransferProgress(taskExecCtx, host, task.ID)
+			taskExecCtx = e.withOutput(taskExecCtx, host, task.ID)
+			taskExecCtx = connection.WithPipelining(taskExecCtx, e.config.SSH.Pipelining)
+			err := e.executeTask(taskConfig, task, ctx, taskExecCtx)
+			status := e.reportResult(state, task, err, handler.Name)
+			if status == ResultStatusUnreachable {
+				e.markUnreachable(state, host, err)
+			} else if err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+
+	return errs
+}
@@ -0,0 +1,94 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/executor/connection"
+	"golang.org/x/crypto/ssh"
+)
+
+// HostKeyResult 定义扫描并记录单个主机密钥的结果
+type HostKeyResult struct {
+	Host        string                      // 清单中的主机名
+	Address     string                      // 实际连接地址和端口
+	KeyType     string                      // 主机密钥类型
+	Fingerprint string                      // 主机密钥SHA256指纹
+	Status      connection.HostKeyPinStatus // 记录结果
+	Error       error                       // 错误信息
+}
+
+// PinHostKeys 扫描清单中所有SSH主机的主机密钥并记录到known_hosts文件
+// 已记录的密钥与扫描结果不一致时，只有replace为true才替换记录
+// 返回按主机名排序的结果
+func (e *Executor) PinHostKeys(replace bool) []*HostKeyResult {
+	hosts := e.inventoryHosts()
+	results := make([]*HostKeyResult, len(hosts))
+
+	parallel := e.config.SSH.MaxParallel
+	if parallel <= 0 {
+		parallel = 5
+	}
+	timeout := time.Duration(e.config.SSH.Timeout) * time.Second
+
+	connManager := e.engine.GetConnectionManager()
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallel)
+	for i, host := range hosts {
+		result := &HostKeyResult{Host: host}
+		results[i] = result
+
+		params, err := connManager.ResolveHostParams(e.resolveHost(host))
+		if err != nil {
+			result.Error = err
+			continue
+		}
+		result.Address = params.Addr()
+		if params.Type != connection.ConnectionTypeSSH {
+			continue
+		}
+		// 经过跳板机的主机无法直接扫描，使用tofu模式在首次连接时记录
+		if len(params.JumpHosts) > 0 {
+			result.Error = fmt.Errorf("主机需要经过跳板机连接，无法直接扫描，请使用ssh.host_key_checking: tofu")
+			continue
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			key, err := connection.ScanHostKey(params.Addr(), params.Port, params.Proxy, timeout)
+			if err != nil {
+				result.Error = err
+				return
+			}
+			result.KeyType = key.Type()
+			result.Fingerprint = ssh.FingerprintSHA256(key)
+			result.Status, result.Error = connection.PinHostKey(&e.config.SSH, params.Addr(), params.Port, key, replace)
+		}()
+	}
+	wg.Wait()
+
+	return results
+}
+
+// inventoryHosts 获取清单中去重并排序后的所有主机
+func (e *Executor) inventoryHosts() []string {
+	seen := make(map[string]bool)
+	hosts := make([]string, 0)
+	for _, group := range e.config.Inventory {
+		for _, info := range group {
+			if info.Host != "" && !seen[info.Host] {
+				seen[info.Host] = true
+				hosts = append(hosts, info.Host)
+			}
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
@@ -0,0 +1,173 @@
+package executor
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+
+	"github.com/ape902/ansible-go/pkg/config"
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/connection"
+)
+
+// 主机变量和组变量中可以覆盖连接参数的变量名
+const (
+	varHost           = "ansible_host"                 // 实际连接地址
+	varPort           = "ansible_port"                 // 端口号
+	varUser           = "ansible_user"                 // 用户名
+	varPassword       = "ansible_password"             // 密码
+	varSSHPass        = "ansible_ssh_pass"             // 密码（兼容写法）
+	varKeyFile        = "ansible_ssh_private_key_file" // 私钥文件路径
+	varCertFile       = "ansible_ssh_cert_file"        // 与私钥配对的证书文件
+	varKeyPassword    = "ansible_ssh_private_key_pass" // 私钥密码
+	varConnectionType = "ansible_connection"           // 连接类型
+	varForwardAgent   = "ansible_ssh_forward_agent"    // 是否转发ssh-agent
+	varBecomePassword = "ansible_become_password"      // 提权密码
+	varBecomePass     = "ansible_become_pass"          // 提权密码（兼容写法）
+)
+
+// resolveHost 根据清单解析主机的连接参数
+// 优先级从高到低: 主机变量 > 清单中的主机字段 > 组变量
+// 清单中未设置的地址、端口、用户和跳板机由连接管理器依次使用ssh_config和全局SSH配置补全
+// 未指定连接类型的localhost和回环地址使用本地连接
+// docker连接的主机名或ansible_host为容器名称，未设置用户时使用docker配置中的默认用户
+// chroot和nspawn连接的主机名或ansible_host为根目录
+func (e *Executor) resolveHost(host string) *connection.HostParams {
+	params := &connection.HostParams{
+		Host:         host,
+		ForwardAgent: e.config.SSH.ForwardAgent,
+	}
+
+	// 按组名排序，保证同一主机出现在多个组中时结果稳定
+	groups := make([]string, 0, len(e.config.Inventory))
+	for group := range e.config.Inventory {
+		groups = append(groups, group)
+	}
+	sort.Strings(groups)
+
+	var hostInfo *types.HostInfo
+	for _, group := range groups {
+		for i := range e.config.Inventory[group] {
+			info := &e.config.Inventory[group][i]
+			if info.Host != host {
+				continue
+			}
+			if hostInfo == nil {
+				hostInfo = info
+			}
+			applyConnectionVars(params, e.config.GroupVars[group])
+			break
+		}
+	}
+
+	if hostInfo != nil {
+		if hostInfo.Port > 0 {
+			params.Port = hostInfo.Port
+		}
+		if hostInfo.ConnectionType != "" {
+			params.Type = connection.ConnectionType(hostInfo.ConnectionType)
+		}
+		if hostInfo.User != "" {
+			params.User = hostInfo.User
+		}
+		if hostInfo.Password != "" {
+			params.Password = hostInfo.Password
+		}
+		if hostInfo.KeyFile != "" {
+			params.KeyFile = hostInfo.KeyFile
+		}
+		if hostInfo.CertFile != "" {
+			params.CertFile = hostInfo.CertFile
+		}
+		if hostInfo.KeyPassword != "" {
+			params.KeyPassword = hostInfo.KeyPassword
+		}
+		if hostInfo.ForwardAgent != nil {
+			params.ForwardAgent = *hostInfo.ForwardAgent
+		}
+		if hostInfo.JumpHosts != nil {
+			params.JumpHosts = hostInfo.JumpHosts
+		}
+		applyConnectionVars(params, hostInfo.Vars)
+	}
+	applyConnectionVars(params, e.config.HostVars[host])
+
+	if params.Type == "" {
+		params.Type = params.DefaultType()
+	}
+	if params.Type == connection.ConnectionTypeDocker {
+		if params.User == "" {
+			params.User = e.config.Docker.User
+		}
+		params.DockerBinary = e.config.Docker.Binary
+	}
+
+	return params
+}
+
+// applyConnectionVars 使用ansible_*变量覆盖连接参数
+func applyConnectionVars(params *connection.HostParams, vars map[string]interface{}) {
+	if len(vars) == 0 {
+		return
+	}
+
+	if v, ok := stringVar(vars, varHost); ok {
+		params.Address = v
+	}
+	if v, ok := vars[varPort]; ok {
+		if port, err := strconv.Atoi(fmt.Sprintf("%v", v)); err == nil && port > 0 {
+			params.Port = port
+		}
+	}
+	if v, ok := stringVar(vars, varUser); ok {
+		params.User = v
+	}
+	if v, ok := stringVar(vars, varSSHPass); ok {
+		params.Password = v
+	}
+	if v, ok := stringVar(vars, varPassword); ok {
+		params.Password = v
+	}
+	if v, ok := stringVar(vars, varKeyFile); ok {
+		params.KeyFile = v
+	}
+	if v, ok := stringVar(vars, varCertFile); ok {
+		params.CertFile = v
+	}
+	if v, ok := stringVar(vars, varKeyPassword); ok {
+		params.KeyPassword = v
+	}
+	if v, ok := stringVar(vars, varConnectionType); ok {
+		params.Type = connection.ConnectionType(v)
+	}
+	// 格式错误的跳板机配置在配置验证时报告，这里忽略
+	if v, ok := stringVar(vars, config.ProxyVar); ok {
+		params.Proxy = v
+	}
+	if v, ok := vars[config.JumpHostsVar]; ok {
+		if hops, err := config.ParseJumpHosts(v); err == nil && hops != nil {
+			params.JumpHosts = hops
+		}
+	}
+	if v, ok := stringVar(vars, varBecomePass); ok {
+		params.BecomePassword = v
+	}
+	if v, ok := stringVar(vars, varBecomePassword); ok {
+		params.BecomePassword = v
+	}
+	if v, ok := stringVar(vars, varForwardAgent); ok {
+		if forward, err := strconv.ParseBool(v); err == nil {
+			params.ForwardAgent = forward
+		}
+	}
+}
+
+// stringVar 获取非空的字符串变量
+func stringVar(vars map[string]interface{}, key string) (string, bool) {
+	v, ok := vars[key]
+	if !ok || v == nil {
+		return "", false
+	}
+	s := fmt.Sprintf("%v", v)
+	return s, s != ""
+}
@@ -42,20 +42,22 @@ type Task struct {
 	Result      *TaskResult           // 执行结果
 	Error       error                 // 错误信息
 	FilePath    string                 // 任务文件路径
+	Become      *types.BecomeConfig    // 提权参数，为nil时不提权
+	Environment map[string]string      // 执行命令时设置的环境变量
 }
 
 // TaskResult 定义任务执行结果
 type TaskResult struct {
-	ExitCode      int                        // 退出码
-	Stdout        string                     // 标准输出
-	Stderr        string                     // 标准错误
-	ImportedTasks []map[string]types.TaskSpec // 导入的任务列表
-	Changed     bool              // 是否发生变更
-	Failed      bool              // 是否失败
-	Skipped     bool              // 是否跳过
-	Unreachable bool              // 是否不可达
-	Duration    time.Duration     // 执行时长
-	Extra       map[string]string // 额外信息
+	ExitCode      int                         `json:"exit_code"`                // 退出码
+	Stdout        string                      `json:"stdout,omitempty"`         // 标准输出
+	Stderr        string                      `json:"stderr,omitempty"`         // 标准错误
+	ImportedTasks []map[string]types.TaskSpec `json:"imported_tasks,omitempty"` // 导入的任务列表
+	Changed       bool                        `json:"changed"`                  // 是否发生变更
+	Failed        bool                        `json:"failed"`                   // 是否失败
+	Skipped       bool                        `json:"skipped"`                  // 是否跳过
+	Unreachable   bool                        `json:"unreachable"`              // 是否不可达
+	Duration      time.Duration               `json:"duration"`                 // 执行时长
+	Extra         map[string]string           `json:"extra,omitempty"`          // 额外信息
 }
 
 // TaskQueue 定义任务队列接口
@@ -0,0 +1,193 @@
+package executor
+
+import (
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/executor/models"
+)
+
+// TaskRecord 定义单个任务在单个主机上的执行记录
+type TaskRecord struct {
+	Host      string             `json:"host"`              // 执行主机
+	Task      string             `json:"task"`              // 任务ID
+	Module    string             `json:"module"`            // 任务模块
+	Handler   string             `json:"handler,omitempty"` // 处理器名称，普通任务为空
+	Status    ResultStatus       `json:"status"`            // 结果状态
+	Result    *models.TaskResult `json:"result,omitempty"`  // 执行结果
+	Error     string             `json:"error,omitempty"`   // 错误信息
+	StartTime time.Time          `json:"start_time"`        // 开始时间
+	EndTime   time.Time          `json:"end_time"`          // 结束时间
+}
+
+// HostResult 定义单个主机的执行结果
+type HostResult struct {
+	Host        string        `json:"host"`        // 主机
+	Unreachable bool          `json:"unreachable"` // 是否不可达，不可达主机不再执行后续任务
+	Stats       HostStats     `json:"stats"`       // 执行统计
+	Tasks       []*TaskRecord `json:"tasks"`       // 按完成顺序排列的任务记录
+}
+
+// RunResult 定义一次playbook运行的结构化结果
+type RunResult struct {
+	Playbook  string                 `json:"playbook,omitempty"`   // playbook文件路径，内存中构建的playbook为空
+	Play      string                 `json:"play"`                 // play名称
+	Hosts     map[string]*HostResult `json:"hosts"`                // 各主机的执行结果
+	AuditFile string                 `json:"audit_file,omitempty"` // 审计文件路径，未启用审计时为空
+	StartTime time.Time              `json:"start_time"`           // 开始时间
+	EndTime   time.Time              `json:"end_time"`             // 结束时间
+}
+
+// Failed 判断是否有主机执行失败或不可达
+func (r *RunResult) Failed() bool {
+	for _, host := range r.Hosts {
+		if host.Stats.Failed > 0 || host.Stats.Unreachable > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// Stats 获取各主机的执行统计
+func (r *RunResult) Stats() map[string]*HostStats {
+	stats := make(map[string]*HostStats, len(r.Hosts))
+	for name, host := range r.Hosts {
+		s := host.Stats
+		stats[name] = &s
+	}
+	return stats
+}
+
+// RetryHosts 获取执行失败或不可达的主机列表，按主机名排序
+func (r *RunResult) RetryHosts() []string {
+	hosts := make([]string, 0)
+	for name, host := range r.Hosts {
+		if host.Unreachable || host.Stats.Failed > 0 || host.Stats.Unreachable > 0 {
+			hosts = append(hosts, name)
+		}
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// writeRetryFile 将需要重试的主机逐行写入重试文件，没有需要重试的主机时删除旧文件
+func writeRetryFile(path string, result *RunResult) error {
+	hosts := result.RetryHosts()
+	if len(hosts) == 0 {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	return os.WriteFile(path, []byte(strings.Join(hosts, "\n")+"\n"), 0644)
+}
+
+// runState 保存单次运行过程中的可变状态
+type runState struct {
+	mutex       sync.Mutex
+	result      *RunResult
+	unreachable map[string]bool // 不可达的主机
+}
+
+// newRunState 创建新的运行状态
+func newRunState(playbookPath, play string) *runState {
+	return &runState{
+		result: &RunResult{
+			Playbook:  playbookPath,
+			Play:      play,
+			Hosts:     make(map[string]*HostResult),
+			StartTime: time.Now(),
+		},
+		unreachable: make(map[string]bool),
+	}
+}
+
+// addHosts 登记参与运行的主机
+func (s *runState) addHosts(hosts []string) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	for _, host := range hosts {
+		if _, ok := s.result.Hosts[host]; !ok {
+			s.result.Hosts[host] = &HostResult{Host: host, Tasks: make([]*TaskRecord, 0)}
+		}
+	}
+}
+
+// record 记录任务执行结果并更新主机统计
+func (s *runState) record(rec *TaskRecord) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	host, ok := s.result.Hosts[rec.Host]
+	if !ok {
+		host = &HostResult{Host: rec.Host, Tasks: make([]*TaskRecord, 0)}
+		s.result.Hosts[rec.Host] = host
+	}
+	host.Stats.record(rec.Status)
+	host.Tasks = append(host.Tasks, rec)
+}
+
+// markUnreachable 记录主机不可达，返回该主机是否为首次标记
+func (s *runState) markUnreachable(host string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.unreachable[host] {
+		return false
+	}
+	s.unreachable[host] = true
+
+	h, ok := s.result.Hosts[host]
+	if !ok {
+		h = &HostResult{Host: host, Tasks: make([]*TaskRecord, 0)}
+		s.result.Hosts[host] = h
+	}
+	// 任务执行中发现的不可达已计入统计
+	if h.Stats.Unreachable == 0 {
+		h.Stats.record(ResultStatusUnreachable)
+	}
+	h.Unreachable = true
+	return true
+}
+
+// isUnreachable 判断主机是否已不可达
+func (s *runState) isUnreachable(host string) bool {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	return s.unreachable[host]
+}
+
+// reachableHosts 过滤掉不可达的主机
+func (s *runState) reachableHosts(hosts []string) []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	result := make([]string, 0, len(hosts))
+	for _, host := range hosts {
+		if !s.unreachable[host] {
+			result = append(result, host)
+		}
+	}
+	return result
+}
+
+// unreachableHosts 获取已排序的不可达主机列表
+func (s *runState) unreachableHosts() []string {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	hosts := make([]string, 0, len(s.unreachable))
+	for host := range s.unreachable {
+		hosts = append(hosts, host)
+	}
+	sort.Strings(hosts)
+	return hosts
+}
+
+// finish 结束运行并返回结果
+func (s *runState) finish() *RunResult {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.result.EndTime = time.Now()
+	return s.result
+}
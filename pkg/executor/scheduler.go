@@ -0,0 +1,135 @@
+package executor
+
+import (
+	"sync"
+
+	"github.com/ape902/ansible-go/pkg/executor/models"
+)
+
+// scheduler 按主机维护有序的任务队列，只把可以立即执行的任务分派给工作协程：
+//  1. 同一主机上的任务按加入顺序（即playbook顺序）开始执行
+//  2. 同一主机同一时间最多只有一个任务在执行，相邻的设置了allow_concurrent的任务可以并发执行
+//  3. 设置了throttle的任务在所有主机上的并发数不超过throttle，配额用完时该主机的后续任务继续排队
+//
+// 工作协程不会为了等待某个主机空闲而阻塞，其他主机的任务可以继续执行
+type scheduler struct {
+	mutex      sync.Mutex
+	ready      *sync.Cond
+	hosts      []string                  // 按首次加入顺序排列的主机
+	queues     map[string][]*models.Task // 各主机等待执行的任务
+	exclusive  map[string]bool           // 正在执行独占任务的主机
+	concurrent map[string]int            // 各主机上正在执行的allow_concurrent任务数
+	throttles  map[string]int            // 各throttle键正在执行的任务数
+	pending    int                       // 等待执行的任务数
+	active     int                       // 正在执行的任务数
+}
+
+// newScheduler 创建新的调度器
+func newScheduler() *scheduler {
+	s := &scheduler{
+		queues:     make(map[string][]*models.Task),
+		exclusive:  make(map[string]bool),
+		concurrent: make(map[string]int),
+		throttles:  make(map[string]int),
+	}
+	s.ready = sync.NewCond(&s.mutex)
+	return s
+}
+
+// add 将任务加入所属主机队列的末尾
+func (s *scheduler) add(task *models.Task) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.queues[task.Host]; !ok {
+		s.hosts = append(s.hosts, task.Host)
+	}
+	s.queues[task.Host] = append(s.queues[task.Host], task)
+	s.pending++
+	s.ready.Broadcast()
+}
+
+// next 阻塞直到有任务可以执行，所有任务都已执行完成时返回false
+// 返回的任务执行结束后必须调用done
+func (s *scheduler) next() (*models.Task, bool) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	for {
+		if task := s.dispatchLocked(); task != nil {
+			return task, true
+		}
+		// 正在执行的任务可能加入导入的任务，全部结束后才算完成
+		if s.pending == 0 && s.active == 0 {
+			return nil, false
+		}
+		s.ready.Wait()
+	}
+}
+
+// done 标记任务执行结束，释放主机和throttle配额
+func (s *scheduler) done(task *models.Task) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if task.Spec.AllowConcurrent {
+		s.concurrent[task.Host]--
+	} else {
+		delete(s.exclusive, task.Host)
+	}
+	if key, limit := throttleKey(task); limit > 0 {
+		s.throttles[key]--
+	}
+	s.active--
+	s.ready.Broadcast()
+}
+
+// dispatchLocked 取出第一个队首任务可以执行的主机的队首任务，没有时返回nil
+func (s *scheduler) dispatchLocked() *models.Task {
+	for _, host := range s.hosts {
+		queue := s.queues[host]
+		if len(queue) == 0 || !s.runnableLocked(queue[0]) {
+			continue
+		}
+
+		task := queue[0]
+		queue[0] = nil
+		s.queues[host] = queue[1:]
+		s.pending--
+		s.active++
+
+		if task.Spec.AllowConcurrent {
+			s.concurrent[host]++
+		} else {
+			s.exclusive[host] = true
+		}
+		if key, limit := throttleKey(task); limit > 0 {
+			s.throttles[key]++
+		}
+		return task
+	}
+	return nil
+}
+
+// runnableLocked 判断主机的队首任务是否可以开始执行
+func (s *scheduler) runnableLocked(task *models.Task) bool {
+	if s.exclusive[task.Host] {
+		return false
+	}
+	if !task.Spec.AllowConcurrent && s.concurrent[task.Host] > 0 {
+		return false
+	}
+	if key, limit := throttleKey(task); limit > 0 && s.throttles[key] >= limit {
+		return false
+	}
+	return true
+}
+
+// throttleKey 获取任务的throttle键和并发上限，未设置throttle时上限为0
+// 同一任务文件中的同名任务在所有主机上共享同一配额
+func throttleKey(task *models.Task) (string, int) {
+	if task.Spec.Throttle <= 0 {
+		return "", 0
+	}
+	return task.FilePath + "#" + task.ID, task.Spec.Throttle
+}
@@ -0,0 +1,164 @@
+package executor
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ape902/ansible-go/pkg/config/types"
+	"github.com/ape902/ansible-go/pkg/executor/models"
+)
+
+func newSchedulerTask(id, host string, spec types.TaskSpec) *models.Task {
+	return &models.Task{ID: id, Host: host, Spec: &spec, FilePath: "play.yml"}
+}
+
+// runScheduler 使用workers个工作协程执行调度器中的任务，返回每个主机上任务的开始顺序
+func runScheduler(s *scheduler, workers int, run func(task *models.Task)) map[string][]string {
+	var mutex sync.Mutex
+	started := make(map[string][]string)
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				task, ok := s.next()
+				if !ok {
+					return
+				}
+				mutex.Lock()
+				started[task.Host] = append(started[task.Host], task.ID)
+				mutex.Unlock()
+				run(task)
+				s.done(task)
+			}
+		}()
+	}
+	wg.Wait()
+	return started
+}
+
+func TestSchedulerRunsHostTasksInOrder(t *testing.T) {
+	s := newScheduler()
+	for i := 0; i < 20; i++ {
+		for _, host := range []string{"a", "b", "c"} {
+			s.add(newSchedulerTask(fmt.Sprintf("t%02d", i), host, types.TaskSpec{}))
+		}
+	}
+
+	var mutex sync.Mutex
+	running := make(map[string]int)
+	started := runScheduler(s, 8, func(task *models.Task) {
+		mutex.Lock()
+		running[task.Host]++
+		if running[task.Host] > 1 {
+			t.Errorf("host %s runs %d tasks at once", task.Host, running[task.Host])
+		}
+		mutex.Unlock()
+		time.Sleep(time.Millisecond)
+		mutex.Lock()
+		running[task.Host]--
+		mutex.Unlock()
+	})
+
+	for host, ids := range started {
+		for i, id := range ids {
+			if want := fmt.Sprintf("t%02d", i); id != want {
+				t.Fatalf("host %s started %v, want playbook order", host, ids)
+			}
+		}
+	}
+}
+
+func TestSchedulerBusyHostDoesNotBlockOthers(t *testing.T) {
+	s := newScheduler()
+	release := make(chan struct{})
+	s.add(newSchedulerTask("slow", "a", types.TaskSpec{}))
+	s.add(newSchedulerTask("next", "a", types.TaskSpec{}))
+	for i := 0; i < 5; i++ {
+		s.add(newSchedulerTask(fmt.Sprintf("t%d", i), "b", types.TaskSpec{}))
+	}
+
+	done := make(chan map[string][]string)
+	var once sync.Once
+	go func() {
+		done <- runScheduler(s, 2, func(task *models.Task) {
+			if task.ID == "slow" {
+				<-release
+			}
+			if task.Host == "b" && task.ID == "t4" {
+				once.Do(func() { close(release) })
+			}
+		})
+	}()
+
+	select {
+	case started := <-done:
+		if len(started["b"]) != 5 || len(started["a"]) != 2 {
+			t.Fatalf("unexpected tasks started: %v", started)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("tasks on host b waited for the busy host a")
+	}
+}
+
+func TestSchedulerThrottle(t *testing.T) {
+	s := newScheduler()
+	for _, host := range []string{"a", "b", "c", "d", "e", "f"} {
+		s.add(newSchedulerTask("limited", host, types.TaskSpec{Throttle: 2}))
+	}
+
+	var mutex sync.Mutex
+	current, peak := 0, 0
+	runScheduler(s, 6, func(task *models.Task) {
+		mutex.Lock()
+		current++
+		if current > peak {
+			peak = current
+		}
+		mutex.Unlock()
+		time.Sleep(5 * time.Millisecond)
+		mutex.Lock()
+		current--
+		mutex.Unlock()
+	})
+
+	if peak != 2 {
+		t.Fatalf("throttled task peaked at %d concurrent runs, want 2", peak)
+	}
+}
+
+func TestSchedulerAllowConcurrent(t *testing.T) {
+	s := newScheduler()
+	s.add(newSchedulerTask("c1", "a", types.TaskSpec{AllowConcurrent: true}))
+	s.add(newSchedulerTask("c2", "a", types.TaskSpec{AllowConcurrent: true}))
+	s.add(newSchedulerTask("x", "a", types.TaskSpec{}))
+
+	var mutex sync.Mutex
+	current, peak := 0, 0
+	var exclusiveOverlap bool
+	runScheduler(s, 3, func(task *models.Task) {
+		mutex.Lock()
+		current++
+		if current > peak {
+			peak = current
+		}
+		if task.ID == "x" && current > 1 {
+			exclusiveOverlap = true
+		}
+		mutex.Unlock()
+		time.Sleep(10 * time.Millisecond)
+		mutex.Lock()
+		current--
+		mutex.Unlock()
+	})
+
+	if peak != 2 {
+		t.Fatalf("allow_concurrent tasks peaked at %d, want 2", peak)
+	}
+	if exclusiveOverlap {
+		t.Fatal("exclusive task overlapped allow_concurrent tasks")
+	}
+}
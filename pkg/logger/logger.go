@@ -2,6 +2,7 @@ package logger
 
 import (
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
@@ -55,6 +56,15 @@ func New() *Logger {
 	}
 }
 
+// NewWithWriter 创建输出到指定位置的日志记录器
+func NewWithWriter(w io.Writer) *Logger {
+	return &Logger{
+		logger:      log.New(w, "", 0),
+		indent:      0,
+		verboseMode: false,
+	}
+}
+
 // SetVerboseMode 设置详细模式
 func (l *Logger) SetVerboseMode(verbose bool) {
 	l.verboseMode = verbose
@@ -148,4 +158,13 @@ func (l *Logger) Output(host, taskID, output string) {
 	
 	// 添加任务输出结束标记
 	l.logger.Printf("%s└─────%s", hostColor, ColorReset)
-}
\ No newline at end of file
+}
+
+// OutputLine 打印命令执行过程中的一行输出，以主机和任务作为前缀，标准错误使用红色显示
+func (l *Logger) OutputLine(host, taskID, line string, stderr bool) {
+	lineColor := ColorReset
+	if stderr {
+		lineColor = ColorRed
+	}
+	l.logger.Printf("%s[%s]%s %s%s%s │ %s%s%s", ColorCyan, host, ColorReset, ColorYellow, taskID, ColorReset, lineColor, line, ColorReset)
+}
@@ -12,6 +12,9 @@ import (
 	"strings"
 )
 
+// EncryptedPrefix 加密变量值的前缀
+const EncryptedPrefix = "!ENCRYPTED:"
+
 // SecurityManager 定义变量安全管理器
 type SecurityManager struct {
 	encryptionKey []byte
@@ -113,6 +116,28 @@ func (sm *SecurityManager) Decrypt(encrypted string) (string, error) {
 	return string(plaintext), nil
 }
 
+// EncryptValue 加密变量值并添加加密前缀，结果可以直接写入配置文件
+func (sm *SecurityManager) EncryptValue(plaintext string) (string, error) {
+	encrypted, err := sm.Encrypt(plaintext)
+	if err != nil {
+		return "", err
+	}
+	return EncryptedPrefix + encrypted, nil
+}
+
+// DecryptValue 解密带加密前缀的变量值，没有前缀的值原样返回
+func (sm *SecurityManager) DecryptValue(value string) (string, error) {
+	if !IsEncrypted(value) {
+		return value, nil
+	}
+	return sm.Decrypt(strings.TrimPrefix(value, EncryptedPrefix))
+}
+
+// IsEncrypted 检查变量值是否为加密值
+func IsEncrypted(value string) bool {
+	return strings.HasPrefix(value, EncryptedPrefix)
+}
+
 // SecureVariable 定义安全变量
 type SecureVariable struct {
 	BaseVariable
@@ -147,12 +172,12 @@ func (sv *SecureVariable) GetValue() interface{} {
 		}
 
 		// 检查是否已加密（以!ENCRYPTED:开头）
-		if !strings.HasPrefix(encrypted, "!ENCRYPTED:") {
+		if !IsEncrypted(encrypted) {
 			return sv.Value
 		}
 
 		// 解密
-		encrypted = strings.TrimPrefix(encrypted, "!ENCRYPTED:")
+		encrypted = strings.TrimPrefix(encrypted, EncryptedPrefix)
 		decrypted, err := sv.security.Decrypt(encrypted)
 		if err != nil {
 			// 解密失败，返回原值
@@ -181,7 +206,7 @@ func (sv *SecureVariable) SetValue(value interface{}) error {
 	}
 
 	// 检查是否已加密
-	if strings.HasPrefix(strVal, "!ENCRYPTED:") {
+	if IsEncrypted(strVal) {
 		sv.Value = strVal
 		return nil
 	}
@@ -193,6 +218,6 @@ func (sv *SecureVariable) SetValue(value interface{}) error {
 	}
 
 	// 存储加密后的值
-	sv.Value = "!ENCRYPTED:" + encrypted
+	sv.Value = EncryptedPrefix + encrypted
 	return nil
 }
\ No newline at end of file